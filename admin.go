@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"text/tabwriter"
 
 	"github.com/coreos/updateservicectl/client/update/v1"
@@ -49,9 +48,14 @@ func adminUserCreate(args []string, service *update.Service, out *tabwriter.Writ
 	call := service.Admin.CreateUser(req)
 	u, err := call.Do()
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
+	}
+	err = printResult(out, u, func(out *tabwriter.Writer) {
+		fmt.Fprintln(out, u.Token)
+	})
+	if err != nil {
+		return handleError(err)
 	}
-	fmt.Println(u.Token)
 	return OK
 }
 
@@ -63,9 +67,14 @@ func adminUserDelete(args []string, service *update.Service, out *tabwriter.Writ
 	call := service.Admin.DeleteUser(userName)
 	u, err := call.Do()
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
+	}
+	err = printResult(out, u, func(out *tabwriter.Writer) {
+		fmt.Fprintf(out, "User %s deleted\n", u.User)
+	})
+	if err != nil {
+		return handleError(err)
 	}
-	fmt.Printf("User %s deleted\n", u.User)
 	return OK
 }
 
@@ -73,12 +82,16 @@ func adminUserList(args []string, service *update.Service, out *tabwriter.Writer
 	call := service.Admin.ListUsers()
 	resp, err := call.Do()
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	for _, u := range resp.Users {
-		fmt.Fprintf(out, "%s\n", u.User)
+	err = printResult(out, resp, func(out *tabwriter.Writer) {
+		for _, u := range resp.Users {
+			fmt.Fprintf(out, "%s\n", u.User)
+		}
+	})
+	if err != nil {
+		return handleError(err)
 	}
-	out.Flush()
 	return OK
 }
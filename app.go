@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"text/tabwriter"
 
 	"github.com/pborman/uuid"
@@ -70,20 +69,30 @@ func formatApp(app *update.App) string {
 	return fmt.Sprintf("%s\t%s\t%s\n", app.Id, app.Label, app.Description)
 }
 
+func printApp(out *tabwriter.Writer, app *update.App) error {
+	return printResult(out, app, func(out *tabwriter.Writer) {
+		fmt.Fprint(out, appHeader)
+		fmt.Fprintf(out, "%s", formatApp(app))
+	})
+}
+
 func appList(args []string, service *update.Service, out *tabwriter.Writer) int {
 	listCall := service.App.List()
 	list, err := listCall.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, appHeader)
-	for _, app := range list.Items {
-		fmt.Fprintf(out, "%s", formatApp(app))
+	err = printResult(out, list, func(out *tabwriter.Writer) {
+		fmt.Fprint(out, appHeader)
+		for _, app := range list.Items {
+			fmt.Fprintf(out, "%s", formatApp(app))
+		}
+	})
+	if err != nil {
+		return handleError(err)
 	}
-
-	out.Flush()
 	return OK
 }
 
@@ -101,13 +110,12 @@ func appCreate(args []string, service *update.Service, out *tabwriter.Writer) in
 	app, err := call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, appHeader)
-	fmt.Fprintf(out, "%s", formatApp(app))
-
-	out.Flush()
+	if err := printApp(out, app); err != nil {
+		return handleError(err)
+	}
 	return OK
 
 }
@@ -122,13 +130,12 @@ func appUpdate(args []string, service *update.Service, out *tabwriter.Writer) in
 	app, err := call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, appHeader)
-	fmt.Fprintf(out, "%s", formatApp(app))
-
-	out.Flush()
+	if err := printApp(out, app); err != nil {
+		return handleError(err)
+	}
 	return OK
 
 }
@@ -142,12 +149,11 @@ func appDelete(args []string, service *update.Service, out *tabwriter.Writer) in
 	app, err := call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, appHeader)
-	fmt.Fprintf(out, "%s", formatApp(app))
-
-	out.Flush()
+	if err := printApp(out, app); err != nil {
+		return handleError(err)
+	}
 	return OK
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"text/tabwriter"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+var (
+	applyFlags struct {
+		file  string
+		prune bool
+	}
+
+	cmdApply = &Command{
+		Name:    "apply",
+		Usage:   "[OPTION]...",
+		Summary: "Reconcile apps, channels, groups and rollouts with a manifest.",
+		Description: `Reads a YAML or JSON manifest (--file) describing the desired state of
+apps, channels, groups and rollouts, compares it with the server and makes
+only the calls needed to bring the server in line with it.
+
+Example manifest:
+
+  apps:
+  - id: e96281a6-d1af-4bde-9a0a-97b76e56dc57
+    label: CoreOS
+    channels:
+    - label: stable
+      version: 1688.5.3
+      publish: true
+    groups:
+    - id: production
+      label: Production
+      channel: stable
+      oemBlacklist: [azure]
+      updatePercent: 10
+      paused: false
+      rollout:
+        active: true
+        linear:
+          frameSize: 3600
+          duration: 86400
+
+Groups without paused, updatePercent or rollout keep their current values on
+the server. With --prune, channels and groups of the listed apps which are
+missing from the manifest are deleted.`,
+		Run: apply,
+	}
+)
+
+func init() {
+	cmdApply.Flags.StringVar(&applyFlags.file, "file", "",
+		"Manifest file to apply, or - for stdin.")
+	cmdApply.Flags.StringVar(&applyFlags.file, "f", "",
+		"Shorthand for --file.")
+	cmdApply.Flags.BoolVar(&applyFlags.prune, "prune", false,
+		"Delete channels and groups not listed in the manifest.")
+}
+
+func apply(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if applyFlags.file == "" {
+		return ERROR_USAGE
+	}
+
+	manifest, err := loadManifest(applyFlags.file)
+	if err != nil {
+		log.Print(err)
+		return ERROR_USAGE
+	}
+
+	changes, err := applyManifest(service, manifest, applyFlags.prune)
+	if err != nil {
+		logApplied(changes)
+		return handleError(err)
+	}
+
+	if err := printChanges(out, changes); err != nil {
+		return handleError(err)
+	}
+	return OK
+}
+
+// applyManifest makes the changes needed to bring the server in line with
+// the manifest and returns the changes which were made. If a change fails,
+// the changes made before it are returned with the error.
+func applyManifest(service *update.Service, manifest *Manifest, prune bool) ([]*fleetChange, error) {
+	live, err := fetchLiveState(service, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := planManifest(manifest, live, prune)
+	for i, c := range changes {
+		if err := c.apply(service); err != nil {
+			return changes[:i], fmt.Errorf("%s failed: %w", c, err)
+		}
+	}
+	return changes, nil
+}
+
+// logApplied logs the changes made before a change failed, as the server
+// is left partly updated.
+func logApplied(changes []*fleetChange) {
+	for _, c := range changes {
+		log.Printf("applied: %s", c)
+	}
+}
+
+func printChanges(out *tabwriter.Writer, changes []*fleetChange) error {
+	return printResult(out, changes, func(out *tabwriter.Writer) {
+		if len(changes) == 0 {
+			fmt.Fprintln(out, "nothing to do")
+		}
+		for _, c := range changes {
+			fmt.Fprint(out, formatChange(c))
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"crypto/tls"
 	"net/http"
+	"sync"
 
 	"github.com/coreos/hawk-go"
 )
@@ -11,12 +12,27 @@ import (
 var DefaultHawkHasher = sha256.New
 
 type HawkRoundTripper struct {
-	User          string
-	Token         string
+	User  string
+	Token string
+	// TokenFunc, if set, is called once on the first request for the
+	// token, which is then used instead of Token.
+	TokenFunc     func() (string, error)
 	SkipSSLVerify bool
+
+	once     sync.Once
+	tokenErr error
 }
 
 func (t *HawkRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.TokenFunc != nil {
+		t.once.Do(func() {
+			t.Token, t.tokenErr = t.TokenFunc()
+		})
+		if t.tokenErr != nil {
+			return nil, t.tokenErr
+		}
+	}
+
 	creds := &hawk.Credentials{
 		ID:   t.User,
 		Key:  t.Token,
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"text/tabwriter"
 
 	"github.com/coreos/updateservicectl/client/update/v1"
@@ -83,6 +82,13 @@ func formatChannel(channel *update.AppChannel) string {
 	return fmt.Sprintf("%s\t%s\t%t\t%s\n", channel.Label, channel.Version, channel.Publish, channel.Upstream)
 }
 
+func printChannel(out *tabwriter.Writer, channel *update.AppChannel) error {
+	return printResult(out, channel, func(out *tabwriter.Writer) {
+		fmt.Fprint(out, channelHeader)
+		fmt.Fprintf(out, "%s", formatChannel(channel))
+	})
+}
+
 func channelList(args []string, service *update.Service, out *tabwriter.Writer) int {
 	if channelFlags.appId.Get() == nil {
 		return ERROR_USAGE
@@ -91,13 +97,17 @@ func channelList(args []string, service *update.Service, out *tabwriter.Writer)
 	listCall := service.Channel.List(channelFlags.appId.String())
 	list, err := listCall.Do()
 	if err != nil {
-		log.Fatal(err)
-	}
-	fmt.Fprint(out, channelHeader)
-	for _, channel := range list.Items {
-		fmt.Fprintf(out, "%s", formatChannel(channel))
+		return handleError(err)
+	}
+	err = printResult(out, list, func(out *tabwriter.Writer) {
+		fmt.Fprint(out, channelHeader)
+		for _, channel := range list.Items {
+			fmt.Fprintf(out, "%s", formatChannel(channel))
+		}
+	})
+	if err != nil {
+		return handleError(err)
 	}
-	out.Flush()
 	return OK
 }
 
@@ -116,12 +126,12 @@ func channelCreate(args []string, service *update.Service, out *tabwriter.Writer
 	call := service.Channel.Insert(*channelFlags.channel.Get(), channelReq)
 	channel, err := call.Do()
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, channelHeader)
-	fmt.Fprintf(out, "%s", formatChannel(channel))
-	out.Flush()
+	if err := printChannel(out, channel); err != nil {
+		return handleError(err)
+	}
 	return OK
 }
 
@@ -135,12 +145,12 @@ func channelUpdate(args []string, service *update.Service, out *tabwriter.Writer
 	call := service.Channel.Update(channelFlags.appId.String(), channelFlags.channel.String(), channelReq)
 	channel, err := call.Do()
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, channelHeader)
-	fmt.Fprintf(out, "%s", formatChannel(channel))
-	out.Flush()
+	if err := printChannel(out, channel); err != nil {
+		return handleError(err)
+	}
 	return OK
 }
 
@@ -150,12 +160,16 @@ func channelDelete(args []string, service *update.Service, out *tabwriter.Writer
 	}
 
 	call := service.Channel.Delete(channelFlags.appId.String(), channelFlags.channel.String())
-	_, err := call.Do()
+	channel, err := call.Do()
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprintf(out, "deleted channel: %s, for application: %s\n", channelFlags.channel.String(), channelFlags.appId.String())
-	out.Flush()
+	err = printResult(out, channel, func(out *tabwriter.Writer) {
+		fmt.Fprintf(out, "deleted channel: %s, for application: %s\n", channelFlags.channel.String(), channelFlags.appId.String())
+	})
+	if err != nil {
+		return handleError(err)
+	}
 	return OK
 }
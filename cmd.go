@@ -3,7 +3,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -14,12 +13,20 @@ import (
 	"github.com/coreos/updateservicectl/version"
 )
 
+// Exit codes. New codes must only ever be appended, scripts depend on the
+// values; exitCodes in help.go documents them.
 const (
 	OK = iota
 	// Error Codes
 	ERROR_API
 	ERROR_USAGE
 	ERROR_NO_COMMAND
+	ERROR_DRIFT
+	ERROR_NOT_FOUND
+	ERROR_UNAUTHORIZED
+	ERROR_CONFLICT
+	ERROR_NETWORK
+	ERROR_TIMEOUT
 
 	cliName        = "updateservicectl"
 	cliDescription = "updateservicectl is a command line driven interface to the roller."
@@ -46,6 +53,17 @@ func (f *StringFlag) String() string {
 	return ""
 }
 
+// splitList splits a comma separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 type Command struct {
 	Name        string       // Name of the Command and the string to use to invoke it
 	Summary     string       // One-sentence summary of what the Command does
@@ -69,6 +87,10 @@ var (
 		Version       bool
 		Help          bool
 		SkipSSLVerify bool
+		Output        string
+		Template      string
+		Config        string
+		Context       string
 	}
 )
 
@@ -89,26 +111,54 @@ func init() {
 	globalFlagSet.BoolVar(&globalFlags.SkipSSLVerify, "skip-ssl-verify", false, "Don't check SSL certificates.")
 	globalFlagSet.StringVar(&globalFlags.User, "user", os.Getenv("UPDATECTL_USER"), "API Username")
 	globalFlagSet.StringVar(&globalFlags.Key, "key", os.Getenv("UPDATECTL_KEY"), "API Key")
+	globalFlagSet.StringVar(&globalFlags.Output, "output", outputTable, "Output format: table, json, yaml or template.")
+	globalFlagSet.StringVar(&globalFlags.Template, "template", "", "Go template applied to command results when --output=template.")
+
+	config := defaultConfigPath()
+	if configEnv := os.Getenv("UPDATECTL_CONFIG"); configEnv != "" {
+		config = configEnv
+	}
+	globalFlagSet.StringVar(&globalFlags.Config, "config", config, "Configuration file with named contexts.")
+	globalFlagSet.StringVar(&globalFlags.Context, "context", os.Getenv("UPDATECTL_CONTEXT"), "Context of the configuration file to use, overriding its current context.")
 
 	commands = []*Command{
 		// admin.go
 		cmdAdminUser,
+		// apply.go
+		cmdApply,
 		// app.go
 		cmdApp,
 		// channel.go
 		cmdChannel,
+		// config.go
+		cmdConfig,
 		// database.go
 		cmdDatabase,
+		// diff.go
+		cmdDiff,
+		// export.go
+		cmdExport,
+		cmdImport,
+		// exporter.go
+		cmdExporter,
+		// fleet.go
+		cmdFleet,
 		// group.go
 		cmdGroup,
 		// help.go
 		cmdHelp,
 		// instance.go
 		cmdInstance,
+		// mockserver.go
+		cmdMockServer,
+		// notify.go
+		cmdNotify,
 		// pkg.go
 		cmdPackage,
 		// rollout.go
 		cmdRollout,
+		// top.go
+		cmdTop,
 		// watch.go
 		cmdWatch,
 		// upstream.go
@@ -123,6 +173,7 @@ func getHawkClient(user string, key string) *http.Client {
 		Transport: &auth.HawkRoundTripper{
 			User:          user,
 			Token:         key,
+			TokenFunc:     contextKey,
 			SkipSSLVerify: globalFlags.SkipSSLVerify,
 		},
 	}
@@ -136,7 +187,7 @@ func handle(fn handlerFunc) func(f *flag.FlagSet) int {
 
 		service, err := update.New(client)
 		if err != nil {
-			log.Fatal(err)
+			return handleError(err)
 		}
 
 		service.BasePath = globalFlags.Server + "/_ah/api/update/v1/"
@@ -211,9 +262,10 @@ func main() {
 		os.Exit(OK)
 	}
 
-	// trim the right most slash because all other uses of globalFlags.Server
-	// append the / already
-	globalFlags.Server = strings.TrimRight(globalFlags.Server, "/")
+	if err := validateOutputFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", cliName, err)
+		os.Exit(ERROR_USAGE)
+	}
 
 	cmd, name := findCommand("", args, commands)
 
@@ -223,6 +275,19 @@ func main() {
 		os.Exit(ERROR_NO_COMMAND)
 	}
 
+	// the config commands must keep working with a broken context so it
+	// can be fixed
+	if !strings.HasPrefix(cmd.Name, cmdConfig.Name) {
+		if err := applyContext(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", cliName, err)
+			os.Exit(ERROR_USAGE)
+		}
+	}
+
+	// trim the right most slash because all other uses of globalFlags.Server
+	// append the / already
+	globalFlags.Server = strings.TrimRight(globalFlags.Server, "/")
+
 	if cmd.Run == nil {
 		printCommandUsage(cmd)
 		os.Exit(ERROR_USAGE)
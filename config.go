@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+// Config is the updateservicectl configuration file. It holds named
+// contexts, each describing a server and the credentials to use for it.
+type Config struct {
+	CurrentContext string              `json:"current-context,omitempty"`
+	Contexts       map[string]*Context `json:"contexts,omitempty"`
+}
+
+// Context is a server and the credentials to use for it. The key is taken
+// from Key, the contents of KeyFile or the output of KeyCommand, in that
+// order.
+type Context struct {
+	Server     string `json:"server,omitempty"`
+	User       string `json:"user,omitempty"`
+	Key        string `json:"key,omitempty"`
+	KeyFile    string `json:"key-file,omitempty"`
+	KeyCommand string `json:"key-command,omitempty"`
+}
+
+// contextSummary is the listing of a context printed by get-contexts. It
+// leaves out the key itself.
+type contextSummary struct {
+	Name      string `json:"name"`
+	Current   bool   `json:"current"`
+	Server    string `json:"server,omitempty"`
+	User      string `json:"user,omitempty"`
+	KeySource string `json:"keySource,omitempty"`
+}
+
+var (
+	configFlags struct {
+		server     StringFlag
+		user       StringFlag
+		key        StringFlag
+		keyFile    StringFlag
+		keyCommand StringFlag
+	}
+
+	cmdConfig = &Command{
+		Name:    "config",
+		Summary: "Manage named server contexts.",
+		Description: `Manage the contexts stored in the configuration file.
+
+A context names a server and the credentials to use for it. Select one with
+--context or UPDATECTL_CONTEXT, otherwise the current context of the
+configuration file is used. Flags and UPDATECTL_* environment variables
+override the values of the context. A key command only runs once a command
+sends a request to the server.
+
+The configuration file defaults to ~/.config/updateservicectl/config.yaml
+and can be changed with --config or UPDATECTL_CONFIG:
+
+current-context: staging
+contexts:
+  staging:
+    server: https://staging.example.com
+    user: admin@example.com
+    key-file: ~/.config/updateservicectl/staging.key
+  production:
+    server: https://updates.example.com
+    user: admin@example.com
+    key-command: pass show updateservice/production`,
+		Subcommands: []*Command{
+			cmdConfigUseContext,
+			cmdConfigGetContexts,
+			cmdConfigSetCredentials,
+		},
+	}
+	cmdConfigUseContext = &Command{
+		Name:        "config use-context",
+		Usage:       "NAME",
+		Description: `Make NAME the current context.`,
+		Run:         configUseContext,
+	}
+	cmdConfigGetContexts = &Command{
+		Name:        "config get-contexts",
+		Description: `List the contexts in the configuration file. Keys are not printed.`,
+		Run:         configGetContexts,
+	}
+	cmdConfigSetCredentials = &Command{
+		Name:  "config set-credentials",
+		Usage: "[OPTION]... NAME",
+		Description: `Create the context NAME or update its server and credentials. Only the
+given options are changed. Setting one of --key, --key-file and
+--key-command clears the other two.`,
+		Run: configSetCredentials,
+	}
+)
+
+func init() {
+	cmdConfigSetCredentials.Flags.Var(&configFlags.server, "server", "Update server of the context.")
+	cmdConfigSetCredentials.Flags.Var(&configFlags.user, "user", "API username.")
+	cmdConfigSetCredentials.Flags.Var(&configFlags.key, "key", "API key, stored in plain text.")
+	cmdConfigSetCredentials.Flags.Var(&configFlags.keyFile, "key-file", "File to read the API key from.")
+	cmdConfigSetCredentials.Flags.Var(&configFlags.keyCommand, "key-command", "Command printing the API key, run with sh -c.")
+}
+
+// defaultConfigPath returns the path of the configuration file used when
+// neither --config nor UPDATECTL_CONFIG is set.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, cliName, "config.yaml")
+}
+
+// loadConfig reads the configuration file. A missing file is an empty
+// configuration.
+func loadConfig(path string) (*Config, error) {
+	config := &Config{}
+	if path == "" {
+		return config, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// saveConfig writes config to path, creating its directory if needed. The
+// file may contain keys, so it is only readable by the user.
+func saveConfig(path string, config *Config) error {
+	if path == "" {
+		return fmt.Errorf("no config file, set --config or UPDATECTL_CONFIG")
+	}
+	b, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// contextKey, if set, returns the API key of the selected context. It is
+// only called once a request is sent, so that a key command does not run
+// for commands which never use the API.
+var contextKey func() (string, error)
+
+// applyContext fills in the server and credentials from the selected
+// context for everything not set by a flag or environment variable.
+func applyContext() error {
+	config, err := loadConfig(globalFlags.Config)
+	if err != nil {
+		return err
+	}
+
+	name := globalFlags.Context
+	if name == "" {
+		name = config.CurrentContext
+	}
+	if name == "" {
+		return nil
+	}
+	ctx, ok := config.Contexts[name]
+	if !ok {
+		return fmt.Errorf("context %q not found in %s", name, globalFlags.Config)
+	}
+
+	set := make(map[string]bool)
+	globalFlagSet.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	unset := func(name, env string) bool {
+		return !set[name] && os.Getenv(env) == ""
+	}
+
+	if unset("server", "UPDATECTL_SERVER") && ctx.Server != "" {
+		globalFlags.Server = ctx.Server
+	}
+	if unset("user", "UPDATECTL_USER") && ctx.User != "" {
+		globalFlags.User = ctx.User
+	}
+	if unset("key", "UPDATECTL_KEY") {
+		contextKey = func() (string, error) {
+			key, err := ctx.key()
+			if err != nil {
+				return "", &cliError{
+					Kind:     errorKindConfig,
+					Message:  fmt.Sprintf("context %q: %v", name, err),
+					ExitCode: ERROR_USAGE,
+					err:      fmt.Errorf("context %q: %v", name, err),
+				}
+			}
+			return key, nil
+		}
+	}
+	return nil
+}
+
+// key returns the API key of the context.
+func (c *Context) key() (string, error) {
+	switch {
+	case c.Key != "":
+		return c.Key, nil
+	case c.KeyFile != "":
+		b, err := ioutil.ReadFile(expandHome(c.KeyFile))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	case c.KeyCommand != "":
+		var stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", c.KeyCommand)
+		cmd.Stderr = &stderr
+		b, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("key command failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return "", nil
+}
+
+// keySource describes where the key of the context comes from without
+// revealing it.
+func (c *Context) keySource() string {
+	switch {
+	case c.Key != "":
+		return "key"
+	case c.KeyFile != "":
+		return "file:" + c.KeyFile
+	case c.KeyCommand != "":
+		return "command:" + c.KeyCommand
+	}
+	return ""
+}
+
+// expandHome replaces a leading ~/ with the home directory of the user.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+func configUseContext(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if len(args) != 1 {
+		return ERROR_USAGE
+	}
+
+	config, err := loadConfig(globalFlags.Config)
+	if err != nil {
+		return handleError(err)
+	}
+	if _, ok := config.Contexts[args[0]]; !ok {
+		return handleError(&cliError{
+			Kind:     errorKindNotFound,
+			Message:  fmt.Sprintf("context %q not found", args[0]),
+			ExitCode: ERROR_NOT_FOUND,
+			err:      fmt.Errorf("context %q not found in %s", args[0], globalFlags.Config),
+		})
+	}
+
+	config.CurrentContext = args[0]
+	if err := saveConfig(globalFlags.Config, config); err != nil {
+		return handleError(err)
+	}
+	if !machineOutput() {
+		fmt.Fprintf(out, "Switched to context %q.\n", args[0])
+		out.Flush()
+	}
+	return OK
+}
+
+func configGetContexts(args []string, service *update.Service, out *tabwriter.Writer) int {
+	config, err := loadConfig(globalFlags.Config)
+	if err != nil {
+		return handleError(err)
+	}
+
+	names := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	current := globalFlags.Context
+	if current == "" {
+		current = config.CurrentContext
+	}
+
+	contexts := make([]*contextSummary, 0, len(names))
+	for _, name := range names {
+		ctx := config.Contexts[name]
+		contexts = append(contexts, &contextSummary{
+			Name:      name,
+			Current:   name == current,
+			Server:    ctx.Server,
+			User:      ctx.User,
+			KeySource: ctx.keySource(),
+		})
+	}
+
+	err = printResult(out, contexts, func(out *tabwriter.Writer) {
+		fmt.Fprintln(out, "Current\tName\tServer\tUser\tKey")
+		for _, ctx := range contexts {
+			marker := ""
+			if ctx.Current {
+				marker = "*"
+			}
+			fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\n", marker, ctx.Name, ctx.Server, ctx.User, ctx.KeySource)
+		}
+	})
+	if err != nil {
+		return handleError(err)
+	}
+	return OK
+}
+
+func configSetCredentials(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if len(args) != 1 {
+		return ERROR_USAGE
+	}
+	name := args[0]
+
+	config, err := loadConfig(globalFlags.Config)
+	if err != nil {
+		return handleError(err)
+	}
+	if config.Contexts == nil {
+		config.Contexts = make(map[string]*Context)
+	}
+	ctx, ok := config.Contexts[name]
+	if !ok {
+		ctx = &Context{}
+		config.Contexts[name] = ctx
+	}
+
+	if configFlags.server.Get() != nil {
+		ctx.Server = strings.TrimRight(configFlags.server.String(), "/")
+	}
+	if configFlags.user.Get() != nil {
+		ctx.User = configFlags.user.String()
+	}
+	if configFlags.key.Get() != nil || configFlags.keyFile.Get() != nil || configFlags.keyCommand.Get() != nil {
+		ctx.Key = configFlags.key.String()
+		ctx.KeyFile = configFlags.keyFile.String()
+		ctx.KeyCommand = configFlags.keyCommand.String()
+	}
+	if config.CurrentContext == "" {
+		config.CurrentContext = name
+	}
+
+	if err := saveConfig(globalFlags.Config, config); err != nil {
+		return handleError(err)
+	}
+	if !machineOutput() {
+		fmt.Fprintf(out, "Context %q set.\n", name)
+		out.Flush()
+	}
+	return OK
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"text/tabwriter"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	for _, c := range []struct {
+		name   string
+		path   string
+		config *Config
+		err    bool
+	}{
+		{"no path", "", &Config{}, false},
+		{"missing file", filepath.Join(dir, "missing.yaml"), &Config{}, false},
+		{"contexts", write("config.yaml", `current-context: staging
+contexts:
+  staging:
+    server: https://staging.example.com
+    user: admin
+    key-file: ~/staging.key
+`), &Config{
+			CurrentContext: "staging",
+			Contexts: map[string]*Context{
+				"staging": {Server: "https://staging.example.com", User: "admin", KeyFile: "~/staging.key"},
+			},
+		}, false},
+		{"invalid", write("invalid.yaml", "contexts: [\n"), nil, true},
+	} {
+		config, err := loadConfig(c.path)
+		if (err != nil) != c.err || !reflect.DeepEqual(config, c.config) {
+			t.Errorf("%s: expected %+v, error %v, got %+v, %v", c.name, c.config, c.err, config, err)
+		}
+	}
+}
+
+// setGlobalFlags parses args as the server, user and key flags, with the
+// UPDATECTL_* environment variables as defaults like init does.
+func setGlobalFlags(t *testing.T, args ...string) {
+	globalFlagSet = flag.NewFlagSet(cliName, flag.ContinueOnError)
+	globalFlagSet.StringVar(&globalFlags.Server, "server", os.Getenv("UPDATECTL_SERVER"), "")
+	globalFlagSet.StringVar(&globalFlags.User, "user", os.Getenv("UPDATECTL_USER"), "")
+	globalFlagSet.StringVar(&globalFlags.Key, "key", os.Getenv("UPDATECTL_KEY"), "")
+	if err := globalFlagSet.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ran := filepath.Join(dir, "ran")
+
+	saved, savedSet := globalFlags, globalFlagSet
+	defer func() {
+		globalFlags, globalFlagSet, contextKey = saved, savedSet, nil
+	}()
+	globalFlags.Config = filepath.Join(dir, "config.yaml")
+	err = saveConfig(globalFlags.Config, &Config{
+		CurrentContext: "staging",
+		Contexts: map[string]*Context{
+			"staging":    {Server: "https://staging.example.com", User: "staging", KeyCommand: "touch " + ran + "; echo secret"},
+			"production": {Server: "https://updates.example.com", User: "production", Key: "production"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name         string
+		context      string
+		env          map[string]string
+		args         []string
+		server, user string
+		key          string
+	}{
+		{"current context", "", nil, nil, "https://staging.example.com", "staging", "secret"},
+		{"selected context", "production", nil, nil, "https://updates.example.com", "production", "production"},
+		{"environment", "production", map[string]string{"UPDATECTL_SERVER": "https://env.example.com", "UPDATECTL_KEY": "env"}, nil,
+			"https://env.example.com", "production", "env"},
+		{"flags", "production", map[string]string{"UPDATECTL_SERVER": "https://env.example.com", "UPDATECTL_USER": "env"},
+			[]string{"--server", "https://flag.example.com", "--key", "flag"}, "https://flag.example.com", "env", "flag"},
+	} {
+		for name, value := range c.env {
+			os.Setenv(name, value)
+		}
+		setGlobalFlags(t, c.args...)
+		globalFlags.Context, contextKey = c.context, nil
+		err := applyContext()
+		for name := range c.env {
+			os.Unsetenv(name)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		key := globalFlags.Key
+		if contextKey != nil {
+			if key != "" {
+				t.Errorf("%s: expected the key of the context to be left to the request, got %q", c.name, key)
+			}
+			if key, err = contextKey(); err != nil {
+				t.Errorf("%s: %v", c.name, err)
+			}
+		}
+		if globalFlags.Server != c.server || globalFlags.User != c.user || key != c.key {
+			t.Errorf("%s: expected server %s, user %s and key %s, got %s, %s and %s",
+				c.name, c.server, c.user, c.key, globalFlags.Server, globalFlags.User, key)
+		}
+	}
+
+	// the key command only runs when the key is needed
+	os.Remove(ran)
+	setGlobalFlags(t)
+	globalFlags.Context = ""
+	if err := applyContext(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ran); !os.IsNotExist(err) {
+		t.Errorf("expected the key command not to run before a request, got %v", err)
+	}
+
+	// a failed key command fails the request
+	if err := saveConfig(globalFlags.Config, &Config{
+		Contexts: map[string]*Context{"broken": {KeyCommand: "exit 1"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	setGlobalFlags(t)
+	globalFlags.Context = "broken"
+	if err := applyContext(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = getHawkClient(globalFlags.User, globalFlags.Key).Get("http://localhost:0")
+	if e := classifyError(err); e.Kind != errorKindConfig || e.ExitCode != ERROR_USAGE {
+		t.Errorf("expected the failed key command to be reported, got %+v", e)
+	}
+}
+
+func TestConfigSetCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedConfig, savedOutput := globalFlags.Config, globalFlags.Output
+	defer func() {
+		globalFlags.Config, globalFlags.Output = savedConfig, savedOutput
+		configFlags.server, configFlags.user = StringFlag{}, StringFlag{}
+		configFlags.key, configFlags.keyFile, configFlags.keyCommand = StringFlag{}, StringFlag{}, StringFlag{}
+	}()
+	globalFlags.Config, globalFlags.Output = filepath.Join(dir, "config.yaml"), outputTable
+	out := tabwriter.NewWriter(ioutil.Discard, 0, 8, 1, '\t', 0)
+
+	configFlags.server.Set("https://staging.example.com/")
+	configFlags.user.Set("admin")
+	configFlags.key.Set("secret")
+	if code := configSetCredentials([]string{"staging"}, nil, out); code != OK {
+		t.Fatalf("expected set-credentials to succeed, got %d", code)
+	}
+
+	// only the given options change, and a key source replaces the others
+	configFlags.server, configFlags.user, configFlags.key = StringFlag{}, StringFlag{}, StringFlag{}
+	configFlags.keyFile.Set("~/staging.key")
+	if code := configSetCredentials([]string{"staging"}, nil, out); code != OK {
+		t.Fatalf("expected set-credentials to succeed, got %d", code)
+	}
+	configFlags.keyFile = StringFlag{}
+	configFlags.server.Set("https://updates.example.com")
+	if code := configSetCredentials([]string{"production"}, nil, out); code != OK {
+		t.Fatalf("expected set-credentials to succeed, got %d", code)
+	}
+
+	config, err := loadConfig(globalFlags.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Config{
+		CurrentContext: "staging",
+		Contexts: map[string]*Context{
+			"staging":    {Server: "https://staging.example.com", User: "admin", KeyFile: "~/staging.key"},
+			"production": {Server: "https://updates.example.com"},
+		},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("expected %+v, got %+v", want, config)
+	}
+	if info, err := os.Stat(globalFlags.Config); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected the config to be readable by the user only, got %v, %v", info, err)
+	}
+
+	if code := configSetCredentials(nil, nil, out); code != ERROR_USAGE {
+		t.Errorf("expected a missing name to be a usage error, got %d", code)
+	}
+}
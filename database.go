@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"text/tabwriter"
@@ -41,12 +40,12 @@ func databaseInit(args []string, service *update.Service, out *tabwriter.Writer)
 	client := &http.Client{}
 	resp, err := client.Get(adminUrl)
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 	fmt.Println(string(body))
 	if string(body) != "ok" {
@@ -63,21 +62,20 @@ func databaseBackup(args []string, service *update.Service, out *tabwriter.Write
 	client := getHawkClient(globalFlags.User, globalFlags.Key)
 	resp, err := client.Get(backupUrl)
 	if err != nil {
-		log.Fatal(err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		log.Fatal(string(body))
+		return handleError(err)
 	}
 	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return handleError(err)
+	}
 	outFile, err := os.Create(args[0])
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 	defer outFile.Close()
 	_, err = io.Copy(outFile, resp.Body)
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 	return OK
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"text/tabwriter"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+var (
+	diffFlags struct {
+		file  string
+		prune bool
+	}
+
+	cmdDiff = &Command{
+		Name:    "diff",
+		Usage:   "[OPTION]...",
+		Summary: "Show the changes apply would make for a manifest.",
+		Description: `Compares a manifest (--file) with the live apps, channels, groups and
+rollouts on the server and prints every change that "apply" would make,
+field by field. Nothing is changed on the server.
+
+Use --output=json for a machine readable diff. The command exits with
+status 4 when the server differs from the manifest, so it can be used to
+detect changes made outside of the manifest.`,
+		Run: diff,
+	}
+)
+
+func init() {
+	cmdDiff.Flags.StringVar(&diffFlags.file, "file", "",
+		"Manifest file to compare with, or - for stdin.")
+	cmdDiff.Flags.StringVar(&diffFlags.file, "f", "",
+		"Shorthand for --file.")
+	cmdDiff.Flags.BoolVar(&diffFlags.prune, "prune", false,
+		"Include channels and groups not listed in the manifest as deletions.")
+}
+
+func diff(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if diffFlags.file == "" {
+		return ERROR_USAGE
+	}
+
+	manifest, err := loadManifest(diffFlags.file)
+	if err != nil {
+		log.Print(err)
+		return ERROR_USAGE
+	}
+
+	live, err := fetchLiveState(service, manifest)
+	if err != nil {
+		return handleError(err)
+	}
+
+	changes := planManifest(manifest, live, diffFlags.prune)
+
+	err = printResult(out, changes, func(out *tabwriter.Writer) {
+		if len(changes) == 0 {
+			fmt.Fprintln(out, "no differences")
+		}
+		for _, c := range changes {
+			fmt.Fprint(out, formatChange(c))
+		}
+	})
+	if err != nil {
+		return handleError(err)
+	}
+
+	if len(changes) > 0 {
+		return ERROR_DRIFT
+	}
+	return OK
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/ghodss/yaml"
+	"google.golang.org/api/googleapi"
+)
+
+// Kinds of errors, as reported in the machine readable error envelope.
+const (
+	errorKindAPI          = "api"
+	errorKindNotFound     = "not_found"
+	errorKindUnauthorized = "unauthorized"
+	errorKindConflict     = "conflict"
+	errorKindNetwork      = "network"
+	errorKindConfig       = "config"
+)
+
+// cliError is an error classified by what went wrong, with the exit code
+// that goes with it.
+type cliError struct {
+	Kind     string `json:"kind"`
+	Status   int    `json:"status,omitempty"`
+	Message  string `json:"message"`
+	ExitCode int    `json:"exitCode"`
+
+	err error
+}
+
+func (e *cliError) Error() string {
+	return e.err.Error()
+}
+
+// classifyError maps errors from the generated client, the network and
+// everything else to a cliError.
+func classifyError(err error) *cliError {
+	var cerr *cliError
+	if errors.As(err, &cerr) {
+		return cerr
+	}
+
+	e := &cliError{
+		Kind:     errorKindAPI,
+		Message:  err.Error(),
+		ExitCode: ERROR_API,
+		err:      err,
+	}
+
+	var apiErr *googleapi.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &apiErr):
+		e.Status = apiErr.Code
+		if apiErr.Message != "" {
+			e.Message = apiErr.Message
+		}
+		switch apiErr.Code {
+		case http.StatusNotFound:
+			e.Kind, e.ExitCode = errorKindNotFound, ERROR_NOT_FOUND
+		case http.StatusUnauthorized, http.StatusForbidden:
+			e.Kind, e.ExitCode = errorKindUnauthorized, ERROR_UNAUTHORIZED
+		case http.StatusConflict:
+			e.Kind, e.ExitCode = errorKindConflict, ERROR_CONFLICT
+		}
+	case errors.As(err, &netErr):
+		e.Kind, e.ExitCode = errorKindNetwork, ERROR_NETWORK
+	}
+	return e
+}
+
+// handleError reports err and returns the exit code for it. When machine
+// readable output was requested the error is written to stdout as an
+// envelope in the same format, otherwise it is logged to stderr.
+func handleError(err error) int {
+	e := classifyError(err)
+
+	if !machineOutput() {
+		log.Print(err)
+		return e.ExitCode
+	}
+
+	envelope := struct {
+		Error *cliError `json:"error"`
+	}{e}
+
+	var b []byte
+	if globalFlags.Output == outputYAML {
+		b, err = yaml.Marshal(envelope)
+	} else {
+		b, err = json.MarshalIndent(envelope, "", "  ")
+		b = append(b, '\n')
+	}
+	if err != nil {
+		log.Print(e.err)
+		return e.ExitCode
+	}
+	os.Stdout.Write(b)
+	return e.ExitCode
+}
+
+// checkResponse turns a non-2xx response from one of the plain HTTP
+// endpoints into the same error the generated client returns.
+func checkResponse(resp *http.Response) error {
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return fmt.Errorf("%s %s: %w", resp.Request.Method, resp.Request.URL.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+var (
+	exportFlags struct {
+		file string
+	}
+	importFlags struct {
+		file string
+	}
+
+	cmdExport = &Command{
+		Name:    "export",
+		Usage:   "[OPTION]...",
+		Summary: "Export the configuration of the server.",
+		Description: `Writes every app with its channels, groups, rollouts and packages and
+all upstreams to a versioned manifest. The manifest can be loaded into
+another server with "import", or used with "apply" and "diff".
+
+The manifest is written as YAML, or as JSON with --output=json.`,
+		Run: export,
+	}
+	cmdImport = &Command{
+		Name:    "import",
+		Usage:   "[OPTION]...",
+		Summary: "Import a configuration written by export.",
+		Description: `Replays a manifest written by "export" into the server. Objects which
+already match the manifest are skipped, so importing the same manifest
+twice is safe. Existing packages are never modified and nothing is
+deleted.`,
+		Run: importManifest,
+	}
+)
+
+func init() {
+	cmdExport.Flags.StringVar(&exportFlags.file, "file", "-",
+		"File to write the export to, or - for stdout.")
+	cmdExport.Flags.StringVar(&exportFlags.file, "f", "-",
+		"Shorthand for --file.")
+
+	cmdImport.Flags.StringVar(&importFlags.file, "file", "",
+		"Manifest file to import, or - for stdin.")
+	cmdImport.Flags.StringVar(&importFlags.file, "f", "",
+		"Shorthand for --file.")
+}
+
+// exportManifest reads the configuration of the server into a manifest.
+func exportManifest(service *update.Service) (*Manifest, error) {
+	manifest := &Manifest{Version: manifestVersion}
+
+	apps, err := service.App.List().Do()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, app := range apps.Items {
+		mapp := &ManifestApp{
+			Id:          app.Id,
+			Label:       app.Label,
+			Description: app.Description,
+		}
+
+		channels, err := service.Channel.List(app.Id).Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range channels.Items {
+			mapp.Channels = append(mapp.Channels, &ManifestChannel{
+				Label:    channel.Label,
+				Version:  channel.Version,
+				Publish:  channel.Publish,
+				Upstream: channel.Upstream,
+			})
+		}
+
+		groups, err := service.Group.List(app.Id).Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups.Items {
+			mgroup, err := exportGroup(service, group)
+			if err != nil {
+				return nil, err
+			}
+			mapp.Groups = append(mapp.Groups, mgroup)
+		}
+
+		packages, err := fetchPackages(service, app.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, pkg := range packages {
+			p := *pkg
+			p.AppId = ""
+			p.DateCreated = ""
+			mapp.Packages = append(mapp.Packages, &p)
+		}
+
+		manifest.Apps = append(manifest.Apps, mapp)
+	}
+
+	upstreams, err := service.Upstream.List().Do()
+	if err != nil {
+		return nil, err
+	}
+	for _, upstream := range upstreams.Items {
+		manifest.Upstreams = append(manifest.Upstreams, &ManifestUpstream{
+			Url:   upstream.Url,
+			Label: upstream.Label,
+		})
+	}
+
+	return manifest, nil
+}
+
+func exportGroup(service *update.Service, group *update.Group) (*ManifestGroup, error) {
+	percent, paused := group.UpdatePercent, group.UpdatesPaused
+	mgroup := &ManifestGroup{
+		Id:            group.Id,
+		Label:         group.Label,
+		Channel:       group.ChannelId,
+		Paused:        &paused,
+		UpdatePercent: &percent,
+	}
+	if group.OemBlacklist != "" {
+		mgroup.OemBlacklist = strings.Split(group.OemBlacklist, ",")
+	}
+
+	rollout, err := fetchRollout(service, group.AppId, group.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(rollout.Rollout) > 0 {
+		mgroup.Rollout = &ManifestRollout{Active: group.RolloutActive}
+		for _, f := range rollout.Rollout {
+			mgroup.Rollout.Frames = append(mgroup.Rollout.Frames, &ManifestFrame{
+				Percent:  f.Percent,
+				Duration: f.Duration,
+			})
+		}
+	}
+	return mgroup, nil
+}
+
+func export(args []string, service *update.Service, out *tabwriter.Writer) int {
+	var marshal func(interface{}) ([]byte, error)
+	switch globalFlags.Output {
+	case outputTable, outputYAML:
+		marshal = yaml.Marshal
+	case outputJSON:
+		marshal = func(v interface{}) ([]byte, error) {
+			b, err := json.MarshalIndent(v, "", "  ")
+			return append(b, '\n'), err
+		}
+	default:
+		log.Printf("export does not support --output=%s", globalFlags.Output)
+		return ERROR_USAGE
+	}
+
+	manifest, err := exportManifest(service)
+	if err != nil {
+		return handleError(err)
+	}
+
+	data, err := marshal(manifest)
+	if err != nil {
+		return handleError(err)
+	}
+
+	if exportFlags.file == "-" {
+		if _, err := os.Stdout.Write(data); err != nil {
+			return handleError(err)
+		}
+		return OK
+	}
+
+	if err := writeFile(exportFlags.file, data); err != nil {
+		return handleError(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d apps and %d upstreams to %s\n",
+		len(manifest.Apps), len(manifest.Upstreams), exportFlags.file)
+	return OK
+}
+
+// writeFile writes data to name, reporting errors from closing the file
+// which may hide a truncated write.
+func writeFile(name string, data []byte) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func importManifest(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if importFlags.file == "" {
+		return ERROR_USAGE
+	}
+
+	manifest, err := loadManifest(importFlags.file)
+	if err != nil {
+		log.Print(err)
+		return ERROR_USAGE
+	}
+
+	changes, err := applyManifest(service, manifest, false)
+	if err != nil {
+		logApplied(changes)
+		return handleError(err)
+	}
+
+	if err := printChanges(out, changes); err != nil {
+		return handleError(err)
+	}
+	return OK
+}
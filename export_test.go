@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/mockserver"
+)
+
+func newMockService(t *testing.T) (*update.Service, func()) {
+	s := mockserver.New()
+	ts := httptest.NewServer(s)
+	return s.Service(ts.URL), ts.Close
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	service, done := newMockService(t)
+	defer done()
+
+	service.App.Insert(&update.AppInsertReq{Id: "app", Label: "App"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "1.0.0", Publish: true}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable", OemBlacklist: "azure,gce"}).Do()
+	service.Group.Rollout.Set("app", "prod", &update.Rollout{Rollout: []*update.Frame{{Percent: 50, Duration: 60}, {Percent: 100}}}).Do()
+	service.App.Package.Insert("app", "1.0.0", &update.Package{Url: "http://example.com/update.gz", Size: "4", Sha1Sum: "sha1"}).Do()
+	service.Upstream.Insert(&update.Upstream{Url: "https://upstream.example.com", Label: "Upstream"}).Do()
+
+	manifest, err := exportManifest(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Apps) != 1 || len(manifest.Upstreams) != 1 {
+		t.Fatalf("expected an app and an upstream, got %+v", manifest)
+	}
+	app := manifest.Apps[0]
+	if len(app.Channels) != 1 || len(app.Groups) != 1 || len(app.Packages) != 1 {
+		t.Fatalf("unexpected app %+v", app)
+	}
+	if g := app.Groups[0]; !reflect.DeepEqual(g.OemBlacklist, []string{"azure", "gce"}) || len(g.Rollout.Frames) != 2 {
+		t.Errorf("unexpected group %+v", g)
+	}
+	if p := app.Packages[0]; p.AppId != "" || p.DateCreated != "" || p.Version != "1.0.0" {
+		t.Errorf("expected the package without server fields, got %+v", p)
+	}
+
+	// the export recreates the same configuration on another server
+	other, done := newMockService(t)
+	defer done()
+	if _, err := applyManifest(other, manifest, false); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := exportManifest(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(manifest)
+	got, _ := json.Marshal(copied)
+	if !bytes.Equal(want, got) {
+		t.Errorf("expected the export of the copy\n%s\nto match\n%s", got, want)
+	}
+	if changes, err := applyManifest(other, manifest, false); err != nil || len(changes) != 0 {
+		t.Errorf("expected importing twice to change nothing, got %v, %v", changes, err)
+	}
+}
+
+func TestFetchPackagesWithoutTotal(t *testing.T) {
+	s := mockserver.New()
+	// leave out the total, as some servers do
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || !strings.HasSuffix(r.URL.Path, "/packages") {
+			s.ServeHTTP(w, r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+		var list map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		delete(list, "total")
+		json.NewEncoder(w).Encode(list)
+	}))
+	defer ts.Close()
+	service := s.Service(ts.URL)
+
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	for i := 0; i < packagePageSize+50; i++ {
+		service.App.Package.Insert("app", fmt.Sprintf("1.0.%03d", i), &update.Package{}).Do()
+	}
+
+	packages, err := fetchPackages(service, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != packagePageSize+50 {
+		t.Errorf("expected all %d packages, got %d", packagePageSize+50, len(packages))
+	}
+}
+
+func TestPlanPackagesAndUpstreams(t *testing.T) {
+	manifest := &Manifest{
+		Upstreams: []*ManifestUpstream{
+			{Url: "https://a.example.com", Label: "A"},
+			{Url: "https://b.example.com", Label: "B"},
+			{Url: "https://c.example.com", Label: "C"},
+		},
+		Apps: []*ManifestApp{{
+			Id: "app",
+			Packages: []*update.Package{
+				{Version: "1.0.0", Url: "http://example.com/1.0.0.gz"},
+				{Version: "1.1.0", Url: "http://example.com/1.1.0.gz"},
+			},
+		}},
+	}
+	live := &liveState{
+		apps: map[string]*liveApp{
+			"app": {
+				app: &update.App{Id: "app"},
+				// existing packages are never modified
+				packages: map[string]*update.Package{
+					"1.0.0": {Version: "1.0.0", Url: "http://example.com/other.gz"},
+				},
+			},
+		},
+		upstreams: map[string]*update.Upstream{
+			"https://a.example.com": {Id: "a", Url: "https://a.example.com", Label: "A"},
+			"https://b.example.com": {Id: "b", Url: "https://b.example.com", Label: "Old"},
+		},
+	}
+
+	var got []string
+	for _, c := range planManifest(manifest, live, false) {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"update upstream https://b.example.com",
+		"create upstream https://c.example.com",
+		"create package 1.1.0 (app app)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected changes %q, got %q", want, got)
+	}
+}
+
+func TestPlanChannelUpstream(t *testing.T) {
+	live := &update.AppChannel{Label: "stable", Version: "1.0.0", Upstream: "a"}
+
+	if c := planChannel("app", &ManifestChannel{Label: "stable", Version: "1.0.0"}, live); c != nil {
+		t.Errorf("expected a channel without upstream to leave the binding, got %v", c.Fields)
+	}
+	c := planChannel("app", &ManifestChannel{Label: "stable", Version: "1.0.0", Upstream: "b"}, live)
+	if c == nil || len(c.Fields) != 1 || c.Fields[0].Field != "upstream" {
+		t.Fatalf("expected the upstream to change, got %v", c)
+	}
+
+	// the mock server, like a server without that upstream, binds nothing
+	service, done := newMockService(t)
+	defer done()
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "1.0.0"}).Do()
+	if err := c.apply(service); err == nil || !strings.Contains(err.Error(), "not bound") {
+		t.Errorf("expected the missing binding to be reported, got %v", err)
+	}
+}
+
+func TestApplyManifestPartly(t *testing.T) {
+	service, done := newMockService(t)
+	defer done()
+
+	// the second group refers to a channel the server does not have, as
+	// the manifest is not validated here
+	manifest := &Manifest{Apps: []*ManifestApp{{
+		Id:       "app",
+		Channels: []*ManifestChannel{{Label: "stable", Version: "1.0.0"}},
+		Groups: []*ManifestGroup{
+			{Id: "beta", Channel: "stable"},
+			{Id: "prod", Channel: "missing"},
+		},
+	}}}
+	changes, err := applyManifest(service, manifest, false)
+	if err == nil {
+		t.Fatal("expected the group with a missing channel to fail")
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	want := []string{"create app app (app app)", "create channel stable (app app)", "create group beta (app app)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the changes made before the failure %q, got %q", want, got)
+	}
+}
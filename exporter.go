@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/exporter"
+)
+
+var (
+	exporterFlags struct {
+		listen   string
+		appIds   string
+		interval int64
+		window   int64
+	}
+
+	cmdExporter = &Command{
+		Name:    "exporter",
+		Usage:   "[OPTION]...",
+		Summary: "Expose the state of apps and groups as Prometheus metrics.",
+		Description: `Poll the update service every --interval seconds and serve the state of all
+groups on /metrics in the Prometheus text format: whether updates are paused,
+whether the rollout is active, the update percent, and the instances,
+versions and events seen during the last --window seconds.
+
+Events are labelled with their Omaha type and result codes, e.g. type="3"
+and result="0" for failed updates. A stalled rollout is an active one whose
+group reports no completed updates:
+
+	updateservice_group_rollout_active == 1
+	unless on (app, group) updateservice_group_events{type="3",result=~"1|2"} > 0
+
+The metrics of the last successful poll are served until the next one
+succeeds; updateservice_up tells whether the last poll failed.`,
+		Run: runExporter,
+	}
+)
+
+func init() {
+	cmdExporter.Flags.StringVar(&exporterFlags.listen, "listen", ":9797", "Address to serve metrics on.")
+	cmdExporter.Flags.StringVar(&exporterFlags.appIds, "app-id", "", "Comma separated list of apps to export, all apps if empty.")
+	cmdExporter.Flags.Int64Var(&exporterFlags.interval, "interval", 60, "Seconds between polls of the update service.")
+	cmdExporter.Flags.Int64Var(&exporterFlags.window, "window", 3600, "Seconds of instances, versions and events to count.")
+}
+
+func runExporter(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if exporterFlags.interval <= 0 || exporterFlags.window <= 0 {
+		return ERROR_USAGE
+	}
+
+	e := &exporter.Exporter{
+		Collector: &exporter.Collector{
+			Service: service,
+			AppIds:  splitList(exporterFlags.appIds),
+			Window:  time.Duration(exporterFlags.window) * time.Second,
+		},
+		Interval: time.Duration(exporterFlags.interval) * time.Second,
+		Logf:     log.Printf,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<html><body><a href="/metrics">Metrics</a></body></html>`))
+	})
+	server := &http.Server{Addr: exporterFlags.listen, Handler: mux}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		cancel()
+		shutdownCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving metrics on %s/metrics", exporterFlags.listen)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return handleError(err)
+	}
+	return OK
+}
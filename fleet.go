@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/omahaclient"
+)
+
+// Update states of instances, derived from the last event they reported.
+const (
+	stateChecking    = "checking"
+	stateDownloading = "downloading"
+	stateInstalled   = "installed"
+	stateError       = "error"
+)
+
+var (
+	fleetFlags struct {
+		appId   StringFlag
+		groupId StringFlag
+		since   int64
+		oems    string
+	}
+
+	cmdFleet = &Command{
+		Name:    "fleet",
+		Summary: "Overview of the instances of all apps and groups.",
+		Subcommands: []*Command{
+			cmdFleetSummary,
+		},
+	}
+	cmdFleetSummary = &Command{
+		Name:  "fleet summary",
+		Usage: "[OPTION]...",
+		Description: `Summarize the instances seen during the last --since seconds for each app
+and group: the number of instances, their versions, OEMs and update states.
+
+The update state is derived from the last event of an instance: downloading
+after an update download started or finished, installed after an update
+completed, error after any failed event, and checking otherwise.
+
+OEMs are counted for the names given with --oems, the remaining instances
+are listed as other.
+
+The server only counts instances, so a group takes a count request for its
+instances, one for each OEM in --oems and five for the update states. Counting
+stops as soon as all instances of the group are accounted for, and groups
+without instances take a single request. On large fleets, --oems= leaves the
+OEMs out.`,
+		Run: fleetSummary,
+	}
+)
+
+func init() {
+	cmdFleetSummary.Flags.Var(&fleetFlags.appId, "app-id", "Only summarize this app.")
+	cmdFleetSummary.Flags.Var(&fleetFlags.groupId, "group-id", "Only summarize this group.")
+	cmdFleetSummary.Flags.Int64Var(&fleetFlags.since, "since", 86400, "Count instances seen during the last this many seconds (default 1 day).")
+	cmdFleetSummary.Flags.StringVar(&fleetFlags.oems, "oems", "ami,azure,digitalocean,gce,packet,pxe,qemu,vmware_raw",
+		"Comma separated list of OEMs to count.")
+}
+
+// groupSummary is the summary of the instances of one group.
+type groupSummary struct {
+	AppId     string           `json:"appId"`
+	GroupId   string           `json:"groupId"`
+	Instances int64            `json:"instances"`
+	Versions  map[string]int64 `json:"versions"`
+	OEMs      map[string]int64 `json:"oems"`
+	States    map[string]int64 `json:"states"`
+}
+
+// countCall returns a client update count call for the instances of a
+// group seen since the given time.
+func countCall(service *update.Service, appId, groupId string, since int64) *update.ClientupdateCountCall {
+	return service.Clientupdate.Count().
+		AppId(appId).
+		GroupId(groupId).
+		DateStart(since)
+}
+
+func summarizeGroup(service *update.Service, appId, groupId string, since int64, versions []*update.AppVersionItem, oems []string) (*groupSummary, error) {
+	summary := &groupSummary{
+		AppId:    appId,
+		GroupId:  groupId,
+		Versions: make(map[string]int64),
+		OEMs:     make(map[string]int64),
+		States:   make(map[string]int64),
+	}
+
+	total, err := countCall(service, appId, groupId, since).Do()
+	if err != nil {
+		return nil, err
+	}
+	summary.Instances = total.Count
+	if summary.Instances == 0 {
+		return summary, nil
+	}
+
+	for _, v := range versions {
+		if v.AppId == appId && v.GroupId == groupId {
+			summary.Versions[v.Version] += v.Count
+		}
+	}
+
+	var counted int64
+	for _, oem := range oems {
+		if counted >= summary.Instances {
+			break
+		}
+		count, err := countCall(service, appId, groupId, since).Oem(oem).Do()
+		if err != nil {
+			return nil, err
+		}
+		if count.Count > 0 {
+			summary.OEMs[oem] = count.Count
+			counted += count.Count
+		}
+	}
+	if other := summary.Instances - counted; other > 0 && len(oems) > 0 {
+		summary.OEMs["other"] = other
+	}
+
+	states := []struct {
+		state       string
+		eventType   string
+		eventResult string
+	}{
+		{stateDownloading, omahaclient.EventTypeDownloadStarted, omahaclient.EventResultSuccess},
+		{stateDownloading, omahaclient.EventTypeDownloadFinished, omahaclient.EventResultSuccess},
+		{stateInstalled, omahaclient.EventTypeUpdateComplete, omahaclient.EventResultSuccess},
+		{stateInstalled, omahaclient.EventTypeUpdateComplete, omahaclient.EventResultSuccessReboot},
+		{stateError, "", omahaclient.EventResultError},
+	}
+	checking := summary.Instances
+	for _, s := range states {
+		if checking <= 0 {
+			break
+		}
+		call := countCall(service, appId, groupId, since).EventResult(s.eventResult)
+		if s.eventType != "" {
+			call.EventType(s.eventType)
+		}
+		count, err := call.Do()
+		if err != nil {
+			return nil, err
+		}
+		summary.States[s.state] += count.Count
+		checking -= count.Count
+	}
+	if checking < 0 {
+		checking = 0
+	}
+	summary.States[stateChecking] = checking
+
+	return summary, nil
+}
+
+// formatDistribution formats counts as name=count pairs, largest first.
+func formatDistribution(counts map[string]int64) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%d", name, counts[name])
+	}
+	return strings.Join(pairs, " ")
+}
+
+func fleetSummary(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if fleetFlags.since <= 0 {
+		return ERROR_USAGE
+	}
+	since := time.Now().Add(-time.Duration(fleetFlags.since) * time.Second).Unix()
+
+	oems := splitList(fleetFlags.oems)
+
+	var appIds []string
+	if fleetFlags.appId.Get() != nil {
+		appIds = append(appIds, fleetFlags.appId.String())
+	} else {
+		apps, err := service.App.List().Do()
+		if err != nil {
+			return handleError(err)
+		}
+		for _, app := range apps.Items {
+			appIds = append(appIds, app.Id)
+		}
+	}
+
+	summaries := []*groupSummary{}
+	for _, appId := range appIds {
+		groups, err := service.Group.List(appId).Do()
+		if err != nil {
+			return handleError(err)
+		}
+
+		versions, err := service.Appversion.List().
+			AppId(appId).
+			DateStart(since).
+			Do()
+		if err != nil {
+			return handleError(err)
+		}
+
+		for _, group := range groups.Items {
+			if fleetFlags.groupId.Get() != nil && group.Id != fleetFlags.groupId.String() {
+				continue
+			}
+			summary, err := summarizeGroup(service, appId, group.Id, since, versions.Items, oems)
+			if err != nil {
+				return handleError(err)
+			}
+			summaries = append(summaries, summary)
+		}
+	}
+
+	err := printResult(out, summaries, func(out *tabwriter.Writer) {
+		fmt.Fprintln(out, "AppID\tGroupID\tInstances\tVersions\tOEMs\tStates")
+		for _, s := range summaries {
+			fmt.Fprintf(out, "%s\t%s\t%d\t%s\t%s\t%s\n", s.AppId, s.GroupId, s.Instances,
+				formatDistribution(s.Versions), formatDistribution(s.OEMs),
+				formatDistribution(s.States))
+		}
+	})
+	if err != nil {
+		return handleError(err)
+	}
+	return OK
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/mockserver"
+	"github.com/coreos/updateservicectl/pkg/omahaclient"
+)
+
+func TestSummarizeGroup(t *testing.T) {
+	s := mockserver.New()
+	var counts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/clientupdatecount") {
+			atomic.AddInt32(&counts, 1)
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	service := s.Service(ts.URL)
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "1.0.0"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "beta", ChannelId: "stable"}).Do()
+	for _, id := range []string{"m1", "m2"} {
+		c := &omahaclient.Client{Server: ts.URL, AppID: "app", Version: "1.0.0", Track: "prod", MachineID: id, OEM: "azure"}
+		if _, err := c.UpdateCheck(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	since := time.Now().Add(-time.Hour).Unix()
+	oems := []string{"azure", "gce", "qemu"}
+
+	summary, err := summarizeGroup(service, "app", "prod", since, nil, oems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Instances != 2 || !reflect.DeepEqual(summary.OEMs, map[string]int64{"azure": 2}) ||
+		!reflect.DeepEqual(summary.States, map[string]int64{stateChecking: 2, stateDownloading: 0, stateInstalled: 0, stateError: 0}) {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	// the other OEMs are not counted once all instances are
+	if n := atomic.SwapInt32(&counts, 0); n != 7 {
+		t.Errorf("expected 7 count requests, got %d", n)
+	}
+
+	if _, err := summarizeGroup(service, "app", "beta", since, nil, oems); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&counts); n != 1 {
+		t.Errorf("expected a single count request for a group without instances, got %d", n)
+	}
+}
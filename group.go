@@ -7,6 +7,7 @@ import (
 	"text/tabwriter"
 
 	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/omahaclient"
 )
 
 var (
@@ -16,9 +17,13 @@ var (
 		appId         StringFlag
 		groupId       StringFlag
 		oemBlacklist  StringFlag
-		start         int64
-		end           int64
+		start         StringFlag
+		end           StringFlag
+		since         StringFlag
+		versions      string
 		resolution    int64
+		chart         bool
+		csv           bool
 		updatePercent float64
 	}
 
@@ -75,16 +80,18 @@ var (
 		Run:     groupUnpause,
 	}
 	cmdGroupVersions = &Command{
-		Name:    "group versions",
-		Usage:   "[OPTION]...",
-		Summary: "List versions from clients by time.",
-		Run:     groupVersions,
+		Name:        "group versions",
+		Usage:       "[OPTION]...",
+		Summary:     "List versions from clients by time.",
+		Description: rollupDescription,
+		Run:         groupVersions,
 	}
 	cmdGroupEvents = &Command{
-		Name:    "group events",
-		Usage:   "[OPTION]...",
-		Summary: "List events from clients by time.",
-		Run:     groupEvents,
+		Name:        "group events",
+		Usage:       "[OPTION]...",
+		Summary:     "List events from clients by time.",
+		Description: rollupDescription,
+		Run:         groupEvents,
 	}
 	cmdGroupPercent = &Command{
 		Name:    "group percent",
@@ -139,10 +146,18 @@ func init() {
 		"ID for the group.")
 	cmdGroupVersions.Flags.Int64Var(&groupFlags.resolution,
 		"resolution", 60, "60, 3600 or 86400 seconds")
-	cmdGroupVersions.Flags.Int64Var(&groupFlags.start, "start", 0,
-		"Start date filter")
-	cmdGroupVersions.Flags.Int64Var(&groupFlags.end, "end", 0,
-		"End date filter")
+	cmdGroupVersions.Flags.Var(&groupFlags.start, "start",
+		"Start date filter, in seconds since the epoch or RFC3339.")
+	cmdGroupVersions.Flags.Var(&groupFlags.end, "end",
+		"End date filter, in seconds since the epoch or RFC3339.")
+	cmdGroupVersions.Flags.Var(&groupFlags.since, "since",
+		"Start date filter as a duration before the end, e.g. 90m, 24h or 7d.")
+	cmdGroupVersions.Flags.StringVar(&groupFlags.versions, "versions", "",
+		"Comma separated list of versions to include.")
+	cmdGroupVersions.Flags.BoolVar(&groupFlags.chart, "chart", false,
+		"Draw a chart of the counts over time.")
+	cmdGroupVersions.Flags.BoolVar(&groupFlags.csv, "csv", false,
+		"Write the counts as CSV.")
 
 	cmdGroupEvents.Flags.Var(&groupFlags.appId, "app-id",
 		"Application containing the group.")
@@ -150,10 +165,18 @@ func init() {
 		"ID for the group.")
 	cmdGroupEvents.Flags.Int64Var(&groupFlags.resolution,
 		"resolution", 60, "60, 3600 or 86400 seconds")
-	cmdGroupEvents.Flags.Int64Var(&groupFlags.start, "start", 0,
-		"Start date filter")
-	cmdGroupEvents.Flags.Int64Var(&groupFlags.end, "end", 0,
-		"End date filter")
+	cmdGroupEvents.Flags.Var(&groupFlags.start, "start",
+		"Start date filter, in seconds since the epoch or RFC3339.")
+	cmdGroupEvents.Flags.Var(&groupFlags.end, "end",
+		"End date filter, in seconds since the epoch or RFC3339.")
+	cmdGroupEvents.Flags.Var(&groupFlags.since, "since",
+		"Start date filter as a duration before the end, e.g. 90m, 24h or 7d.")
+	cmdGroupEvents.Flags.StringVar(&groupFlags.versions, "versions", "",
+		"Comma separated list of versions to include.")
+	cmdGroupEvents.Flags.BoolVar(&groupFlags.chart, "chart", false,
+		"Draw a chart of the counts over time.")
+	cmdGroupEvents.Flags.BoolVar(&groupFlags.csv, "csv", false,
+		"Write the counts as CSV.")
 
 	cmdGroupPercent.Flags.Var(&groupFlags.appId, "app-id",
 		"Application containing the group.")
@@ -172,6 +195,13 @@ func formatGroup(group *update.Group) string {
 		group.UpdatePercent, strconv.FormatBool(group.RolloutActive))
 }
 
+func printGroup(out *tabwriter.Writer, group *update.Group) error {
+	return printResult(out, group, func(out *tabwriter.Writer) {
+		fmt.Fprint(out, groupHeader)
+		fmt.Fprintf(out, "%s", formatGroup(group))
+	})
+}
+
 func groupList(args []string, service *update.Service, out *tabwriter.Writer) int {
 	if groupFlags.appId.Get() == nil {
 		return ERROR_USAGE
@@ -181,15 +211,18 @@ func groupList(args []string, service *update.Service, out *tabwriter.Writer) in
 	list, err := listCall.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, groupHeader)
-	for _, group := range list.Items {
-		fmt.Fprintf(out, "%s", formatGroup(group))
+	err = printResult(out, list, func(out *tabwriter.Writer) {
+		fmt.Fprint(out, groupHeader)
+		for _, group := range list.Items {
+			fmt.Fprintf(out, "%s", formatGroup(group))
+		}
+	})
+	if err != nil {
+		return handleError(err)
 	}
-
-	out.Flush()
 	return OK
 }
 
@@ -197,28 +230,53 @@ func groupEvents(args []string, service *update.Service, out *tabwriter.Writer)
 	if groupFlags.appId.Get() == nil || groupFlags.groupId.Get() == nil {
 		return ERROR_USAGE
 	}
+	start, end, err := rollupRange(groupFlags.start.Get(), groupFlags.end.Get(), groupFlags.since.Get())
+	if err != nil {
+		log.Print(err)
+		return ERROR_USAGE
+	}
+	if groupFlags.chart && groupFlags.csv {
+		log.Print("--chart and --csv are mutually exclusive")
+		return ERROR_USAGE
+	}
 
 	call := service.Group.Requests.Events.Rollup(
 		groupFlags.appId.String(),
 		groupFlags.groupId.String(),
-		groupFlags.start,
-		groupFlags.end,
+		start,
+		end,
 	)
 	call.Resolution(groupFlags.resolution)
+	if groupFlags.versions != "" {
+		call.Versions(groupFlags.versions)
+	}
 	list, err := call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprintln(out, "Version\tType\tResult\tTimestamp\tCount")
-	for _, i := range list.Items {
-		for _, j := range i.Values {
-			fmt.Fprintf(out, "%s\t%s\t%s\t%d\t%d\n",
-				i.Version, i.Type, i.Result, j.Timestamp, j.Count)
-		}
+	label := func(i *update.GroupRequestsItem) string {
+		return fmt.Sprintf("%s %s: %s", i.Version,
+			omahaclient.EventTypeName(i.Type), omahaclient.EventResultName(i.Result))
+	}
+	err = printRollup(out, list, "Event", label,
+		[]string{"version", "type", "result"},
+		func(i *update.GroupRequestsItem) []string {
+			return []string{i.Version, i.Type, i.Result}
+		},
+		func(out *tabwriter.Writer) {
+			fmt.Fprintln(out, "Version\tType\tResult\tTimestamp\tCount")
+			for _, i := range list.Items {
+				for _, j := range i.Values {
+					fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%d\n",
+						i.Version, i.Type, i.Result, formatTimestamp(j.Timestamp), j.Count)
+				}
+			}
+		})
+	if err != nil {
+		return handleError(err)
 	}
-	out.Flush()
 	return OK
 }
 
@@ -226,28 +284,52 @@ func groupVersions(args []string, service *update.Service, out *tabwriter.Writer
 	if groupFlags.appId.Get() == nil || groupFlags.groupId.Get() == nil {
 		return ERROR_USAGE
 	}
+	start, end, err := rollupRange(groupFlags.start.Get(), groupFlags.end.Get(), groupFlags.since.Get())
+	if err != nil {
+		log.Print(err)
+		return ERROR_USAGE
+	}
+	if groupFlags.chart && groupFlags.csv {
+		log.Print("--chart and --csv are mutually exclusive")
+		return ERROR_USAGE
+	}
 
 	call := service.Group.Requests.Versions.Rollup(
 		groupFlags.appId.String(),
 		groupFlags.groupId.String(),
-		groupFlags.start,
-		groupFlags.end,
+		start,
+		end,
 	)
 	call.Resolution(groupFlags.resolution)
+	if groupFlags.versions != "" {
+		call.Versions(groupFlags.versions)
+	}
 	list, err := call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprintln(out, "Version\tTimestamp\tCount")
-	for _, i := range list.Items {
-		for _, j := range i.Values {
-			fmt.Fprintf(out, "%s\t%d\t%d\n",
-				i.Version, j.Timestamp, j.Count)
-		}
+	label := func(i *update.GroupRequestsItem) string {
+		return i.Version
+	}
+	err = printRollup(out, list, "Version", label,
+		[]string{"version"},
+		func(i *update.GroupRequestsItem) []string {
+			return []string{i.Version}
+		},
+		func(out *tabwriter.Writer) {
+			fmt.Fprintln(out, "Version\tTimestamp\tCount")
+			for _, i := range list.Items {
+				for _, j := range i.Values {
+					fmt.Fprintf(out, "%s\t%s\t%d\n",
+						i.Version, formatTimestamp(j.Timestamp), j.Count)
+				}
+			}
+		})
+	if err != nil {
+		return handleError(err)
 	}
-	out.Flush()
 	return OK
 }
 
@@ -267,12 +349,12 @@ func groupCreate(args []string, service *update.Service, out *tabwriter.Writer)
 	group, err := call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, groupHeader)
-	fmt.Fprintf(out, "%s", formatGroup(group))
-	out.Flush()
+	if err := printGroup(out, group); err != nil {
+		return handleError(err)
+	}
 	return OK
 }
 
@@ -286,12 +368,12 @@ func groupDelete(args []string, service *update.Service, out *tabwriter.Writer)
 	group, err := call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, groupHeader)
-	fmt.Fprintf(out, "%s", formatGroup(group))
-	out.Flush()
+	if err := printGroup(out, group); err != nil {
+		return handleError(err)
+	}
 	return OK
 }
 
@@ -314,7 +396,7 @@ func setUpdatesPaused(service *update.Service, out *tabwriter.Writer, paused boo
 	group, err := call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
 	group.UpdatesPaused = paused
@@ -323,13 +405,12 @@ func setUpdatesPaused(service *update.Service, out *tabwriter.Writer, paused boo
 	group, err = updateCall.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, groupHeader)
-	fmt.Fprintf(out, "%s", formatGroup(group))
-
-	out.Flush()
+	if err := printGroup(out, group); err != nil {
+		return handleError(err)
+	}
 	return OK
 }
 
@@ -343,7 +424,7 @@ func groupUpdate(args []string, service *update.Service, out *tabwriter.Writer)
 	group, err := call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
 	if groupFlags.label.Get() != nil {
@@ -360,13 +441,12 @@ func groupUpdate(args []string, service *update.Service, out *tabwriter.Writer)
 	group, err = updateCall.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, groupHeader)
-	fmt.Fprintf(out, "%s", formatGroup(group))
-
-	out.Flush()
+	if err := printGroup(out, group); err != nil {
+		return handleError(err)
+	}
 	return OK
 }
 
@@ -387,10 +467,14 @@ func groupPercent(args []string, service *update.Service, out *tabwriter.Writer)
 	groupPercent, err := setCall.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprintf(out, "update percent set to %f\n", groupPercent.UpdatePercent)
-	out.Flush()
+	err = printResult(out, groupPercent, func(out *tabwriter.Writer) {
+		fmt.Fprintf(out, "update percent set to %f\n", groupPercent.UpdatePercent)
+	})
+	if err != nil {
+		return handleError(err)
+	}
 	return OK
 }
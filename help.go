@@ -20,6 +20,22 @@ var (
 		Run:         runHelp,
 	}
 
+	exitCodes = []struct {
+		Code        int
+		Description string
+	}{
+		{OK, "Success."},
+		{ERROR_API, "The server returned an error, or the command failed."},
+		{ERROR_USAGE, "Invalid or missing options."},
+		{ERROR_NO_COMMAND, "Unknown command."},
+		{ERROR_DRIFT, "diff found differences between the manifest and the server, or instance replay between the recorded and replayed responses."},
+		{ERROR_NOT_FOUND, "The requested object does not exist."},
+		{ERROR_UNAUTHORIZED, "Missing, invalid or insufficient credentials."},
+		{ERROR_CONFLICT, "The object already exists or was changed concurrently."},
+		{ERROR_NETWORK, "The server could not be reached."},
+		{ERROR_TIMEOUT, "instance fake ran for --duration without all instances reaching --until-version."},
+	}
+
 	globalUsageTemplate  *template.Template
 	commandUsageTemplate *template.Template
 	templFuncs           = template.FuncMap{
@@ -54,6 +70,9 @@ COMMANDS:{{range .Commands}}
 GLOBAL OPTIONS:{{range .Flags}}
 {{printOption .Name .DefValue .Usage}}{{end}}
 
+EXIT CODES:{{range .ExitCodes}}
+{{printf "\t%d\t%s" .Code .Description}}{{end}}
+
 Run "{{.Executable}} <command> --help" for more details on a specific command.
 `[1:]))
 	commandUsageTemplate = template.Must(template.New("command_usage").Funcs(templFuncs).Parse(`
@@ -106,12 +125,14 @@ func printGlobalUsage() {
 		Flags       []*flag.Flag
 		Description string
 		Version     string
+		ExitCodes   interface{}
 	}{
 		cliName,
 		commands,
 		getAllFlags(),
 		cliDescription,
 		version.Version,
+		exitCodes,
 	})
 }
 
@@ -1,20 +1,24 @@
 package main
 
 import (
-	"bytes"
-	"encoding/xml"
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
-	"github.com/coreos/go-omaha/omaha"
 	"github.com/pborman/uuid"
 
 	update "github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/omahaclient"
 )
 
 var (
@@ -32,6 +36,23 @@ var (
 		pingOnly      int
 		version       string
 		forceUpdate   bool
+		scenario      string
+		duration      int64
+		untilVersion  string
+		file          string
+		listen        string
+		speed         float64
+		clientId      StringFlag
+		stuckAfter    int64
+
+		// instance list filters
+		eventType   StringFlag
+		eventResult StringFlag
+		oem         StringFlag
+		withVersion StringFlag
+		pageSize    int64
+		max         int64
+		sort        string
 	}
 
 	cmdInstance = &Command{
@@ -39,12 +60,35 @@ var (
 		Usage:   "[OPTION]...",
 		Summary: "Operations to view instances.",
 		Subcommands: []*Command{
+			cmdInstanceList,
+			cmdInstanceCount,
 			cmdInstanceListUpdates,
 			cmdInstanceListAppVersions,
+			cmdInstanceHistory,
 			cmdInstanceFake,
+			cmdInstanceRecord,
+			cmdInstanceReplay,
 		},
 	}
 
+	cmdInstanceList = &Command{
+		Name:  "instance list",
+		Usage: "[OPTION]...",
+		Description: `List instances matching all of the given filters.
+
+Results are fetched --page-size at a time until all were listed or --max is
+reached. Unless sorted, each page is printed as soon as it arrives. With
+--sort=last-seen the most recently seen instances are listed first.`,
+		Run: instanceList,
+	}
+
+	cmdInstanceCount = &Command{
+		Name:        "instance count",
+		Usage:       "[OPTION]...",
+		Description: "Count the instances matching all of the given filters.",
+		Run:         instanceCount,
+	}
+
 	cmdInstanceListUpdates = &Command{
 		Name:        "instance list-updates",
 		Usage:       "[OPTION]...",
@@ -59,15 +103,105 @@ var (
 		Run:         instanceListAppVersions,
 	}
 
+	cmdInstanceHistory = &Command{
+		Name:  "instance history",
+		Usage: "[OPTION]...",
+		Description: `Show the update history of one instance, oldest event first.
+
+Event types and results are shown by name. Failed events are marked FAILED,
+downloads which did not finish within --stuck-after seconds are marked
+STUCK.`,
+		Run: instanceHistory,
+	}
+
 	cmdInstanceFake = &Command{
-		Name:        "instance fake",
-		Usage:       "[OPTION]...",
-		Description: "Simulate multiple fake instances.",
-		Run:         instanceFake,
+		Name:  "instance fake",
+		Usage: "[OPTION]...",
+		Description: `Simulate multiple fake instances.
+
+By default --clients-per-app instances of one app and group are simulated.
+With --scenario, populations of instances are read from a YAML or JSON file
+instead, e.g.:
+
+	populations:
+	- name: stable
+	  count: 100
+	  appId: e96281a6-d1af-4bde-9a0a-97b76e56dc57
+	  group: stable
+	  version: 1.0.0
+	  oems: {gce: 3, ami: 1}
+	  forceUpdate: false
+	  checkInterval: {min: 1m, max: 10m}
+	  pingInterval: 30s
+	  rebootDelay: {min: 10s, max: 2m}
+	  rebootPings: 0
+	  failures: {downloadStarted: 0.01, downloadFinished: 0.01, install: 0.05}
+
+OEMs are picked at random by weight. Durations are given like 90s or 5m, or
+in seconds. Failures are the chances, from 0 to 1, of each update step
+failing. The other flags of fake instances are ignored with --scenario.
+
+The instances run until interrupted, for --duration seconds, or until all
+of them run --until-version. If --duration runs out first, the command exits
+with a non-zero status after the report. A report of the requests sent, their latency,
+the updates which succeeded and failed, the versions reached and the
+statuses of update checks is printed at the end, as JSON or YAML with
+--output. Instance logs are then written to stderr.`,
+		Run: instanceFake,
+	}
+
+	cmdInstanceRecord = &Command{
+		Name:    "instance record",
+		Usage:   "[OPTION]...",
+		Summary: "Record Omaha requests and responses.",
+		Description: `Serve an Omaha proxy on --listen which forwards requests to the update
+service and appends every request and response to --file as a line of JSON.
+
+Point fake instances or watch at the proxy to record their traffic, e.g.:
+
+	updateservicectl instance record --file session.jsonl --listen :8099 &
+	updateservicectl --server http://localhost:8099 instance fake ...`,
+		Run: instanceRecord,
+	}
+
+	cmdInstanceReplay = &Command{
+		Name:    "instance replay",
+		Usage:   "[OPTION]...",
+		Summary: "Replay recorded Omaha requests and compare the responses.",
+		Description: `Resend the requests recorded by instance record to the update service, with
+the recorded time between them scaled by --speed, and compare the responses
+with the recorded ones. The time of day and the name of the server are
+ignored in the comparison.
+
+Responses which differ are logged. The exit status is 4 if any response
+differed or a request failed.`,
+		Run: instanceReplay,
 	}
 )
 
 func init() {
+	cmdInstanceList.Flags.Var(&instanceFlags.appId, "app-id", "App id")
+	cmdInstanceList.Flags.Var(&instanceFlags.groupId, "group-id", "Group id")
+	cmdInstanceList.Flags.Var(&instanceFlags.clientId, "client-id", "Client id")
+	cmdInstanceList.Flags.Var(&instanceFlags.eventType, "event-type", "Omaha event type of the last event, e.g. 3 for update complete")
+	cmdInstanceList.Flags.Var(&instanceFlags.eventResult, "event-result", "Omaha event result of the last event, e.g. 0 for error")
+	cmdInstanceList.Flags.Var(&instanceFlags.oem, "oem", "OEM")
+	cmdInstanceList.Flags.Var(&instanceFlags.withVersion, "version", "Version")
+	cmdInstanceList.Flags.Int64Var(&instanceFlags.start, "start", 0, "Start date filter")
+	cmdInstanceList.Flags.Int64Var(&instanceFlags.end, "end", 0, "End date filter")
+	cmdInstanceList.Flags.Int64Var(&instanceFlags.pageSize, "page-size", 100, "Number of instances requested at a time")
+	cmdInstanceList.Flags.Int64Var(&instanceFlags.max, "max", 0, "Maximum number of instances to list, 0 for all")
+	cmdInstanceList.Flags.StringVar(&instanceFlags.sort, "sort", "", "Sort order, empty for server order or last-seen")
+
+	cmdInstanceCount.Flags.Var(&instanceFlags.appId, "app-id", "App id")
+	cmdInstanceCount.Flags.Var(&instanceFlags.groupId, "group-id", "Group id")
+	cmdInstanceCount.Flags.Var(&instanceFlags.eventType, "event-type", "Omaha event type of the last event, e.g. 3 for update complete")
+	cmdInstanceCount.Flags.Var(&instanceFlags.eventResult, "event-result", "Omaha event result of the last event, e.g. 0 for error")
+	cmdInstanceCount.Flags.Var(&instanceFlags.oem, "oem", "OEM")
+	cmdInstanceCount.Flags.Var(&instanceFlags.withVersion, "version", "Version")
+	cmdInstanceCount.Flags.Int64Var(&instanceFlags.start, "start", 0, "Start date filter")
+	cmdInstanceCount.Flags.Int64Var(&instanceFlags.end, "end", 0, "End date filter")
+
 	cmdInstanceListUpdates.Flags.Var(&instanceFlags.groupId, "group-id", "Group id")
 	cmdInstanceListUpdates.Flags.Var(&instanceFlags.appId, "app-id", "App id")
 	cmdInstanceListUpdates.Flags.Int64Var(&instanceFlags.start, "start", 0, "Start date filter")
@@ -78,6 +212,15 @@ func init() {
 	cmdInstanceListAppVersions.Flags.Int64Var(&instanceFlags.start, "start", 0, "Start date filter")
 	cmdInstanceListAppVersions.Flags.Int64Var(&instanceFlags.end, "end", 0, "End date filter")
 
+	cmdInstanceHistory.Flags.Var(&instanceFlags.clientId, "client-id", "Client id of the instance.")
+	cmdInstanceHistory.Flags.Int64Var(&instanceFlags.stuckAfter, "stuck-after", 3600, "Seconds after which an unfinished download is considered stuck.")
+
+	cmdInstanceRecord.Flags.StringVar(&instanceFlags.file, "file", "", "File to append the records to, - for stdout.")
+	cmdInstanceRecord.Flags.StringVar(&instanceFlags.listen, "listen", "127.0.0.1:8099", "Address to serve the proxy on.")
+
+	cmdInstanceReplay.Flags.StringVar(&instanceFlags.file, "file", "", "File to read the records from, - for stdin.")
+	cmdInstanceReplay.Flags.Float64Var(&instanceFlags.speed, "speed", 1, "Speed relative to the recording, 0 to send as fast as possible.")
+
 	cmdInstanceFake.Flags.BoolVar(&instanceFlags.verbose, "verbose", false, "Print out the request bodies")
 	cmdInstanceFake.Flags.IntVar(&instanceFlags.clientsPerApp, "clients-per-app", 20, "Number of fake fents per appid.")
 	cmdInstanceFake.Flags.IntVar(&instanceFlags.minSleep, "min-sleep", 1, "Minimum time between update checks.")
@@ -92,6 +235,201 @@ func init() {
 	instanceFlags.groupId.required = true
 	cmdInstanceFake.Flags.StringVar(&instanceFlags.version, "version", "0.0.0", "Version to report.")
 	cmdInstanceFake.Flags.BoolVar(&instanceFlags.forceUpdate, "force-update", false, "Force updates regardless of rate limiting")
+	cmdInstanceFake.Flags.StringVar(&instanceFlags.scenario, "scenario", "", "File describing populations of fake instances, - for stdin.")
+	cmdInstanceFake.Flags.Int64Var(&instanceFlags.duration, "duration", 0, "Stop after this many seconds, 0 to run until interrupted.")
+	cmdInstanceFake.Flags.StringVar(&instanceFlags.untilVersion, "until-version", "", "Stop once all instances run this version.")
+}
+
+// clientUpdateCall is implemented by the list and count calls of client
+// updates, which take the same filters.
+type clientUpdateCall[C any] interface {
+	AppId(appId string) C
+	GroupId(groupId string) C
+	EventType(eventType string) C
+	EventResult(eventResult string) C
+	Oem(oem string) C
+	Version(version string) C
+	DateStart(dateStart int64) C
+	DateEnd(dateEnd int64) C
+}
+
+// filterClientUpdates sets the filter flags of the instance list and count
+// commands which were given on call. The client id only applies to lists.
+func filterClientUpdates[C clientUpdateCall[C]](call C) C {
+	if instanceFlags.appId.Get() != nil {
+		call = call.AppId(instanceFlags.appId.String())
+	}
+	if instanceFlags.groupId.Get() != nil {
+		call = call.GroupId(instanceFlags.groupId.String())
+	}
+	if instanceFlags.eventType.Get() != nil {
+		call = call.EventType(instanceFlags.eventType.String())
+	}
+	if instanceFlags.eventResult.Get() != nil {
+		call = call.EventResult(instanceFlags.eventResult.String())
+	}
+	if instanceFlags.oem.Get() != nil {
+		call = call.Oem(instanceFlags.oem.String())
+	}
+	if instanceFlags.withVersion.Get() != nil {
+		call = call.Version(instanceFlags.withVersion.String())
+	}
+	if instanceFlags.start != 0 {
+		call = call.DateStart(instanceFlags.start)
+	}
+	if instanceFlags.end != 0 {
+		call = call.DateEnd(instanceFlags.end)
+	}
+	return call
+}
+
+// listClientUpdates pages through the client updates matching the filters
+// of the instance list command and calls fn with each page. It stops after
+// max results if max is positive.
+func listClientUpdates(service *update.Service, pageSize, max int64, fn func(page []*update.ClientUpdate) error) error {
+	var listed int64
+	for {
+		limit := pageSize
+		if max > 0 && max-listed < limit {
+			limit = max - listed
+		}
+
+		call := filterClientUpdates(service.Clientupdate.List()).
+			Limit(limit).
+			Skip(listed)
+		if instanceFlags.clientId.Get() != nil {
+			call.ClientId(instanceFlags.clientId.String())
+		}
+
+		list, err := call.Do()
+		if err != nil {
+			return err
+		}
+		if len(list.Items) > 0 {
+			if err := fn(list.Items); err != nil {
+				return err
+			}
+		}
+
+		listed += int64(len(list.Items))
+		if int64(len(list.Items)) < limit || (max > 0 && listed >= max) {
+			return nil
+		}
+	}
+}
+
+// lastSeenLayouts are the time formats accepted for the last seen time of
+// a client update.
+var lastSeenLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// sortByLastSeen sorts client updates with the most recently seen first.
+// Times which can not be parsed sort last.
+func sortByLastSeen(updates []*update.ClientUpdate) {
+	// parse every time once rather than on each comparison
+	lastSeen := make([]time.Time, len(updates))
+	for i, cl := range updates {
+		for _, layout := range lastSeenLayouts {
+			if t, err := time.Parse(layout, cl.LastSeen); err == nil {
+				lastSeen[i] = t
+				break
+			}
+		}
+	}
+	sort.Stable(byLastSeen{updates, lastSeen})
+}
+
+// byLastSeen sorts client updates by their parsed last seen times, most
+// recent first.
+type byLastSeen struct {
+	updates  []*update.ClientUpdate
+	lastSeen []time.Time
+}
+
+func (s byLastSeen) Len() int           { return len(s.updates) }
+func (s byLastSeen) Less(i, j int) bool { return s.lastSeen[i].After(s.lastSeen[j]) }
+func (s byLastSeen) Swap(i, j int) {
+	s.updates[i], s.updates[j] = s.updates[j], s.updates[i]
+	s.lastSeen[i], s.lastSeen[j] = s.lastSeen[j], s.lastSeen[i]
+}
+
+func writeClientUpdateHeading(out *tabwriter.Writer) {
+	fmt.Fprintln(out, "AppID\tClientID\tVersion\tLastSeen\tGroup\tOEM\tEvent\tResult\tError")
+}
+
+func formatClientUpdate(cl *update.ClientUpdate) string {
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", cl.AppId,
+		cl.ClientId, cl.Version, cl.LastSeen, cl.GroupId, cl.Oem,
+		omahaclient.EventTypeName(cl.EventType), omahaclient.EventResultName(cl.EventResult),
+		cl.ErrorCode)
+}
+
+func instanceList(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if instanceFlags.pageSize <= 0 || instanceFlags.max < 0 {
+		return ERROR_USAGE
+	}
+	switch instanceFlags.sort {
+	case "", "last-seen":
+	default:
+		return ERROR_USAGE
+	}
+
+	// without sorting, table output is streamed page by page
+	if instanceFlags.sort == "" && !machineOutput() {
+		writeClientUpdateHeading(out)
+		err := listClientUpdates(service, instanceFlags.pageSize, instanceFlags.max, func(page []*update.ClientUpdate) error {
+			for _, cl := range page {
+				fmt.Fprintf(out, "%s", formatClientUpdate(cl))
+			}
+			return out.Flush()
+		})
+		if err != nil {
+			out.Flush()
+			return handleError(err)
+		}
+		return OK
+	}
+
+	updates := []*update.ClientUpdate{}
+	err := listClientUpdates(service, instanceFlags.pageSize, instanceFlags.max, func(page []*update.ClientUpdate) error {
+		updates = append(updates, page...)
+		return nil
+	})
+	if err != nil {
+		return handleError(err)
+	}
+	if instanceFlags.sort == "last-seen" {
+		sortByLastSeen(updates)
+	}
+
+	err = printResult(out, updates, func(out *tabwriter.Writer) {
+		writeClientUpdateHeading(out)
+		for _, cl := range updates {
+			fmt.Fprintf(out, "%s", formatClientUpdate(cl))
+		}
+	})
+	if err != nil {
+		return handleError(err)
+	}
+	return OK
+}
+
+func instanceCount(args []string, service *update.Service, out *tabwriter.Writer) int {
+	count, err := filterClientUpdates(service.Clientupdate.Count()).Do()
+	if err != nil {
+		return handleError(err)
+	}
+
+	err = printResult(out, count, func(out *tabwriter.Writer) {
+		fmt.Fprintf(out, "%d\n", count.Count)
+	})
+	if err != nil {
+		return handleError(err)
+	}
+	return OK
 }
 
 func instanceListUpdates(args []string, service *update.Service, out *tabwriter.Writer) int {
@@ -101,21 +439,25 @@ func instanceListUpdates(args []string, service *update.Service, out *tabwriter.
 	if instanceFlags.groupId.Get() != nil {
 		call.GroupId(instanceFlags.groupId.String())
 	}
-	if instanceFlags.groupId.Get() != nil {
+	if instanceFlags.appId.Get() != nil {
 		call.AppId(instanceFlags.appId.String())
 	}
 	list, err := call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprintln(out, "AppID\tClientID\tVersion\tLastSeen\tGroup\tOEM")
-	for _, cl := range list.Items {
-		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\n", cl.AppId,
-			cl.ClientId, cl.Version, cl.LastSeen, cl.GroupId, cl.Oem)
+	err = printResult(out, list, func(out *tabwriter.Writer) {
+		fmt.Fprintln(out, "AppID\tClientID\tVersion\tLastSeen\tGroup\tOEM")
+		for _, cl := range list.Items {
+			fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\n", cl.AppId,
+				cl.ClientId, cl.Version, cl.LastSeen, cl.GroupId, cl.Oem)
+		}
+	})
+	if err != nil {
+		return handleError(err)
 	}
-	out.Flush()
 	return OK
 }
 
@@ -139,236 +481,397 @@ func instanceListAppVersions(args []string, service *update.Service, out *tabwri
 	list, err := call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprintln(out, "AppID\tGroupID\tVersion\tClients")
-	for _, cl := range list.Items {
-		fmt.Fprintf(out, "%s\t%s\t%s\t%d\n", cl.AppId, cl.GroupId, cl.Version, cl.Count)
+	err = printResult(out, list, func(out *tabwriter.Writer) {
+		fmt.Fprintln(out, "AppID\tGroupID\tVersion\tClients")
+		for _, cl := range list.Items {
+			fmt.Fprintf(out, "%s\t%s\t%s\t%d\n", cl.AppId, cl.GroupId, cl.Version, cl.Count)
+		}
+	})
+	if err != nil {
+		return handleError(err)
 	}
-	out.Flush()
 	return OK
 }
 
-type serverConfig struct {
-	server string
-}
-
-type Client struct {
-	Id             string
-	SessionId      string
-	Version        string
-	AppId          string
-	Track          string
-	config         *serverConfig
-	errorRate      int
-	pingsRemaining int
-	forceUpdate    bool
-}
-
-func (c *Client) Log(format string, v ...interface{}) {
-	format = c.Id + ": " + format
-	fmt.Printf(format, v...)
+// historyEvent is an event from the history of an instance, with decoded
+// names and the problems found in it.
+type historyEvent struct {
+	Time          time.Time `json:"time"`
+	Version       string    `json:"version"`
+	GroupId       string    `json:"groupId"`
+	EventType     string    `json:"eventType"`
+	EventTypeName string    `json:"eventTypeName"`
+	Result        string    `json:"eventResult"`
+	ResultName    string    `json:"eventResultName"`
+	ErrorCode     string    `json:"errorCode,omitempty"`
+	InstallSource string    `json:"installSource,omitempty"`
+	Failed        bool      `json:"failed"`
+	Stuck         bool      `json:"stuck"`
 }
 
-func (c *Client) OmahaRequest(otype, result string, updateCheck, isPing bool) *omaha.Request {
-	req := omaha.NewRequest("lsb", "CoreOS", "", "")
-	app := req.AddApp(c.AppId, c.Version)
-	app.MachineID = c.Id
-	app.BootId = c.SessionId
-	app.Track = c.Track
-	app.OEM = instanceFlags.OEM
-	if c.forceUpdate {
-		req.InstallSource = "ondemandupdate"
-	} else {
-		req.InstallSource = "scheduler"
+// annotateHistory sorts the history of an instance by time and flags
+// failed events and downloads which were started but not finished
+// within stuckAfter, either before the next update started or until now.
+func annotateHistory(items []*update.ClientHistoryItem, stuckAfter time.Duration, now time.Time) []*historyEvent {
+	sorted := make([]*update.ClientHistoryItem, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].DateTime < sorted[j].DateTime
+	})
+
+	events := make([]*historyEvent, len(sorted))
+	for i, item := range sorted {
+		events[i] = &historyEvent{
+			Time:          time.Unix(item.DateTime, 0).UTC(),
+			Version:       item.Version,
+			GroupId:       item.GroupId,
+			EventType:     item.EventType,
+			EventTypeName: omahaclient.EventTypeName(item.EventType),
+			Result:        item.EventResult,
+			ResultName:    omahaclient.EventResultName(item.EventResult),
+			ErrorCode:     item.ErrorCode,
+			InstallSource: item.InstallSource,
+			Failed:        item.EventResult == omahaclient.EventResultError,
+		}
 	}
 
-	if updateCheck {
-		app.AddUpdateCheck()
+	for i, e := range events {
+		if e.EventType != omahaclient.EventTypeDownloadStarted || e.Failed {
+			continue
+		}
+		// the download ends with the next download finished event, or is
+		// abandoned by the next download started
+		end := now
+		finished := false
+		for _, next := range events[i+1:] {
+			if next.EventType == omahaclient.EventTypeDownloadFinished {
+				end, finished = next.Time, true
+				break
+			}
+			if next.EventType == omahaclient.EventTypeDownloadStarted || next.Failed {
+				end = next.Time
+				break
+			}
+		}
+		if !finished && end.Sub(e.Time) >= stuckAfter {
+			e.Stuck = true
+		}
 	}
+	return events
+}
 
-	if isPing {
-		app.AddPing()
-		app.Ping.LastReportDays = "1"
-		app.Ping.Status = "1"
+func instanceHistory(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if instanceFlags.clientId.Get() == nil {
+		return ERROR_USAGE
 	}
 
-	if otype != "" {
-		event := app.AddEvent()
-		event.Type = otype
-		event.Result = result
-		if result == "0" {
-			event.ErrorCode = "2000"
-		} else {
-			event.ErrorCode = ""
+	resp, err := service.Client.History(instanceFlags.clientId.String()).Do()
+	if err != nil {
+		return handleError(err)
+	}
+
+	events := annotateHistory(resp.Items, time.Duration(instanceFlags.stuckAfter)*time.Second, time.Now())
+
+	err = printResult(out, events, func(out *tabwriter.Writer) {
+		fmt.Fprintln(out, "Time\tVersion\tGroup\tEvent\tResult\tError\tSource\tNote")
+		for _, e := range events {
+			note := ""
+			if e.Failed {
+				note = "FAILED"
+			} else if e.Stuck {
+				note = "STUCK"
+			}
+			fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				e.Time.Format(time.RFC3339), e.Version, e.GroupId, e.EventTypeName,
+				e.ResultName, e.ErrorCode, e.InstallSource, note)
 		}
+	})
+	if err != nil {
+		return handleError(err)
 	}
-
-	return req
+	return OK
 }
 
-func (c *Client) MakeRequest(otype, result string, updateCheck, isPing bool) (*omaha.Response, error) {
-	client := &http.Client{}
-	req := c.OmahaRequest(otype, result, updateCheck, isPing)
-	raw, err := xml.MarshalIndent(req, "", " ")
-	if err != nil {
-		return nil, err
+func randomHex(n int) string {
+	rand.Seed(time.Now().UnixNano())
+
+	chars := "abcdef0123456789"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = chars[rand.Intn(len(chars))]
 	}
+	return string(b)
+}
 
-	resp, err := client.Post(c.config.server+"/v1/update/", "text/xml", bytes.NewReader(raw))
-	if err != nil {
-		return nil, err
+// flagScenario returns the scenario of one population described by the
+// flags of instance fake.
+func flagScenario() *omahaclient.Scenario {
+	return &omahaclient.Scenario{
+		Populations: []*omahaclient.Population{{
+			Count:       instanceFlags.clientsPerApp,
+			AppId:       instanceFlags.appId.String(),
+			Group:       instanceFlags.groupId.String(),
+			Version:     instanceFlags.version,
+			OEMs:        map[string]int{instanceFlags.OEM: 1},
+			ForceUpdate: instanceFlags.forceUpdate,
+			CheckInterval: omahaclient.Range{
+				Min: omahaclient.Duration(time.Duration(instanceFlags.minSleep) * time.Second),
+				Max: omahaclient.Duration(time.Duration(instanceFlags.maxSleep) * time.Second),
+			},
+			RebootPings: instanceFlags.pingOnly,
+		}},
 	}
-	defer resp.Body.Close()
+}
 
-	oresp := new(omaha.Response)
-	err = xml.NewDecoder(resp.Body).Decode(oresp)
+func readScenario(name string) (*omahaclient.Scenario, error) {
+	if name == "-" {
+		return omahaclient.ReadScenario(os.Stdin)
+	}
+	f, err := os.Open(name)
 	if err != nil {
 		return nil, err
 	}
-
-	if instanceFlags.verbose {
-		raw, _ := xml.MarshalIndent(req, "", " ")
-		c.Log("request: %s\n", string(raw))
-		raw, _ = xml.MarshalIndent(oresp, "", " ")
-		c.Log("response: %s\n", string(raw))
-	}
-
-	return oresp, nil
+	defer f.Close()
+	return omahaclient.ReadScenario(f)
 }
 
-func (c *Client) SetVersion(resp *omaha.Response) {
-	// A field can potentially be nil.
-	defer func() {
-		if err := recover(); err != nil {
-			c.Log("%s: error setting version: %v\n", c.Id, err)
+func instanceFake(args []string, service *update.Service, out *tabwriter.Writer) int {
+	var scenario *omahaclient.Scenario
+	if instanceFlags.scenario != "" {
+		var err error
+		scenario, err = readScenario(instanceFlags.scenario)
+		if err != nil {
+			log.Printf("reading scenario: %v", err)
+			return ERROR_USAGE
 		}
-	}()
-
-	uc := resp.Apps[0].UpdateCheck
-	if uc.Status != "ok" {
-		c.Log("%s\n", uc.Status)
-		return
-	}
-
-	randFailRequest := func(eventType, eventResult string) (failed bool, err error) {
-		if rand.Intn(100) <= c.errorRate {
-			eventType = "3"
-			eventResult = "0"
-			failed = true
+	} else {
+		if instanceFlags.appId.Get() == nil || instanceFlags.groupId.Get() == nil {
+			return ERROR_USAGE
+		}
+		scenario = flagScenario()
+		if err := scenario.Validate(); err != nil {
+			log.Print(err)
+			return ERROR_USAGE
 		}
-		_, err = c.MakeRequest(eventType, eventResult, false, false)
-		return
 	}
 
-	requests := [][]string{
-		[]string{"13", "1"}, // downloading
-		[]string{"14", "1"}, // downloaded
-		[]string{"3", "1"},  // installed
+	if instanceFlags.duration < 0 {
+		return ERROR_USAGE
 	}
 
-	for i, r := range requests {
-		if i > 0 {
-			time.Sleep(1 * time.Second)
-		}
-		failed, err := randFailRequest(r[0], r[1])
-		if failed {
-			log.Printf("failed to update in eventType: %s, eventResult: %s. Retrying.", r[0], r[1])
-			time.Sleep(time.Second * time.Duration(instanceFlags.minSleep))
-			c.MakeRequest(r[0], r[1], false, false)
-			return
-		}
-		if err != nil {
-			log.Println(err)
-			return
-		}
+	logOut := os.Stdout
+	if machineOutput() {
+		logOut = os.Stderr
 	}
 
-	// simulate reboot lock for a while
-	for c.pingsRemaining > 0 {
-		c.MakeRequest("", "", false, true)
-		c.pingsRemaining--
-		time.Sleep(1 * time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	if instanceFlags.duration > 0 {
+		var done context.CancelFunc
+		ctx, done = context.WithTimeout(ctx, time.Duration(instanceFlags.duration)*time.Second)
+		defer done()
 	}
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-	c.Log("updated from %s to %s\n", c.Version, uc.Manifest.Version)
+	stats := omahaclient.NewStats()
 
-	c.Version = uc.Manifest.Version
+	// generate a prefix with a well-known string and a constant sequence of hex
+	// this lets us easily recognize fake instances
+	// it still has to be a valid uuid though
+	prefix := "deadbeef" + randomHex(6)
 
-	_, err := c.MakeRequest("3", "2", false, false) // Send complete with new version.
-	if err != nil {
-		log.Println(err)
+	var wg sync.WaitGroup
+	for _, p := range scenario.Populations {
+		for i := 0; i < p.Count; i++ {
+			id := prefix + strings.Replace(uuid.New(), "-", "", -1)[14:]
+			logf := func(format string, v ...interface{}) {
+				fmt.Fprintf(logOut, id+": "+format, v...)
+			}
+
+			c := p.NewFake(globalFlags.Server, id)
+			if instanceFlags.scenario == "" {
+				// keep the percentage based error rate of the flags
+				c.FailureRates = nil
+				c.ErrorRate = instanceFlags.errorRate
+			}
+			c.Log = logf
+			c.Stats = stats
+			if instanceFlags.verbose {
+				c.Client.Logf = logf
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.Run(ctx)
+			}()
+		}
 	}
 
-	c.SessionId = uuid.New()
+	if instanceFlags.untilVersion != "" {
+		total := scenario.Total()
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				break wait
+			case <-tick.C:
+				if stats.Reached(instanceFlags.untilVersion) == total {
+					cancel()
+					break wait
+				}
+			}
+		}
+	}
+	<-ctx.Done()
+	timedOut := ctx.Err() == context.DeadlineExceeded
+	wg.Wait()
+
+	report := stats.Report()
+	err := printResult(out, report, func(out *tabwriter.Writer) {
+		fmt.Fprintf(out, "Duration:\t%s\n", time.Duration(report.Duration*float64(time.Second)).Truncate(time.Second))
+		fmt.Fprintf(out, "Instances:\t%d\n", report.Clients)
+		fmt.Fprintf(out, "Requests:\t%d\n", report.Requests)
+		fmt.Fprintf(out, "Request errors:\t%d\n", report.Errors)
+		fmt.Fprintf(out, "Latency (ms):\tp50=%.1f p90=%.1f p99=%.1f max=%.1f\n",
+			report.Latency.P50, report.Latency.P90, report.Latency.P99, report.Latency.Max)
+		fmt.Fprintf(out, "Updates succeeded:\t%d\n", report.UpdatesSuccess)
+		fmt.Fprintf(out, "Updates failed:\t%d\n", report.UpdatesFailed)
+		fmt.Fprintf(out, "Versions:\t%s\n", formatDistribution(report.Versions))
+		fmt.Fprintf(out, "Update check statuses:\t%s\n", formatDistribution(report.Statuses))
+	})
+	if err != nil {
+		return handleError(err)
+	}
+	if reached := stats.Reached(instanceFlags.untilVersion); instanceFlags.untilVersion != "" && timedOut && reached < scenario.Total() {
+		log.Printf("only %d of %d instances reached %s within %ds",
+			reached, scenario.Total(), instanceFlags.untilVersion, instanceFlags.duration)
+		return ERROR_TIMEOUT
+	}
+	return OK
 }
 
-// Sleep between n and m seconds
-func (c *Client) Loop(n, m int) {
-	for {
-		randSleep(n, m)
+func instanceRecord(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if instanceFlags.file == "" {
+		return ERROR_USAGE
+	}
 
-		resp, err := c.MakeRequest("3", "2", true, false)
+	w := os.Stdout
+	if instanceFlags.file != "-" {
+		f, err := os.OpenFile(instanceFlags.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 		if err != nil {
-			log.Println(err)
-			continue
+			return handleError(err)
 		}
-		c.SetVersion(resp)
+		defer f.Close()
+		w = f
 	}
-}
 
-// Sleeps randomly between n and m seconds.
-func randSleep(n, m int) {
-	r := m
-	if m-n > 0 {
-		r = rand.Intn(m-n) + n
-	}
-	time.Sleep(time.Duration(r) * time.Second)
-}
+	recorder := omahaclient.NewRecorder(globalFlags.Server, w)
+	server := &http.Server{Addr: instanceFlags.listen, Handler: recorder}
 
-func randomHex(n int) string {
-	rand.Seed(time.Now().UnixNano())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
 
-	chars := "abcdef0123456789"
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = chars[rand.Intn(len(chars))]
+	log.Printf("recording Omaha requests to %s on %s", globalFlags.Server, instanceFlags.listen)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return handleError(err)
 	}
-	return string(b)
+	log.Printf("recorded %d requests", recorder.Records())
+	return OK
 }
 
-func instanceFake(args []string, service *update.Service, out *tabwriter.Writer) int {
-	if instanceFlags.appId.Get() == nil || instanceFlags.groupId.Get() == nil {
-		return ERROR_USAGE
-	}
+// replaySummary counts the outcomes of a replay.
+type replaySummary struct {
+	Requests int `json:"requests"`
+	Matched  int `json:"matched"`
+	Differed int `json:"differed"`
+	Failed   int `json:"failed"`
+}
 
-	conf := &serverConfig{
-		server: globalFlags.Server,
+func instanceReplay(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if instanceFlags.file == "" || instanceFlags.speed < 0 {
+		return ERROR_USAGE
 	}
 
-	// generate a prefix with a well-known string and a constant sequence of hex
-	// this lets us easily recognize fake instances
-	// it still has to be a valid uuid though
-	prefix := "deadbeef" + randomHex(6)
+	in := os.Stdin
+	if instanceFlags.file != "-" {
+		f, err := os.Open(instanceFlags.file)
+		if err != nil {
+			return handleError(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-	for i := 0; i < instanceFlags.clientsPerApp; i++ {
-		c := &Client{
-			Id:             prefix + strings.Replace(uuid.New(), "-", "", -1)[14:],
-			SessionId:      uuid.New(),
-			Version:        instanceFlags.version,
-			AppId:          instanceFlags.appId.String(),
-			Track:          instanceFlags.groupId.String(),
-			config:         conf,
-			errorRate:      instanceFlags.errorRate,
-			pingsRemaining: instanceFlags.pingOnly,
-			forceUpdate:    instanceFlags.forceUpdate,
+	replayer := &omahaclient.Replayer{
+		Server: globalFlags.Server,
+		Speed:  instanceFlags.speed,
+	}
+	summary := &replaySummary{}
+	err := replayer.Replay(ctx, in, func(r *omahaclient.ReplayResult) {
+		summary.Requests++
+		switch {
+		case r.Err != nil:
+			summary.Failed++
+			log.Printf("request %d recorded at %s failed: %v", summary.Requests, r.Record.Time.Format(time.RFC3339), r.Err)
+		case r.Match:
+			summary.Matched++
+		default:
+			summary.Differed++
+			log.Printf("response %d recorded at %s differs:\nrequest:\n%s\nrecorded (HTTP %d):\n%s\nreplayed (HTTP %d):\n%s",
+				summary.Requests, r.Record.Time.Format(time.RFC3339), r.Record.Request,
+				r.Record.Status, omahaclient.NormalizeResponse(r.Record.Response),
+				r.Status, omahaclient.NormalizeResponse(r.Response))
 		}
-		go c.Loop(instanceFlags.minSleep, instanceFlags.maxSleep)
+	})
+	if err != nil && err != context.Canceled {
+		return handleError(err)
 	}
 
-	// run forever
-	wait := make(chan bool)
-	<-wait
+	err = printResult(out, summary, func(out *tabwriter.Writer) {
+		fmt.Fprintf(out, "Requests:\t%d\n", summary.Requests)
+		fmt.Fprintf(out, "Matched:\t%d\n", summary.Matched)
+		fmt.Fprintf(out, "Differed:\t%d\n", summary.Differed)
+		fmt.Fprintf(out, "Failed:\t%d\n", summary.Failed)
+	})
+	if err != nil {
+		return handleError(err)
+	}
+	if summary.Differed > 0 || summary.Failed > 0 {
+		return ERROR_DRIFT
+	}
 	return OK
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/mockserver"
+)
+
+func TestAnnotateHistory(t *testing.T) {
+	now := time.Unix(100000, 0)
+	item := func(at int64, eventType, result string) *update.ClientHistoryItem {
+		return &update.ClientHistoryItem{
+			DateTime:    at,
+			EventType:   eventType,
+			EventResult: result,
+			Version:     "2.0.0",
+		}
+	}
+
+	// unsorted on purpose
+	items := []*update.ClientHistoryItem{
+		item(1200, "14", "1"),
+		item(1000, "13", "1"),
+		item(2000, "13", "1"),
+		item(9000, "3", "0"),
+		item(10000, "13", "1"),
+		item(99000, "13", "1"),
+	}
+
+	events := annotateHistory(items, time.Hour, now)
+
+	expected := []struct {
+		at       int64
+		typeName string
+		failed   bool
+		stuck    bool
+	}{
+		{1000, "update download started", false, false},
+		{1200, "update download finished", false, false},
+		// abandoned by a failed update after more than an hour
+		{2000, "update download started", false, true},
+		{9000, "update complete", true, false},
+		// abandoned by the next download after more than an hour
+		{10000, "update download started", false, true},
+		// still running, but started less than an hour ago
+		{99000, "update download started", false, false},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(events))
+	}
+	for i, e := range expected {
+		got := events[i]
+		if got.Time.Unix() != e.at || got.EventTypeName != e.typeName ||
+			got.Failed != e.failed || got.Stuck != e.stuck {
+			t.Errorf("event %d: expected %d %q failed=%v stuck=%v, got %d %q failed=%v stuck=%v",
+				i, e.at, e.typeName, e.failed, e.stuck,
+				got.Time.Unix(), got.EventTypeName, got.Failed, got.Stuck)
+		}
+	}
+	if events[3].ResultName != "error" {
+		t.Errorf("expected result name error, got %q", events[3].ResultName)
+	}
+}
+
+func TestSortByLastSeen(t *testing.T) {
+	updates := []*update.ClientUpdate{
+		{ClientId: "a", LastSeen: "2016-01-01 10:00:00"},
+		{ClientId: "b", LastSeen: "not a time"},
+		{ClientId: "c", LastSeen: "2016-01-01T12:00:00Z"},
+		{ClientId: "d", LastSeen: "2016-01-01 11:00:00.5 +0000 UTC"},
+		{ClientId: "e", LastSeen: ""},
+	}
+	sortByLastSeen(updates)
+
+	var got string
+	for _, cl := range updates {
+		got += cl.ClientId
+	}
+	if got != "cdabe" {
+		t.Errorf("expected the order cdabe, got %s", got)
+	}
+}
+
+func TestClientUpdateFilters(t *testing.T) {
+	queries := make(map[string]string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		q.Del("alt")
+		q.Del("limit")
+		q.Del("skip")
+		queries[r.URL.Path] = q.Encode()
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+	service, _ := update.New(http.DefaultClient)
+	service.BasePath = ts.URL + "/"
+
+	instanceFlags.appId.Set("app")
+	instanceFlags.oem.Set("azure")
+	instanceFlags.start = 100
+	defer func() {
+		instanceFlags.appId, instanceFlags.oem = StringFlag{}, StringFlag{}
+		instanceFlags.start = 0
+	}()
+
+	if err := listClientUpdates(service, 10, 0, func([]*update.ClientUpdate) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	out := tabwriter.NewWriter(ioutil.Discard, 0, 8, 1, '\t', 0)
+	if code := instanceCount(nil, service, out); code != OK {
+		t.Fatalf("expected instance count to succeed, got %d", code)
+	}
+	want := "appId=app&dateStart=100&oem=azure"
+	if queries["/clientupdates"] != want || queries["/clientupdatecount"] != want {
+		t.Errorf("expected list and count to filter by %q, got %q", want, queries)
+	}
+}
+
+func TestInstanceFakeUntilVersion(t *testing.T) {
+	s := mockserver.New()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	service := s.Service(ts.URL)
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "1.0.0"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable"}).Do()
+
+	saved, server, output, savedStdout := instanceFlags, globalFlags.Server, globalFlags.Output, stdout
+	defer func() {
+		instanceFlags, globalFlags.Server, globalFlags.Output, stdout = saved, server, output, savedStdout
+	}()
+	globalFlags.Server, globalFlags.Output, stdout = ts.URL, outputJSON, ioutil.Discard
+	instanceFlags.appId.Set("app")
+	instanceFlags.groupId.Set("prod")
+	instanceFlags.clientsPerApp = 2
+	instanceFlags.version = "1.0.0"
+	instanceFlags.minSleep, instanceFlags.maxSleep = 1, 1
+	instanceFlags.duration = 2
+
+	out := tabwriter.NewWriter(ioutil.Discard, 0, 8, 1, '\t', 0)
+	instanceFlags.untilVersion = "1.0.0"
+	if exit := instanceFake(nil, service, out); exit != OK {
+		t.Errorf("expected instances already at the version to succeed, got exit %d", exit)
+	}
+	// no update to 2.0.0 is offered
+	instanceFlags.untilVersion = "2.0.0"
+	if exit := instanceFake(nil, service, out); exit != ERROR_TIMEOUT {
+		t.Errorf("expected running out of time to fail, got exit %d", exit)
+	}
+}
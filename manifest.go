@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/rollout"
+)
+
+// manifestVersion is the version of the manifest format written by
+// export. Manifests without a version are treated as version 1.
+const manifestVersion = 1
+
+// Manifest describes the desired state of applications on an update
+// server. It is read from a YAML or JSON file by the apply, diff and import
+// commands and written by export.
+type Manifest struct {
+	Version   int                 `json:"version,omitempty"`
+	Apps      []*ManifestApp      `json:"apps"`
+	Upstreams []*ManifestUpstream `json:"upstreams,omitempty"`
+}
+
+type ManifestApp struct {
+	Id          string             `json:"id"`
+	Label       string             `json:"label,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Channels    []*ManifestChannel `json:"channels,omitempty"`
+	Groups      []*ManifestGroup   `json:"groups,omitempty"`
+
+	// Packages are only ever created, existing packages are left as
+	// they are.
+	Packages []*update.Package `json:"packages,omitempty"`
+}
+
+type ManifestChannel struct {
+	Label   string `json:"label"`
+	Version string `json:"version"`
+	Publish bool   `json:"publish,omitempty"`
+
+	// Upstream is the upstream the channel follows. Channels are bound
+	// to upstreams by syncing them; the binding is left untouched when
+	// it is not set.
+	Upstream string `json:"upstream,omitempty"`
+}
+
+type ManifestGroup struct {
+	Id           string   `json:"id"`
+	Label        string   `json:"label,omitempty"`
+	Channel      string   `json:"channel"`
+	OemBlacklist []string `json:"oemBlacklist,omitempty"`
+
+	// Paused, UpdatePercent and Rollout are left untouched on the
+	// server when they are not set.
+	Paused        *bool            `json:"paused,omitempty"`
+	UpdatePercent *float64         `json:"updatePercent,omitempty"`
+	Rollout       *ManifestRollout `json:"rollout,omitempty"`
+}
+
+// ManifestRollout describes a rollout either as an explicit list of frames
+// or with the parameters of a linear rollout.
+type ManifestRollout struct {
+	Active bool             `json:"active"`
+	Frames []*ManifestFrame `json:"frames,omitempty"`
+	Linear *ManifestLinear  `json:"linear,omitempty"`
+}
+
+type ManifestFrame struct {
+	Percent  float64 `json:"percent"`
+	Duration int64   `json:"duration"`
+}
+
+type ManifestLinear struct {
+	FrameSize int64 `json:"frameSize"`
+	Duration  int64 `json:"duration"`
+}
+
+// ManifestUpstream is an upstream update service, identified by its URL.
+type ManifestUpstream struct {
+	Url   string `json:"url"`
+	Label string `json:"label,omitempty"`
+}
+
+// loadManifest reads a manifest from path, or from stdin if path is "-".
+func loadManifest(path string) (*Manifest, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := new(Manifest)
+	if err := yaml.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("parsing %s failed: %v", path, err)
+	}
+	if err := manifest.validate(); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", path, err)
+	}
+	return manifest, nil
+}
+
+func (m *Manifest) validate() error {
+	if m.Version > manifestVersion {
+		return fmt.Errorf("unsupported version %d (this %s supports up to %d)",
+			m.Version, cliName, manifestVersion)
+	}
+
+	apps := make(map[string]bool)
+	for _, app := range m.Apps {
+		if app.Id == "" {
+			return fmt.Errorf("app without id")
+		}
+		if apps[app.Id] {
+			return fmt.Errorf("app %s listed more than once", app.Id)
+		}
+		apps[app.Id] = true
+
+		channels := make(map[string]bool)
+		for _, channel := range app.Channels {
+			if channel.Label == "" {
+				return fmt.Errorf("app %s: channel without label", app.Id)
+			}
+			if channels[channel.Label] {
+				return fmt.Errorf("app %s: channel %s listed more than once", app.Id, channel.Label)
+			}
+			channels[channel.Label] = true
+		}
+
+		groups := make(map[string]bool)
+		for _, group := range app.Groups {
+			if group.Id == "" {
+				return fmt.Errorf("app %s: group without id", app.Id)
+			}
+			if groups[group.Id] {
+				return fmt.Errorf("app %s: group %s listed more than once", app.Id, group.Id)
+			}
+			groups[group.Id] = true
+			if group.Channel == "" {
+				return fmt.Errorf("app %s: group %s has no channel", app.Id, group.Id)
+			}
+			if p := group.UpdatePercent; p != nil && (*p < 0 || *p > 100) {
+				return fmt.Errorf("app %s: group %s: update percent must be between 0 and 100", app.Id, group.Id)
+			}
+			if r := group.Rollout; r != nil {
+				if (r.Linear == nil) == (len(r.Frames) == 0) {
+					return fmt.Errorf("app %s: group %s: rollout needs either frames or linear", app.Id, group.Id)
+				}
+				frames, err := r.frames()
+				if err == nil {
+					err = rollout.Validate(frames)
+				}
+				if err != nil {
+					return fmt.Errorf("app %s: group %s: %v", app.Id, group.Id, err)
+				}
+			}
+		}
+
+		packages := make(map[string]bool)
+		for _, pkg := range app.Packages {
+			if pkg.Version == "" {
+				return fmt.Errorf("app %s: package without version", app.Id)
+			}
+			if packages[pkg.Version] {
+				return fmt.Errorf("app %s: package %s listed more than once", app.Id, pkg.Version)
+			}
+			packages[pkg.Version] = true
+		}
+	}
+
+	upstreams := make(map[string]bool)
+	for _, upstream := range m.Upstreams {
+		if upstream.Url == "" {
+			return fmt.Errorf("upstream without url")
+		}
+		if upstreams[upstream.Url] {
+			return fmt.Errorf("upstream %s listed more than once", upstream.Url)
+		}
+		upstreams[upstream.Url] = true
+	}
+	return nil
+}
+
+// oemBlacklist returns the blacklist in the comma separated form the
+// server uses.
+func (g *ManifestGroup) oemBlacklist() string {
+	return strings.Join(g.OemBlacklist, ",")
+}
+
+// frames returns the rollout frames described by r.
+func (r *ManifestRollout) frames() ([]*update.Frame, error) {
+	if r.Linear != nil {
+		linear, err := rollout.Linear("", "", r.Linear.FrameSize, r.Linear.Duration)
+		if err != nil {
+			return nil, err
+		}
+		return linear.Rollout, nil
+	}
+
+	frames := make([]*update.Frame, len(r.Frames))
+	for i, f := range r.Frames {
+		frames[i] = &update.Frame{
+			Percent:  f.Percent,
+			Duration: f.Duration,
+		}
+	}
+	return frames, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/mockserver"
+)
+
+var (
+	mockServerFlags struct {
+		listen string
+	}
+
+	cmdMockServer = &Command{
+		Name:    "mock-server",
+		Usage:   "[OPTION]...",
+		Summary: "Run an in-memory update service for development.",
+		Description: `Serve the update service JSON API, the Omaha endpoint used by machines and
+package uploads from memory, for developing scripts and trying commands
+without a real update service. Everything is lost when the server stops.
+
+Credentials are not checked. Rollouts advance with the time since they were
+activated, and machines are offered the version of the channel of their
+group if they are within the update percent. Point other commands at it
+with --server, e.g.:
+
+	updateservicectl mock-server --listen 127.0.0.1:8080 &
+	updateservicectl --server http://127.0.0.1:8080 apply --file config.yaml
+	updateservicectl --server http://127.0.0.1:8080 instance fake ...
+
+Go tests can run the same server with the mockserver package.`,
+		Run: runMockServer,
+	}
+)
+
+func init() {
+	cmdMockServer.Flags.StringVar(&mockServerFlags.listen, "listen", "127.0.0.1:8080", "Address to serve on.")
+}
+
+func runMockServer(args []string, service *update.Service, out *tabwriter.Writer) int {
+	server := &http.Server{Addr: mockServerFlags.listen, Handler: mockserver.New()}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		ctx, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		server.Shutdown(ctx)
+	}()
+
+	log.Printf("serving the update service on %s", mockServerFlags.listen)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return handleError(err)
+	}
+	return OK
+}
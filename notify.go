@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/notify"
+)
+
+var (
+	notifyFlags struct {
+		appIds         string
+		webhooks       string
+		command        string
+		events         string
+		interval       int64
+		errorThreshold int64
+	}
+
+	cmdNotify = &Command{
+		Name:    "notify",
+		Usage:   "[OPTION]...",
+		Summary: "Send notifications when channels, groups or rollouts change.",
+		Description: `Poll the update service every --interval seconds and send an event when
+the version of a channel changes, updates of a group are paused or unpaused,
+a rollout starts, finishes or is stopped, or at least --error-threshold
+updates of a version in a group failed since the previous poll.
+
+Events are posted as JSON to every --webhook URL, and passed as JSON on the
+standard input of --exec, which is run with sh -c and the event in the
+UPDATE_SERVICE_EVENT, UPDATE_SERVICE_APP_ID, UPDATE_SERVICE_GROUP_ID,
+UPDATE_SERVICE_CHANNEL, UPDATE_SERVICE_VERSION, UPDATE_SERVICE_OLD_VERSION
+and UPDATE_SERVICE_TEXT environment variables. The text field of events is
+a message for humans, which chat webhooks show as is.
+
+Event types: channel_version_changed, group_paused, group_unpaused,
+rollout_started, rollout_finished, rollout_stopped and error_spike.`,
+		Run: runNotify,
+	}
+)
+
+func init() {
+	cmdNotify.Flags.StringVar(&notifyFlags.appIds, "app-id", "", "Comma separated list of apps to watch, all apps if empty.")
+	cmdNotify.Flags.StringVar(&notifyFlags.webhooks, "webhook", "", "Comma separated list of URLs to post events to.")
+	cmdNotify.Flags.StringVar(&notifyFlags.command, "exec", "", "Command to run for every event.")
+	cmdNotify.Flags.StringVar(&notifyFlags.events, "events", "", "Comma separated list of event types to send, all if empty.")
+	cmdNotify.Flags.Int64Var(&notifyFlags.interval, "interval", 60, "Seconds between polls of the update service.")
+	cmdNotify.Flags.Int64Var(&notifyFlags.errorThreshold, "error-threshold", 10, "Failed updates of a version in a group between polls which make an error spike.")
+}
+
+func runNotify(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if notifyFlags.interval <= 0 || notifyFlags.errorThreshold <= 0 {
+		return ERROR_USAGE
+	}
+
+	var sinks []notify.Sink
+	for _, url := range splitList(notifyFlags.webhooks) {
+		sinks = append(sinks, &notify.Webhook{URL: url})
+	}
+	if notifyFlags.command != "" {
+		sinks = append(sinks, &notify.Command{Command: notifyFlags.command})
+	}
+	if len(sinks) == 0 {
+		log.Print("at least one of --webhook and --exec is required")
+		return ERROR_USAGE
+	}
+
+	types := splitList(notifyFlags.events)
+	for _, typ := range types {
+		if !knownEventType(typ) {
+			log.Printf("unknown event type %q", typ)
+			return ERROR_USAGE
+		}
+	}
+
+	w := &notify.Watcher{
+		Service:        service,
+		AppIds:         splitList(notifyFlags.appIds),
+		Types:          types,
+		ErrorThreshold: notifyFlags.errorThreshold,
+		Interval:       time.Duration(notifyFlags.interval) * time.Second,
+		Sinks:          sinks,
+		Logf:           log.Printf,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if err := w.Run(ctx); err != nil && err != context.Canceled {
+		return handleError(err)
+	}
+	return OK
+}
+
+func knownEventType(typ string) bool {
+	for _, t := range notify.EventTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	outputTable    = "table"
+	outputJSON     = "json"
+	outputYAML     = "yaml"
+	outputTemplate = "template"
+)
+
+var outputTmpl *template.Template
+
+// stdout receives the json, yaml and template output, which must not go
+// through the tabwriter: it would align tabs in the output as columns.
+var stdout io.Writer = os.Stdout
+
+// validateOutputFlags checks the --output and --template global flags and
+// prepares the template used by the template output format.
+func validateOutputFlags() error {
+	switch globalFlags.Output {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	case outputTemplate:
+		if globalFlags.Template == "" {
+			return fmt.Errorf("--output=%s requires --template", outputTemplate)
+		}
+		tmpl, err := template.New("output").Parse(globalFlags.Template)
+		if err != nil {
+			return fmt.Errorf("invalid --template: %v", err)
+		}
+		outputTmpl = tmpl
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q (expected %s, %s, %s or %s)",
+		globalFlags.Output, outputTable, outputJSON, outputYAML, outputTemplate)
+}
+
+// machineOutput reports whether the user asked for something other than
+// the human readable table output.
+func machineOutput() bool {
+	return globalFlags.Output != outputTable
+}
+
+// printResult writes the result of a command in the format selected with
+// --output. Objects are encoded using the field names of the generated
+// update client types and written to stdout after anything pending in out.
+// In table mode, table is called to write the usual tab separated rows to
+// out instead.
+func printResult(out *tabwriter.Writer, v interface{}, table func(out *tabwriter.Writer)) error {
+	defer out.Flush()
+
+	switch globalFlags.Output {
+	case outputJSON:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		out.Flush()
+		fmt.Fprintf(stdout, "%s\n", b)
+	case outputYAML:
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		out.Flush()
+		fmt.Fprintf(stdout, "%s", b)
+	case outputTemplate:
+		out.Flush()
+		if err := outputTmpl.Execute(stdout, v); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout)
+	default:
+		table(out)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"text/tabwriter"
+)
+
+func TestPrintResult(t *testing.T) {
+	savedOutput, savedTemplate, savedStdout := globalFlags.Output, globalFlags.Template, stdout
+	defer func() {
+		globalFlags.Output, globalFlags.Template, stdout = savedOutput, savedTemplate, savedStdout
+	}()
+	var table, machine bytes.Buffer
+	stdout = &machine
+	out := tabwriter.NewWriter(&table, 0, 8, 1, '\t', 0)
+
+	rows := []string{"a", "abcdefghij"}
+	for _, c := range []struct {
+		output, template string
+		want             string
+	}{
+		{outputJSON, "", "[\n  \"a\",\n  \"abcdefghij\"\n]\n"},
+		{outputYAML, "", "- a\n- abcdefghij\n"},
+		// tabs in the template output are not aligned as columns
+		{outputTemplate, "{{range .}}{{.}}\t|\n{{end}}", "a\t|\nabcdefghij\t|\n\n"},
+	} {
+		globalFlags.Output, globalFlags.Template = c.output, c.template
+		if err := validateOutputFlags(); err != nil {
+			t.Fatal(err)
+		}
+		machine.Reset()
+		err := printResult(out, rows, func(out *tabwriter.Writer) {
+			t.Errorf("%s: expected no table", c.output)
+		})
+		if err != nil || machine.String() != c.want {
+			t.Errorf("%s: expected %q, got %q, %v", c.output, c.want, machine.String(), err)
+		}
+	}
+	if table.Len() != 0 {
+		t.Errorf("expected nothing written to the table, got %q", table.String())
+	}
+
+	globalFlags.Output = outputTable
+	machine.Reset()
+	printResult(out, rows, func(out *tabwriter.Writer) {
+		for _, row := range rows {
+			out.Write([]byte(row + "\t|\n"))
+		}
+	})
+	if want := "a\t\t|\nabcdefghij\t|\n"; table.String() != want || machine.Len() != 0 {
+		t.Errorf("expected the table %q, got %q", want, table.String())
+	}
+}
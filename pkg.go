@@ -1,17 +1,11 @@
 package main
 
 import (
-	"bytes"
-	"crypto/sha1"
-	"crypto/sha256"
-	"encoding/base64"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
-	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
@@ -26,6 +20,7 @@ import (
 	"github.com/coreos/go-semver/semver"
 
 	update "github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/packages"
 )
 
 type MetadataFile struct {
@@ -167,32 +162,34 @@ func formatPackage(pkg *update.Package) string {
 	return fmt.Sprintf("%s\t%s\t%s\n", pkg.Version, pkg.Url, pkg.Size)
 }
 
+func printPackage(out *tabwriter.Writer, pkg *update.Package) error {
+	return printResult(out, pkg, func(out *tabwriter.Writer) {
+		fmt.Fprint(out, packageHeader)
+		fmt.Fprintf(out, "%s", formatPackage(pkg))
+	})
+}
+
 func packageCreate(args []string, service *update.Service, out *tabwriter.Writer) int {
 	if packageFlags.appId.Get() == nil ||
 		packageFlags.version.Get() == nil {
 		return ERROR_USAGE
 	}
 
-	file := packageFlags.file
-	info, err := os.Stat(file)
+	pkg, err := packages.FromFile(packageFlags.file)
 	if err != nil {
-		log.Fatalf("stat of %s failed: %v", file, err)
-	}
-	content, err := ioutil.ReadFile(file)
-	if err != nil {
-		log.Fatalf("reading %s failed: %v", file, err)
+		return handleError(err)
 	}
 
 	metaFile := packageFlags.meta
 	var meta MetadataFile
 	if metaFile != "" {
-		content, err = ioutil.ReadFile(metaFile)
+		content, err := ioutil.ReadFile(metaFile)
 		if err != nil {
-			log.Fatalf("reading %s failed: %v", metaFile, err)
+			return handleError(fmt.Errorf("reading %s failed: %v", metaFile, err))
 		}
 		err = json.Unmarshal(content, &meta)
 		if err != nil {
-			log.Fatalf("reading %s failed: %v", metaFile, err)
+			return handleError(fmt.Errorf("reading %s failed: %v", metaFile, err))
 		}
 	}
 
@@ -201,118 +198,42 @@ func packageCreate(args []string, service *update.Service, out *tabwriter.Writer
 	if releaseNotesFile != "" {
 		notes, err = ioutil.ReadFile(releaseNotesFile)
 		if err != nil {
-			log.Fatalf("reading %s failed: %v", releaseNotesFile, err)
+			return handleError(fmt.Errorf("reading %s failed: %v", releaseNotesFile, err))
 		}
 	}
 
-	var sha1base64 bytes.Buffer
-	encoder := base64.NewEncoder(base64.StdEncoding, &sha1base64)
-	sha1h := sha1.New()
-	io.Copy(sha1h, bytes.NewReader(content))
-	encoder.Write(sha1h.Sum(nil))
-	encoder.Close()
-
-	var sha256base64 bytes.Buffer
-	encoder = base64.NewEncoder(base64.StdEncoding, &sha256base64)
-	sha256h := sha256.New()
-	io.Copy(sha256h, bytes.NewReader(content))
-	encoder.Write(sha256h.Sum(nil))
-	encoder.Close()
+	pkg.Url = packageFlags.url
+	pkg.MetadataSignatureRsa = meta.MetadataSignatureRsa
+	pkg.MetadataSize = meta.MetadataSize
+	pkg.ReleaseNotes = string(notes)
 
-	pkg := &update.Package{
-		Url:                  packageFlags.url,
-		Size:                 strconv.FormatInt(info.Size(), 10),
-		Sha1Sum:              sha1base64.String(),
-		Sha256Sum:            sha256base64.String(),
-		MetadataSignatureRsa: meta.MetadataSignatureRsa,
-		MetadataSize:         meta.MetadataSize,
-		ReleaseNotes:         string(notes),
+	if !machineOutput() {
+		jbytes, _ := json.MarshalIndent(pkg, "", " ")
+		fmt.Printf("%s\n", string(jbytes))
 	}
 
-	jbytes, _ := json.MarshalIndent(pkg, "", " ")
-	fmt.Printf("%s\n", string(jbytes))
-
 	call := service.App.Package.Insert(packageFlags.appId.String(), packageFlags.version.String(), pkg)
 	pkg, err = call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, packageHeader)
-	fmt.Fprintf(out, "%s", formatPackage(pkg))
-
-	out.Flush()
+	if err := printPackage(out, pkg); err != nil {
+		return handleError(err)
+	}
 	return OK
 }
 
 func uploadPayload(service *update.Service, file string) error {
-	if file == "" {
-		return errors.New("missing file argument")
-	}
-
-	fpath, err := filepath.Abs(file)
-	if err != nil {
-		return err
-	}
-
-	f, err := os.Open(fpath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	pipeOut, pipeIn := io.Pipe()
-
-	writer := multipart.NewWriter(pipeIn)
-	errChan := make(chan error, 1)
-	go func() {
-		defer pipeIn.Close()
-		part, _ := writer.CreateFormFile("file", filepath.Base(fpath))
-		if _, err := io.Copy(part, f); err != nil {
-			errChan <- err
-			return
-		}
-		errChan <- writer.Close()
-	}()
-
-	req, err := http.NewRequest("POST", globalFlags.Server+"/package-upload", pipeOut)
-	if err != nil {
-		return err
-	}
-
-	req.Header.Add("Content-Type", writer.FormDataContentType())
-
 	client := getHawkClient(globalFlags.User, globalFlags.Key)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-
-	if resp == nil || resp.Body == nil {
-		return errors.New("server did not respond")
-	}
-	defer func() {
-		if resp.Body != nil {
-			resp.Body.Close()
-		}
-	}()
-
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("error uploading file %s", string(respBody))
-	}
-
-	return <-errChan
+	return packages.Upload(context.Background(), client, globalFlags.Server, file)
 }
 
 func packageUploadPayload(args []string, service *update.Service, out *tabwriter.Writer) int {
 	err := uploadPayload(service, packageFlags.file)
 	if err != nil {
-		fmt.Println("error uploading file")
-		fmt.Print(err)
-		return ERROR_USAGE
+		return handleError(fmt.Errorf("error uploading file: %w", err))
 	}
 
 	fmt.Printf("uploaded file %s\n", packageFlags.file)
@@ -402,14 +323,7 @@ func packageCreateBulk(args []string, service *update.Service, out *tabwriter.Wr
 
 func createPackageFromInfoFile(filename string, service *update.Service, handleError func(error)) {
 	// Load metadata from package info.json into struct
-	pkg := new(update.Package)
-	jsonBody, err := ioutil.ReadFile(filename)
-	if err != nil {
-		handleError(err)
-		return
-	}
-
-	err = json.Unmarshal(jsonBody, &pkg)
+	pkg, err := packages.ReadInfo(filename)
 	if err != nil {
 		handleError(err)
 		return
@@ -420,18 +334,12 @@ func createPackageFromInfoFile(filename string, service *update.Service, handleE
 	// If --base-url specified, rewrite hosting URL
 	baseUrl := packageFlags.baseUrl
 	if baseUrl != "" {
-		filename := fmt.Sprintf(
-			"%s_%s_%s",
-			pkg.AppId, pkg.Version,
-			path.Base(pkg.Url),
-		)
-
 		u, err := url.Parse(baseUrl)
 		if err != nil {
 			handleError(err)
 			return
 		}
-		u.Path = path.Join(u.Path, filename)
+		u.Path = path.Join(u.Path, packages.Filename(pkg))
 		pkg.Url = u.String()
 	}
 
@@ -456,15 +364,18 @@ func packageList(args []string, service *update.Service, out *tabwriter.Writer)
 	list, err := call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, packageHeader)
-	for _, pkg := range list.Items {
-		fmt.Fprintf(out, "%s", formatPackage(pkg))
+	err = printResult(out, list, func(out *tabwriter.Writer) {
+		fmt.Fprint(out, packageHeader)
+		for _, pkg := range list.Items {
+			fmt.Fprintf(out, "%s", formatPackage(pkg))
+		}
+	})
+	if err != nil {
+		return handleError(err)
 	}
-
-	out.Flush()
 	return OK
 }
 
@@ -478,13 +389,12 @@ func packageDelete(args []string, service *update.Service, out *tabwriter.Writer
 	pkg, err := call.Do()
 
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprint(out, packageHeader)
-	fmt.Fprintf(out, "%s", formatPackage(pkg))
-
-	out.Flush()
+	if err := printPackage(out, pkg); err != nil {
+		return handleError(err)
+	}
 	return OK
 
 }
@@ -572,13 +482,6 @@ func packageDownload(args []string, service *update.Service, out *tabwriter.Writ
 					pkg.AppId, pkg.Version, pkg.Url, err,
 				)
 
-				if err = os.Remove(path.Join(saveDir, path.Base(pkg.Url))); err != nil {
-					fmt.Fprintf(os.Stderr,
-						"Tried to remove file and got error: %s",
-						err,
-					)
-				}
-
 				downloadGroup.Done()
 			}
 		}
@@ -602,73 +505,14 @@ func packageDownload(args []string, service *update.Service, out *tabwriter.Writ
 }
 
 func downloadPackagePayload(pkg *update.Package, saveTo string, bar *pb.ProgressBar, handle func(error)) {
-	// Ensure we have a valid package URL
-	pkgUrl, err := url.Parse(pkg.Url)
+	_, err := packages.Download(context.Background(), http.DefaultClient, pkg, saveTo, bar)
 	if err != nil {
 		handle(err)
 		return
 	}
 
-	// Currently only supports files hosted publicly on HTTP/HTTPS
-	if pkgUrl.Scheme != "http" && pkgUrl.Scheme != "https" {
-		err = fmt.Errorf("Cannot download package with scheme %s", pkgUrl.Scheme)
-		handle(err)
-		return
-	}
-
-	// Download the package
-	res, err := http.Get(pkg.Url)
-	if res != nil {
-		defer res.Body.Close()
-	}
-	if err != nil {
-		handle(err)
-		return
-	}
-
-	// Save the file by Applciation, Version, and Filename
-	filename := fmt.Sprintf("%s_%s_%s", pkg.AppId, pkg.Version, path.Base(pkg.Url))
-	out, err := os.Create(path.Join(saveTo, filename))
-	if out != nil {
-		defer out.Close()
-	}
-
-	if err != nil {
-		handle(err)
-		return
-	}
-
-	// We will hash the file as we download it.
-	sha1h := sha1.New()
-	// Write to file, hash, and progress bar.
-	n, err := io.Copy(io.MultiWriter(out, sha1h, bar), res.Body)
-	if err != nil {
-		handle(err)
-		return
-	}
-
-	// Verify downloaded size matches the package's size.
-	pkgSize, err := strconv.ParseInt(pkg.Size, 10, 64)
-	if n != pkgSize {
-		err = fmt.Errorf("Download size does not match package size. %d != %d", n, pkgSize)
-		handle(err)
-		return
-	}
-
-	// Decode the SHA1 sum in the package manifest.
-	pkgSha1, err := base64.StdEncoding.DecodeString(pkg.Sha1Sum)
-	if err != nil {
-		handle(err)
-		return
-	}
-	// Verify the hash matches
-	if string(pkgSha1) != string(sha1h.Sum(nil)) {
-		err = fmt.Errorf("SHA1 sums do not match: %s != %s", string(pkgSha1), string(sha1h.Sum(nil)))
-		handle(err)
-		return
-	}
 	// Write out an info.json file containing metadata
-	err = writePackageInfo(pkg, saveTo)
+	err = packages.WriteInfo(pkg, saveTo)
 	if err != nil {
 		handle(err)
 		return
@@ -700,22 +544,3 @@ func getPackageSaveDirectory() (string, error) {
 	}
 	return saveDir, nil
 }
-
-func writePackageInfo(pkg *update.Package, saveTo string) error {
-	filename := fmt.Sprintf("%s_%s_info.json", pkg.AppId, pkg.Version)
-	out, err := os.Create(path.Join(saveTo, filename))
-	if out != nil {
-		defer out.Close()
-	}
-	if err != nil {
-		return err
-	}
-
-	output, err := json.Marshal(pkg)
-	if err != nil {
-		return err
-	}
-
-	out.Write(output)
-	return nil
-}
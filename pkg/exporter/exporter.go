@@ -0,0 +1,230 @@
+// Package exporter polls the update service and exposes the state of its
+// apps and groups as Prometheus metrics.
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+// Collector gathers metrics of the groups of apps from the update service.
+type Collector struct {
+	Service *update.Service
+
+	// AppIds are the apps to collect metrics of. If empty, all apps are
+	// collected.
+	AppIds []string
+
+	// Window is how far back instances, versions and events are counted.
+	Window time.Duration
+}
+
+// rollupResolution returns the finest resolution the update service
+// offers which keeps a rollup over window reasonably small.
+func rollupResolution(window time.Duration) int64 {
+	switch {
+	case window <= 6*time.Hour:
+		return 60
+	case window <= 7*24*time.Hour:
+		return 3600
+	}
+	return 86400
+}
+
+// Collect fetches the state of all groups and the instances, versions
+// and events reported during the window.
+func (c *Collector) Collect(ctx context.Context) ([]*Family, error) {
+	paused := NewFamily("updateservice_group_updates_paused", Gauge,
+		"Whether updates of the group are paused.")
+	rolloutActive := NewFamily("updateservice_group_rollout_active", Gauge,
+		"Whether the rollout of the group is active.")
+	percent := NewFamily("updateservice_group_update_percent", Gauge,
+		"Percentage of the instances of the group allowed to update.")
+	instances := NewFamily("updateservice_group_instances", Gauge,
+		"Instances of the group seen during the window.")
+	versions := NewFamily("updateservice_group_version_instances", Gauge,
+		"Instances of the group seen during the window by version.")
+	events := NewFamily("updateservice_group_events", Gauge,
+		"Events reported by the instances of the group during the window by version, Omaha event type and result.")
+
+	appIds := c.AppIds
+	if len(appIds) == 0 {
+		apps, err := c.Service.App.List().Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, app := range apps.Items {
+			appIds = append(appIds, app.Id)
+		}
+	}
+
+	end := time.Now()
+	start := end.Add(-c.Window)
+	resolution := rollupResolution(c.Window)
+
+	for _, appId := range appIds {
+		groups, err := c.Service.Group.List(appId).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+
+		appVersions, err := c.Service.Appversion.List().
+			AppId(appId).
+			DateStart(start.Unix()).
+			Context(ctx).
+			Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range appVersions.Items {
+			versions.Add(float64(v.Count), "app", appId, "group", v.GroupId, "version", v.Version)
+		}
+
+		for _, group := range groups.Items {
+			paused.Add(boolValue(group.UpdatesPaused), "app", appId, "group", group.Id)
+			rolloutActive.Add(boolValue(group.RolloutActive), "app", appId, "group", group.Id)
+			percent.Add(group.UpdatePercent, "app", appId, "group", group.Id)
+
+			count, err := c.Service.Clientupdate.Count().
+				AppId(appId).
+				GroupId(group.Id).
+				DateStart(start.Unix()).
+				Context(ctx).
+				Do()
+			if err != nil {
+				return nil, err
+			}
+			instances.Add(float64(count.Count), "app", appId, "group", group.Id)
+
+			rollup, err := c.Service.Group.Requests.Events.Rollup(appId, group.Id, start.Unix(), end.Unix()).
+				Resolution(resolution).
+				Context(ctx).
+				Do()
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range rollup.Items {
+				var total int64
+				for _, v := range item.Values {
+					total += v.Count
+				}
+				events.Add(float64(total), "app", appId, "group", group.Id,
+					"version", item.Version, "type", item.Type, "result", item.Result)
+			}
+		}
+	}
+
+	return []*Family{paused, rolloutActive, percent, instances, versions, events}, nil
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Exporter polls a Collector and serves the metrics of the last
+// successful poll, along with metrics about the polls themselves.
+type Exporter struct {
+	Collector *Collector
+	Interval  time.Duration
+
+	// Logf, if set, is called with poll failures.
+	Logf func(format string, v ...interface{})
+
+	mu       sync.Mutex
+	families []*Family
+	up       bool
+	polls    int64
+	errors   int64
+	lastPoll time.Time
+	duration time.Duration
+}
+
+// Poll collects the metrics once.
+func (e *Exporter) Poll(ctx context.Context) error {
+	started := time.Now()
+	families, err := e.Collector.Collect(ctx)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.polls++
+	e.up = err == nil
+	e.duration = time.Since(started)
+	if err != nil {
+		e.errors++
+		return err
+	}
+	e.families = families
+	e.lastPoll = started
+	return nil
+}
+
+// Run polls every interval until ctx is done.
+func (e *Exporter) Run(ctx context.Context) {
+	tick := time.NewTicker(e.Interval)
+	defer tick.Stop()
+	for {
+		if err := e.Poll(ctx); err != nil && ctx.Err() == nil {
+			e.logf("poll failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func (e *Exporter) logf(format string, v ...interface{}) {
+	if e.Logf != nil {
+		e.Logf(format, v...)
+	}
+}
+
+// Families returns the metrics of the last successful poll followed by
+// the metrics of the exporter.
+func (e *Exporter) Families() []*Family {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	up := NewFamily("updateservice_up", Gauge,
+		"Whether the last poll of the update service succeeded.")
+	up.Add(boolValue(e.up))
+	polls := NewFamily("updateservice_exporter_polls_total", Counter,
+		"Polls of the update service.")
+	polls.Add(float64(e.polls))
+	errors := NewFamily("updateservice_exporter_poll_errors_total", Counter,
+		"Polls of the update service which failed.")
+	errors.Add(float64(e.errors))
+	duration := NewFamily("updateservice_exporter_poll_duration_seconds", Gauge,
+		"Duration of the last poll.")
+	duration.Add(e.duration.Seconds())
+
+	families := append([]*Family{}, e.families...)
+	families = append(families, up, polls, errors, duration)
+	if !e.lastPoll.IsZero() {
+		last := NewFamily("updateservice_exporter_last_success_timestamp_seconds", Gauge,
+			"Time of the last successful poll.")
+		last.Add(float64(e.lastPoll.Unix()))
+		families = append(families, last)
+	}
+	return families
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := WriteText(&buf, e.Families()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
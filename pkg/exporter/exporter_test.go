@@ -0,0 +1,92 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/mockserver"
+	"github.com/coreos/updateservicectl/pkg/omahaclient"
+)
+
+func TestExporter(t *testing.T) {
+	s := mockserver.New()
+	var down int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) != 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	service := s.Service(ts.URL)
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "1.0.0"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable", UpdatePercent: 50, UpdatesPaused: true}).Do()
+
+	ctx := context.Background()
+	for _, id := range []string{"m1", "m2"} {
+		c := &omahaclient.Client{Server: ts.URL, AppID: "app", Version: "1.0.0", Track: "prod", MachineID: id}
+		if _, err := c.UpdateCheck(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if id == "m2" {
+			c.SendEvent(ctx, &omahaclient.Event{Type: "3", Result: "0", ErrorCode: "7"})
+		}
+	}
+
+	e := &Exporter{Collector: &Collector{Service: service, Window: time.Hour}}
+	if err := e.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	metrics := func() string {
+		var buf bytes.Buffer
+		if err := WriteText(&buf, e.Families()); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	got := metrics()
+	for _, line := range []string{
+		`updateservice_group_updates_paused{app="app",group="prod"} 1`,
+		`updateservice_group_rollout_active{app="app",group="prod"} 0`,
+		`updateservice_group_update_percent{app="app",group="prod"} 50`,
+		`updateservice_group_instances{app="app",group="prod"} 2`,
+		`updateservice_group_version_instances{app="app",group="prod",version="1.0.0"} 2`,
+		`updateservice_group_events{app="app",group="prod",version="1.0.0",type="3",result="0"} 1`,
+		`updateservice_up 1`,
+		`updateservice_exporter_polls_total 1`,
+		`updateservice_exporter_poll_errors_total 0`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("expected %s in\n%s", line, got)
+		}
+	}
+
+	// a failed poll keeps the metrics of the last good one
+	atomic.StoreInt32(&down, 1)
+	if err := e.Poll(ctx); err == nil {
+		t.Fatal("expected the poll to fail")
+	}
+	got = metrics()
+	for _, line := range []string{
+		`updateservice_group_instances{app="app",group="prod"} 2`,
+		`updateservice_up 0`,
+		`updateservice_exporter_polls_total 2`,
+		`updateservice_exporter_poll_errors_total 1`,
+		`updateservice_exporter_last_success_timestamp_seconds `,
+	} {
+		if !strings.Contains(got, line) {
+			t.Errorf("expected %s after a failed poll in\n%s", line, got)
+		}
+	}
+}
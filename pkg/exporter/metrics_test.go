@@ -0,0 +1,50 @@
+package exporter
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestWriteText(t *testing.T) {
+	events := NewFamily("updateservice_group_events", Gauge, "Events\nby type.")
+	events.Add(3, "app", "e96281a6", "group", `say "hi"\`, "type", "3")
+	events.Add(0.5)
+	polls := NewFamily("updateservice_exporter_polls_total", Counter, "Polls.")
+	polls.Add(math.Inf(1))
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf, []*Family{events, polls}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP updateservice_group_events Events\nby type.
+# TYPE updateservice_group_events gauge
+updateservice_group_events{app="e96281a6",group="say \"hi\"\\",type="3"} 3
+updateservice_group_events 0.5
+# HELP updateservice_exporter_polls_total Polls.
+# TYPE updateservice_exporter_polls_total counter
+updateservice_exporter_polls_total +Inf
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestRollupResolution(t *testing.T) {
+	tests := []struct {
+		window     time.Duration
+		resolution int64
+	}{
+		{time.Hour, 60},
+		{6 * time.Hour, 60},
+		{24 * time.Hour, 3600},
+		{30 * 24 * time.Hour, 86400},
+	}
+	for _, tt := range tests {
+		if got := rollupResolution(tt.window); got != tt.resolution {
+			t.Errorf("%v: expected %d, got %d", tt.window, tt.resolution, got)
+		}
+	}
+}
@@ -0,0 +1,986 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+type params map[string]string
+
+// route is an endpoint of the JSON API. Segments of pattern in braces
+// match any segment and are passed to handle by name.
+type route struct {
+	method  string
+	pattern string
+	handle  func(r *http.Request, body []byte, p params) (interface{}, error)
+}
+
+func (s *Server) routes() []route {
+	return []route{
+		{"POST", "admin/user", s.createUser},
+		{"GET", "admin/user", s.listUsers},
+		{"GET", "admin/user/{userName}", s.getUser},
+		{"DELETE", "admin/user/{userName}", s.deleteUser},
+		{"PUT", "admin/user/{userName}/token/new", s.genToken},
+
+		{"GET", "apps", s.listApps},
+		{"POST", "apps", s.insertApp},
+		{"GET", "apps/{id}", s.getApp},
+		{"PATCH", "apps/{id}", s.patchApp},
+		{"DELETE", "apps/{id}", s.deleteApp},
+
+		{"GET", "apps/{appId}/packages", s.listPackages},
+		{"POST", "apps/{appId}/packages/{version}", s.insertPackage},
+		{"DELETE", "apps/{appId}/packages/{version}", s.deletePackage},
+		{"GET", "public/packages", s.publicPackages},
+
+		{"GET", "apps/{appId}/channels", s.listChannels},
+		{"POST", "apps/{appId}/channels", s.insertChannel},
+		{"PATCH", "apps/{appId}/channels/{label}", s.patchChannel},
+		{"DELETE", "apps/{appId}/channels/{label}", s.deleteChannel},
+		{"GET", "public/channels", s.publicChannels},
+
+		{"GET", "apps/{appId}/groups", s.listGroups},
+		{"POST", "apps/{appId}/groups", s.insertGroup},
+		{"GET", "apps/{appId}/groups/{id}", s.getGroup},
+		{"PATCH", "apps/{appId}/groups/{id}", s.patchGroup},
+		{"POST", "apps/{appId}/groups/{id}", s.setPercent},
+		{"DELETE", "apps/{appId}/groups/{id}", s.deleteGroup},
+		{"GET", "apps/{appId}/groups/{groupId}/requests/events/{dateStart}/{dateEnd}", s.eventsRollup},
+		{"GET", "apps/{appId}/groups/{groupId}/requests/versions/{dateStart}/{dateEnd}", s.versionsRollup},
+		{"GET", "apps/{appId}/groups/{groupId}/rollout", s.getRollout},
+		{"POST", "apps/{appId}/groups/{groupId}/rollout", s.setRollout},
+		{"GET", "apps/{appId}/groups/{groupId}/rollout/active", s.getRolloutActive},
+		{"POST", "apps/{appId}/groups/{groupId}/rollout/active", s.setRolloutActive},
+
+		{"GET", "appversions", s.listAppVersions},
+		{"GET", "clientupdates", s.listClientUpdates},
+		{"GET", "clientupdatecount", s.countClientUpdates},
+		{"GET", "client/history", s.clientHistory},
+
+		{"GET", "upstream", s.listUpstreams},
+		{"POST", "upstream", s.insertUpstream},
+		{"PUT", "upstream/{id}", s.updateUpstream},
+		{"DELETE", "upstream/{id}", s.deleteUpstream},
+		{"POST", "upstream/sync", s.syncUpstreams},
+
+		{"GET", "util/uuid", s.generateUuid},
+	}
+}
+
+// match returns the parameters of path if it matches pattern.
+func match(pattern string, path []string) (params, bool) {
+	segments := strings.Split(pattern, "/")
+	if len(segments) != len(path) {
+		return nil, false
+	}
+	p := make(params)
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			p[segment[1:len(segment)-1]] = path[i]
+		} else if segment != path[i] {
+			return nil, false
+		}
+	}
+	return p, true
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, APIPath), "/"), "/")
+
+	var matched bool
+	for _, rt := range s.routes() {
+		p, ok := match(rt.pattern, path)
+		if !ok {
+			continue
+		}
+		matched = true
+		if rt.method != r.Method {
+			continue
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, badRequest("%v", err))
+			return
+		}
+
+		v, err := s.call(rt, r, body, p)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, v)
+		return
+	}
+
+	if matched {
+		writeError(w, errorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method))
+		return
+	}
+	writeError(w, notFound("no such endpoint %s", r.URL.Path))
+}
+
+// call runs the handler of rt with the server locked.
+func (s *Server) call(rt route, r *http.Request, body []byte, p params) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+	return rt.handle(r, body, p)
+}
+
+// decode reads a JSON body into v, leaving the fields the body does not
+// mention as they are.
+func decode(body []byte, v interface{}) error {
+	if len(body) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return badRequest("invalid body: %v", err)
+	}
+	return nil
+}
+
+// has tells whether a JSON body sets field.
+func has(body []byte, field string) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return false
+	}
+	_, ok := fields[field]
+	return ok
+}
+
+func queryInt(q url.Values, name string) (int64, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, badRequest("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
+// page applies the limit and skip parameters to n items and returns the
+// range of items to return.
+func page(q url.Values, n int) (int, int, error) {
+	limit, err := queryInt(q, "limit")
+	if err != nil {
+		return 0, 0, err
+	}
+	skip, err := queryInt(q, "skip")
+	if err != nil {
+		return 0, 0, err
+	}
+	if limit < 0 || skip < 0 {
+		return 0, 0, badRequest("limit and skip must not be negative")
+	}
+	start := int(skip)
+	if start > n {
+		start = n
+	}
+	end := n
+	if limit > 0 && start+int(limit) < end {
+		end = start + int(limit)
+	}
+	return start, end, nil
+}
+
+// advance moves the update percent of groups with an active rollout to the
+// frame in effect.
+func (s *Server) advance() {
+	now := s.Now()
+	for _, g := range s.groups {
+		if !g.RolloutActive || len(g.rollout) == 0 {
+			continue
+		}
+		elapsed := now.Sub(g.activated)
+		var at time.Duration
+		for _, frame := range g.rollout {
+			if elapsed < at {
+				break
+			}
+			g.UpdatePercent = frame.Percent
+			at += time.Duration(frame.Duration) * time.Second
+		}
+	}
+}
+
+// Admin users
+
+func (s *Server) createUser(r *http.Request, body []byte, p params) (interface{}, error) {
+	req := new(update.AdminUserReq)
+	if err := decode(body, req); err != nil {
+		return nil, err
+	}
+	if req.UserName == "" {
+		return nil, badRequest("userName is required")
+	}
+	if _, ok := s.users[req.UserName]; ok {
+		return nil, errorf(http.StatusConflict, "user %s exists", req.UserName)
+	}
+	user := &update.AdminUser{User: req.UserName, Token: uuid.New()}
+	s.users[req.UserName] = user
+	return user, nil
+}
+
+func (s *Server) listUsers(r *http.Request, body []byte, p params) (interface{}, error) {
+	resp := &update.AdminListUsersResp{}
+	for _, user := range s.users {
+		resp.Users = append(resp.Users, user)
+	}
+	sort.Slice(resp.Users, func(i, j int) bool { return resp.Users[i].User < resp.Users[j].User })
+	return resp, nil
+}
+
+func (s *Server) user(name string) (*update.AdminUser, error) {
+	user, ok := s.users[name]
+	if !ok {
+		return nil, notFound("user %s not found", name)
+	}
+	return user, nil
+}
+
+func (s *Server) getUser(r *http.Request, body []byte, p params) (interface{}, error) {
+	return s.user(p["userName"])
+}
+
+func (s *Server) deleteUser(r *http.Request, body []byte, p params) (interface{}, error) {
+	user, err := s.user(p["userName"])
+	if err != nil {
+		return nil, err
+	}
+	delete(s.users, user.User)
+	return user, nil
+}
+
+func (s *Server) genToken(r *http.Request, body []byte, p params) (interface{}, error) {
+	user, err := s.user(p["userName"])
+	if err != nil {
+		return nil, err
+	}
+	user.Token = uuid.New()
+	return user, nil
+}
+
+// Apps
+
+func (s *Server) listApps(r *http.Request, body []byte, p params) (interface{}, error) {
+	resp := &update.AppListResp{}
+	for _, app := range s.apps {
+		resp.Items = append(resp.Items, app)
+	}
+	sort.Slice(resp.Items, func(i, j int) bool { return resp.Items[i].Id < resp.Items[j].Id })
+	return resp, nil
+}
+
+func (s *Server) insertApp(r *http.Request, body []byte, p params) (interface{}, error) {
+	app := new(update.App)
+	if err := decode(body, app); err != nil {
+		return nil, err
+	}
+	if app.Id == "" {
+		app.Id = uuid.New()
+	}
+	if _, ok := s.apps[app.Id]; ok {
+		return nil, errorf(http.StatusConflict, "app %s exists", app.Id)
+	}
+	s.apps[app.Id] = app
+	return app, nil
+}
+
+func (s *Server) app(id string) (*update.App, error) {
+	app, ok := s.apps[id]
+	if !ok {
+		return nil, notFound("app %s not found", id)
+	}
+	return app, nil
+}
+
+func (s *Server) getApp(r *http.Request, body []byte, p params) (interface{}, error) {
+	return s.app(p["id"])
+}
+
+func (s *Server) patchApp(r *http.Request, body []byte, p params) (interface{}, error) {
+	app, err := s.app(p["id"])
+	if err != nil {
+		return nil, err
+	}
+	patched := *app
+	if err := decode(body, &patched); err != nil {
+		return nil, err
+	}
+	patched.Id = app.Id
+	*app = patched
+	return app, nil
+}
+
+func (s *Server) deleteApp(r *http.Request, body []byte, p params) (interface{}, error) {
+	app, err := s.app(p["id"])
+	if err != nil {
+		return nil, err
+	}
+	delete(s.apps, app.Id)
+	for k := range s.channels {
+		if k[0] == app.Id {
+			delete(s.channels, k)
+		}
+	}
+	for k := range s.groups {
+		if k[0] == app.Id {
+			delete(s.groups, k)
+		}
+	}
+	for k := range s.packages {
+		if k[0] == app.Id {
+			delete(s.packages, k)
+		}
+	}
+	return app, nil
+}
+
+// Packages
+
+func (s *Server) appPackages(appId string) []*update.Package {
+	var pkgs []*update.Package
+	for k, pkg := range s.packages {
+		if k[0] == appId {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Version < pkgs[j].Version })
+	return pkgs
+}
+
+func (s *Server) listPackages(r *http.Request, body []byte, p params) (interface{}, error) {
+	if _, err := s.app(p["appId"]); err != nil {
+		return nil, err
+	}
+	var pkgs []*update.Package
+	version := r.URL.Query().Get("version")
+	for _, pkg := range s.appPackages(p["appId"]) {
+		if version == "" || pkg.Version == version {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	start, end, err := page(r.URL.Query(), len(pkgs))
+	if err != nil {
+		return nil, err
+	}
+	return &update.PackageList{Items: pkgs[start:end], Total: int64(len(pkgs))}, nil
+}
+
+func (s *Server) insertPackage(r *http.Request, body []byte, p params) (interface{}, error) {
+	if _, err := s.app(p["appId"]); err != nil {
+		return nil, err
+	}
+	k := key{p["appId"], p["version"]}
+	if _, ok := s.packages[k]; ok {
+		return nil, errorf(http.StatusConflict, "package %s exists", p["version"])
+	}
+	pkg := new(update.Package)
+	if err := decode(body, pkg); err != nil {
+		return nil, err
+	}
+	pkg.AppId = p["appId"]
+	pkg.Version = p["version"]
+	pkg.DateCreated = timestamp(s.Now())
+	s.packages[k] = pkg
+	return pkg, nil
+}
+
+func (s *Server) deletePackage(r *http.Request, body []byte, p params) (interface{}, error) {
+	k := key{p["appId"], p["version"]}
+	pkg, ok := s.packages[k]
+	if !ok {
+		return nil, notFound("package %s not found", p["version"])
+	}
+	delete(s.packages, k)
+	return pkg, nil
+}
+
+func (s *Server) publicPackages(r *http.Request, body []byte, p params) (interface{}, error) {
+	resp := &update.PublicPackageList{}
+	apps, _ := s.listApps(r, nil, nil)
+	for _, app := range apps.(*update.AppListResp).Items {
+		if pkgs := s.appPackages(app.Id); len(pkgs) > 0 {
+			resp.Items = append(resp.Items, &update.PublicPackageItem{AppId: app.Id, Packages: pkgs})
+		}
+	}
+	return resp, nil
+}
+
+// Channels
+
+func (s *Server) appChannels(appId string, published bool) []*update.AppChannel {
+	var channels []*update.AppChannel
+	for k, channel := range s.channels {
+		if (appId == "" || k[0] == appId) && (!published || channel.Publish) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		if channels[i].AppId != channels[j].AppId {
+			return channels[i].AppId < channels[j].AppId
+		}
+		return channels[i].Label < channels[j].Label
+	})
+	return channels
+}
+
+func (s *Server) listChannels(r *http.Request, body []byte, p params) (interface{}, error) {
+	if _, err := s.app(p["appId"]); err != nil {
+		return nil, err
+	}
+	return &update.ChannelListResp{Items: s.appChannels(p["appId"], false)}, nil
+}
+
+func (s *Server) publicChannels(r *http.Request, body []byte, p params) (interface{}, error) {
+	return &update.ChannelListResp{Items: s.appChannels("", true)}, nil
+}
+
+func (s *Server) insertChannel(r *http.Request, body []byte, p params) (interface{}, error) {
+	req := new(update.ChannelRequest)
+	if err := decode(body, req); err != nil {
+		return nil, err
+	}
+	// like the update service, prefer the app of the body: channel create
+	// puts the label in the path
+	appId := req.AppId
+	if appId == "" {
+		appId = p["appId"]
+	}
+	if _, err := s.app(appId); err != nil {
+		return nil, err
+	}
+	if req.Label == "" {
+		return nil, badRequest("label is required")
+	}
+	k := key{appId, req.Label}
+	if _, ok := s.channels[k]; ok {
+		return nil, errorf(http.StatusConflict, "channel %s exists", req.Label)
+	}
+	channel := &update.AppChannel{
+		AppId:       appId,
+		Label:       req.Label,
+		Publish:     req.Publish,
+		Version:     req.Version,
+		DateCreated: timestamp(s.Now()),
+	}
+	s.channels[k] = channel
+	return channel, nil
+}
+
+func (s *Server) channel(appId, label string) (*update.AppChannel, error) {
+	channel, ok := s.channels[key{appId, label}]
+	if !ok {
+		return nil, notFound("channel %s not found", label)
+	}
+	return channel, nil
+}
+
+func (s *Server) patchChannel(r *http.Request, body []byte, p params) (interface{}, error) {
+	channel, err := s.channel(p["appId"], p["label"])
+	if err != nil {
+		return nil, err
+	}
+	req := &update.ChannelRequest{Publish: channel.Publish, Version: channel.Version}
+	if err := decode(body, req); err != nil {
+		return nil, err
+	}
+	channel.Publish = req.Publish
+	channel.Version = req.Version
+	return channel, nil
+}
+
+func (s *Server) deleteChannel(r *http.Request, body []byte, p params) (interface{}, error) {
+	channel, err := s.channel(p["appId"], p["label"])
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range s.groups {
+		if g.AppId == channel.AppId && g.ChannelId == channel.Label {
+			return nil, errorf(http.StatusConflict, "channel %s is used by group %s", channel.Label, g.Id)
+		}
+	}
+	delete(s.channels, key{channel.AppId, channel.Label})
+	return &update.ChannelRequest{
+		AppId:   channel.AppId,
+		Label:   channel.Label,
+		Publish: channel.Publish,
+		Version: channel.Version,
+	}, nil
+}
+
+// Groups
+
+func (s *Server) listGroups(r *http.Request, body []byte, p params) (interface{}, error) {
+	if _, err := s.app(p["appId"]); err != nil {
+		return nil, err
+	}
+	resp := &update.GroupList{}
+	for k, g := range s.groups {
+		if k[0] == p["appId"] {
+			resp.Items = append(resp.Items, g.Group)
+		}
+	}
+	sort.Slice(resp.Items, func(i, j int) bool { return resp.Items[i].Id < resp.Items[j].Id })
+	start, end, err := page(r.URL.Query(), len(resp.Items))
+	if err != nil {
+		return nil, err
+	}
+	resp.Items = resp.Items[start:end]
+	return resp, nil
+}
+
+func (s *Server) insertGroup(r *http.Request, body []byte, p params) (interface{}, error) {
+	if _, err := s.app(p["appId"]); err != nil {
+		return nil, err
+	}
+	g := new(update.Group)
+	if err := decode(body, g); err != nil {
+		return nil, err
+	}
+	g.AppId = p["appId"]
+	if g.Id == "" {
+		g.Id = uuid.New()
+	}
+	if _, ok := s.groups[key{g.AppId, g.Id}]; ok {
+		return nil, errorf(http.StatusConflict, "group %s exists", g.Id)
+	}
+	if _, err := s.channel(g.AppId, g.ChannelId); err != nil {
+		return nil, badRequest("channel %q not found", g.ChannelId)
+	}
+	if !has(body, "updatePercent") {
+		g.UpdatePercent = 100
+	}
+	g.RolloutActive = false
+	s.groups[key{g.AppId, g.Id}] = &group{Group: g}
+	return g, nil
+}
+
+func (s *Server) group(appId, id string) (*group, error) {
+	g, ok := s.groups[key{appId, id}]
+	if !ok {
+		return nil, notFound("group %s not found", id)
+	}
+	return g, nil
+}
+
+func (s *Server) getGroup(r *http.Request, body []byte, p params) (interface{}, error) {
+	g, err := s.group(p["appId"], p["id"])
+	if err != nil {
+		return nil, err
+	}
+	return g.Group, nil
+}
+
+func (s *Server) patchGroup(r *http.Request, body []byte, p params) (interface{}, error) {
+	g, err := s.group(p["appId"], p["id"])
+	if err != nil {
+		return nil, err
+	}
+	patched := *g.Group
+	if err := decode(body, &patched); err != nil {
+		return nil, err
+	}
+	patched.AppId, patched.Id = g.AppId, g.Id
+	// the rollout is activated through its own endpoint
+	patched.RolloutActive = g.RolloutActive
+	if _, err := s.channel(patched.AppId, patched.ChannelId); err != nil {
+		return nil, badRequest("channel %q not found", patched.ChannelId)
+	}
+	*g.Group = patched
+	return g.Group, nil
+}
+
+func (s *Server) setPercent(r *http.Request, body []byte, p params) (interface{}, error) {
+	g, err := s.group(p["appId"], p["id"])
+	if err != nil {
+		return nil, err
+	}
+	req := new(update.GroupPercent)
+	if err := decode(body, req); err != nil {
+		return nil, err
+	}
+	if req.UpdatePercent < 0 || req.UpdatePercent > 100 {
+		return nil, badRequest("updatePercent must be between 0 and 100")
+	}
+	g.UpdatePercent = req.UpdatePercent
+	return &update.GroupPercent{AppId: g.AppId, Id: g.Id, UpdatePercent: g.UpdatePercent}, nil
+}
+
+func (s *Server) deleteGroup(r *http.Request, body []byte, p params) (interface{}, error) {
+	g, err := s.group(p["appId"], p["id"])
+	if err != nil {
+		return nil, err
+	}
+	delete(s.groups, key{g.AppId, g.Id})
+	return g.Group, nil
+}
+
+func (s *Server) getRollout(r *http.Request, body []byte, p params) (interface{}, error) {
+	g, err := s.group(p["appId"], p["groupId"])
+	if err != nil {
+		return nil, err
+	}
+	return &update.Rollout{AppId: g.AppId, GroupId: g.Id, Rollout: g.rollout}, nil
+}
+
+func (s *Server) setRollout(r *http.Request, body []byte, p params) (interface{}, error) {
+	g, err := s.group(p["appId"], p["groupId"])
+	if err != nil {
+		return nil, err
+	}
+	req := new(update.Rollout)
+	if err := decode(body, req); err != nil {
+		return nil, err
+	}
+	for _, frame := range req.Rollout {
+		if frame.Percent < 0 || frame.Percent > 100 || frame.Duration < 0 {
+			return nil, badRequest("invalid rollout frame")
+		}
+	}
+	g.rollout = req.Rollout
+	return &update.Rollout{AppId: g.AppId, GroupId: g.Id, Rollout: g.rollout}, nil
+}
+
+func (s *Server) getRolloutActive(r *http.Request, body []byte, p params) (interface{}, error) {
+	g, err := s.group(p["appId"], p["groupId"])
+	if err != nil {
+		return nil, err
+	}
+	return &update.RolloutActive{AppId: g.AppId, GroupId: g.Id, Active: g.RolloutActive}, nil
+}
+
+func (s *Server) setRolloutActive(r *http.Request, body []byte, p params) (interface{}, error) {
+	g, err := s.group(p["appId"], p["groupId"])
+	if err != nil {
+		return nil, err
+	}
+	req := new(update.RolloutActive)
+	if err := decode(body, req); err != nil {
+		return nil, err
+	}
+	if req.Active && len(g.rollout) == 0 {
+		return nil, badRequest("group %s has no rollout", g.Id)
+	}
+	if req.Active && !g.RolloutActive {
+		g.activated = s.Now()
+	}
+	g.RolloutActive = req.Active
+	s.advance()
+	return &update.RolloutActive{AppId: g.AppId, GroupId: g.Id, Active: g.RolloutActive}, nil
+}
+
+// Rollups
+
+// rollupRange reads the range and resolution of a rollup.
+func rollupRange(r *http.Request, p params) (start, end, resolution int64, err error) {
+	if start, err = strconv.ParseInt(p["dateStart"], 10, 64); err != nil {
+		return 0, 0, 0, badRequest("invalid dateStart %q", p["dateStart"])
+	}
+	if end, err = strconv.ParseInt(p["dateEnd"], 10, 64); err != nil {
+		return 0, 0, 0, badRequest("invalid dateEnd %q", p["dateEnd"])
+	}
+	if resolution, err = queryInt(r.URL.Query(), "resolution"); err != nil {
+		return 0, 0, 0, err
+	}
+	switch resolution {
+	case 0:
+		resolution = 60
+	case 60, 3600, 86400:
+	default:
+		return 0, 0, 0, badRequest("resolution must be 60, 3600 or 86400")
+	}
+	return start, end, resolution, nil
+}
+
+// rollupVersions returns the versions a rollup is limited to, or nil.
+func rollupVersions(r *http.Request) map[string]bool {
+	list := r.URL.Query().Get("versions")
+	if list == "" {
+		return nil
+	}
+	versions := make(map[string]bool)
+	for _, v := range strings.Split(list, ",") {
+		versions[strings.TrimSpace(v)] = true
+	}
+	return versions
+}
+
+// rollupBuilder sums counts into items by key and time bucket.
+type rollupBuilder struct {
+	resolution int64
+	items      map[string]*update.GroupRequestsItem
+	keys       []string
+	buckets    map[string]map[int64]int64
+}
+
+func newRollupBuilder(resolution int64) *rollupBuilder {
+	return &rollupBuilder{
+		resolution: resolution,
+		items:      make(map[string]*update.GroupRequestsItem),
+		buckets:    make(map[string]map[int64]int64),
+	}
+}
+
+func (b *rollupBuilder) add(item *update.GroupRequestsItem, t time.Time, count int64) {
+	k := item.Version + "/" + item.Type + "/" + item.Result
+	if _, ok := b.items[k]; !ok {
+		b.items[k] = item
+		b.keys = append(b.keys, k)
+		b.buckets[k] = make(map[int64]int64)
+	}
+	bucket := t.Unix() / b.resolution * b.resolution
+	b.buckets[k][bucket] += count
+}
+
+func (b *rollupBuilder) rollup() *update.GroupRequestsRollup {
+	sort.Strings(b.keys)
+	resp := &update.GroupRequestsRollup{}
+	for _, k := range b.keys {
+		item := b.items[k]
+		var timestamps []int64
+		for ts := range b.buckets[k] {
+			timestamps = append(timestamps, ts)
+		}
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+		for _, ts := range timestamps {
+			item.Values = append(item.Values, &update.GroupRequestsValues{Timestamp: ts, Count: b.buckets[k][ts]})
+		}
+		resp.Items = append(resp.Items, item)
+	}
+	return resp
+}
+
+func inRange(t time.Time, start, end int64) bool {
+	return t.Unix() >= start && (end == 0 || t.Unix() <= end)
+}
+
+func (s *Server) eventsRollup(r *http.Request, body []byte, p params) (interface{}, error) {
+	if _, err := s.group(p["appId"], p["groupId"]); err != nil {
+		return nil, err
+	}
+	start, end, resolution, err := rollupRange(r, p)
+	if err != nil {
+		return nil, err
+	}
+	versions := rollupVersions(r)
+
+	b := newRollupBuilder(resolution)
+	for _, e := range s.events {
+		if e.appId != p["appId"] || e.groupId != p["groupId"] || !inRange(e.time, start, end) ||
+			(versions != nil && !versions[e.version]) {
+			continue
+		}
+		b.add(&update.GroupRequestsItem{Version: e.version, Type: e.typ, Result: e.result}, e.time, 1)
+	}
+	return b.rollup(), nil
+}
+
+func (s *Server) versionsRollup(r *http.Request, body []byte, p params) (interface{}, error) {
+	if _, err := s.group(p["appId"], p["groupId"]); err != nil {
+		return nil, err
+	}
+	start, end, resolution, err := rollupRange(r, p)
+	if err != nil {
+		return nil, err
+	}
+	versions := rollupVersions(r)
+
+	// count every machine once per bucket
+	seen := make(map[string]bool)
+	b := newRollupBuilder(resolution)
+	for _, c := range s.checks {
+		if c.appId != p["appId"] || c.groupId != p["groupId"] || !inRange(c.time, start, end) ||
+			(versions != nil && !versions[c.version]) {
+			continue
+		}
+		k := strconv.FormatInt(c.time.Unix()/resolution, 10) + "/" + c.version + "/" + c.clientId
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		b.add(&update.GroupRequestsItem{Version: c.version}, c.time, 1)
+	}
+	return b.rollup(), nil
+}
+
+// Instances
+
+// clientUpdates returns the latest state of the machines matching the
+// filters of a query, most recently seen first.
+func (s *Server) clientUpdates(q url.Values) ([]*update.ClientUpdate, error) {
+	start, err := queryInt(q, "dateStart")
+	if err != nil {
+		return nil, err
+	}
+	end, err := queryInt(q, "dateEnd")
+	if err != nil {
+		return nil, err
+	}
+	filters := map[string]func(*update.ClientUpdate) string{
+		"appId":       func(c *update.ClientUpdate) string { return c.AppId },
+		"groupId":     func(c *update.ClientUpdate) string { return c.GroupId },
+		"clientId":    func(c *update.ClientUpdate) string { return c.ClientId },
+		"eventType":   func(c *update.ClientUpdate) string { return c.EventType },
+		"eventResult": func(c *update.ClientUpdate) string { return c.EventResult },
+		"oem":         func(c *update.ClientUpdate) string { return c.Oem },
+		"version":     func(c *update.ClientUpdate) string { return c.Version },
+	}
+
+	var clients []*update.ClientUpdate
+next:
+	for _, c := range s.clients {
+		for name, field := range filters {
+			if v := q.Get(name); v != "" && field(c) != v {
+				continue next
+			}
+		}
+		lastSeen, _ := time.Parse(time.RFC3339Nano, c.LastSeen)
+		if !inRange(lastSeen, start, end) {
+			continue
+		}
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].LastSeen != clients[j].LastSeen {
+			return clients[i].LastSeen > clients[j].LastSeen
+		}
+		return clients[i].ClientId < clients[j].ClientId
+	})
+	return clients, nil
+}
+
+func (s *Server) listClientUpdates(r *http.Request, body []byte, p params) (interface{}, error) {
+	clients, err := s.clientUpdates(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	start, end, err := page(r.URL.Query(), len(clients))
+	if err != nil {
+		return nil, err
+	}
+	return &update.ClientUpdateList{Items: clients[start:end]}, nil
+}
+
+func (s *Server) countClientUpdates(r *http.Request, body []byte, p params) (interface{}, error) {
+	clients, err := s.clientUpdates(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	return &update.ClientCountResp{Count: int64(len(clients))}, nil
+}
+
+func (s *Server) listAppVersions(r *http.Request, body []byte, p params) (interface{}, error) {
+	clients, err := s.clientUpdates(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[[3]string]int64)
+	for _, c := range clients {
+		counts[[3]string{c.AppId, c.GroupId, c.Version}]++
+	}
+	resp := &update.AppVersionList{}
+	for k, count := range counts {
+		resp.Items = append(resp.Items, &update.AppVersionItem{AppId: k[0], GroupId: k[1], Version: k[2], Count: count})
+	}
+	sort.Slice(resp.Items, func(i, j int) bool {
+		a, b := resp.Items[i], resp.Items[j]
+		if a.AppId != b.AppId {
+			return a.AppId < b.AppId
+		}
+		if a.GroupId != b.GroupId {
+			return a.GroupId < b.GroupId
+		}
+		return a.Version < b.Version
+	})
+	return resp, nil
+}
+
+func (s *Server) clientHistory(r *http.Request, body []byte, p params) (interface{}, error) {
+	clientId := r.URL.Query().Get("clientId")
+	if clientId == "" {
+		return nil, badRequest("clientId is required")
+	}
+	return &update.ClientHistoryResp{Items: s.history[clientId]}, nil
+}
+
+// Upstreams
+
+func (s *Server) listUpstreams(r *http.Request, body []byte, p params) (interface{}, error) {
+	resp := &update.UpstreamListResp{}
+	for _, u := range s.upstreams {
+		resp.Items = append(resp.Items, u)
+	}
+	sort.Slice(resp.Items, func(i, j int) bool { return resp.Items[i].Id < resp.Items[j].Id })
+	return resp, nil
+}
+
+func (s *Server) insertUpstream(r *http.Request, body []byte, p params) (interface{}, error) {
+	u := new(update.Upstream)
+	if err := decode(body, u); err != nil {
+		return nil, err
+	}
+	if u.Url == "" {
+		return nil, badRequest("url is required")
+	}
+	u.Id = uuid.New()
+	s.upstreams[u.Id] = u
+	return u, nil
+}
+
+func (s *Server) upstream(id string) (*update.Upstream, error) {
+	u, ok := s.upstreams[id]
+	if !ok {
+		return nil, notFound("upstream %s not found", id)
+	}
+	return u, nil
+}
+
+func (s *Server) updateUpstream(r *http.Request, body []byte, p params) (interface{}, error) {
+	u, err := s.upstream(p["id"])
+	if err != nil {
+		return nil, err
+	}
+	updated := *u
+	if err := decode(body, &updated); err != nil {
+		return nil, err
+	}
+	updated.Id = u.Id
+	*u = updated
+	return u, nil
+}
+
+func (s *Server) deleteUpstream(r *http.Request, body []byte, p params) (interface{}, error) {
+	u, err := s.upstream(p["id"])
+	if err != nil {
+		return nil, err
+	}
+	delete(s.upstreams, u.Id)
+	return u, nil
+}
+
+func (s *Server) syncUpstreams(r *http.Request, body []byte, p params) (interface{}, error) {
+	return &update.UpstreamSyncResp{
+		Status: "ok",
+		Detail: "the mock server does not sync upstreams",
+	}, nil
+}
+
+func (s *Server) generateUuid(r *http.Request, body []byte, p params) (interface{}, error) {
+	return &update.GenerateUuidResp{Uuid: uuid.New()}, nil
+}
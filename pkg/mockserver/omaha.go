@@ -0,0 +1,218 @@
+package mockserver
+
+import (
+	"encoding/xml"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-omaha/omaha"
+	"github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+// maxUploadSize is the largest package accepted on /package-upload.
+const maxUploadSize = 1 << 30
+
+// The server keeps only the latest update checks and events for the
+// rollups and the latest history of each machine.
+const (
+	maxChecks  = 100000
+	maxEvents  = 100000
+	maxHistory = 1000
+)
+
+func (s *Server) serveOmaha(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req := new(omaha.Request)
+	if err := xml.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := s.answer(req)
+	w.Header().Set("Content-Type", "text/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(resp)
+}
+
+// answer builds the response to an Omaha request with the server locked.
+func (s *Server) answer(req *omaha.Request) *omaha.Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+	resp := omaha.NewResponse("mockserver")
+	now := s.Now()
+	resp.DayStart.ElapsedSeconds = strconv.Itoa(now.Hour()*3600 + now.Minute()*60 + now.Second())
+	for _, app := range req.Apps {
+		s.omahaApp(req, app, resp.AddApp(app.Id), now)
+	}
+	return resp
+}
+
+// omahaApp answers the part of a request for one application.
+func (s *Server) omahaApp(req *omaha.Request, app, resp *omaha.App, now time.Time) {
+	appId := strings.Trim(app.Id, "{}")
+	if _, ok := s.apps[appId]; !ok {
+		resp.Status = "error-unknownApplication"
+		return
+	}
+	resp.Status = "ok"
+
+	s.checks = append(s.checks, &check{
+		time:     now,
+		appId:    appId,
+		groupId:  app.Track,
+		version:  app.Version,
+		clientId: app.MachineID,
+	})
+	if len(s.checks) > maxChecks {
+		s.checks = s.checks[len(s.checks)-maxChecks:]
+	}
+
+	client := s.clients[key{appId, app.MachineID}]
+	if client == nil {
+		client = &update.ClientUpdate{AppId: appId, ClientId: app.MachineID}
+		s.clients[key{appId, app.MachineID}] = client
+	}
+	client.GroupId = app.Track
+	client.Version = app.Version
+	client.Oem = app.OEM
+	client.LastSeen = timestamp(now)
+
+	for _, e := range app.Events {
+		s.events = append(s.events, &event{
+			time:    now,
+			appId:   appId,
+			groupId: app.Track,
+			version: app.Version,
+			typ:     e.Type,
+			result:  e.Result,
+		})
+		if len(s.events) > maxEvents {
+			s.events = s.events[len(s.events)-maxEvents:]
+		}
+		client.EventType = e.Type
+		client.EventResult = e.Result
+		client.ErrorCode = e.ErrorCode
+		s.history[app.MachineID] = append(s.history[app.MachineID], &update.ClientHistoryItem{
+			DateTime:      now.Unix(),
+			EventType:     e.Type,
+			EventResult:   e.Result,
+			ErrorCode:     e.ErrorCode,
+			GroupId:       app.Track,
+			InstallSource: req.InstallSource,
+			Version:       app.Version,
+		})
+		if history := s.history[app.MachineID]; len(history) > maxHistory {
+			s.history[app.MachineID] = history[len(history)-maxHistory:]
+		}
+		resp.AddEvent()
+	}
+
+	if app.Ping != nil {
+		resp.AddPing().Status = "ok"
+	}
+	if app.UpdateCheck != nil {
+		s.updateCheck(req, app, appId, resp.AddUpdateCheck())
+	}
+}
+
+// updateCheck offers the version of the channel of the group of a machine
+// if the machine is behind it and within the update percent of the group.
+func (s *Server) updateCheck(req *omaha.Request, app *omaha.App, appId string, uc *omaha.UpdateCheck) {
+	uc.Status = "noupdate"
+
+	g, ok := s.groups[key{appId, app.Track}]
+	if !ok || g.UpdatesPaused {
+		return
+	}
+	channel, ok := s.channels[key{appId, g.ChannelId}]
+	if !ok || !newer(channel.Version, app.Version) {
+		return
+	}
+	if req.InstallSource != "ondemandupdate" && !selected(app.MachineID, g.UpdatePercent) {
+		return
+	}
+	pkg, ok := s.packages[key{appId, channel.Version}]
+	if !ok {
+		return
+	}
+
+	uc.Status = "ok"
+	dir, name := path.Split(pkg.Url)
+	uc.AddUrl(dir)
+	manifest := uc.AddManifest(pkg.Version)
+	manifest.AddPackage(pkg.Sha1Sum, name, pkg.Size, pkg.Required)
+	action := manifest.AddAction("postinstall")
+	action.Sha256 = pkg.Sha256Sum
+	action.MetadataSignatureRsa = pkg.MetadataSignatureRsa
+	action.MetadataSize = pkg.MetadataSize
+}
+
+// newer tells whether version a is newer than b, comparing them as
+// strings when they are not semantic versions.
+func newer(a, b string) bool {
+	va, errA := semver.NewVersion(a)
+	vb, errB := semver.NewVersion(b)
+	if errA != nil || errB != nil {
+		return a != b && b < a
+	}
+	return vb.LessThan(*va)
+}
+
+// selected tells whether a machine is within the first percent of all
+// machines. The same machine is always in the same place.
+func selected(machineID string, percent float64) bool {
+	h := fnv.New32a()
+	io.WriteString(h, machineID)
+	return float64(h.Sum32()%100) < percent
+}
+
+// serveUpload stores a package uploaded as the "file" field of a form.
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.files[path.Base(header.Filename)] = data
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+}
+
+// serveFile serves the packages uploaded.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/packages/")
+	s.mu.Lock()
+	data, ok := s.files[name]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
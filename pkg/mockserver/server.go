@@ -0,0 +1,171 @@
+// Package mockserver is an in-memory update service for development and
+// tests. It serves the JSON API used by the update client on
+// /_ah/api/update/v1/, the Omaha endpoint used by machines on /v1/update/
+// and package uploads on /package-upload.
+//
+// Credentials are not checked. Rollouts advance with the time since they
+// were activated. Uploaded packages are served on /packages/.
+//
+// In tests, serve it with httptest and talk to it with Service:
+//
+//	s := mockserver.New()
+//	ts := httptest.NewServer(s)
+//	defer ts.Close()
+//	service := s.Service(ts.URL)
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+// APIPath is the path of the JSON API.
+const APIPath = "/_ah/api/update/v1/"
+
+type key [2]string
+
+// group is a group with its rollout.
+type group struct {
+	*update.Group
+	rollout   []*update.Frame
+	activated time.Time
+}
+
+// event is an event reported by a machine.
+type event struct {
+	time    time.Time
+	appId   string
+	groupId string
+	version string
+	typ     string
+	result  string
+}
+
+// check is a request of a machine, counted in version rollups.
+type check struct {
+	time     time.Time
+	appId    string
+	groupId  string
+	version  string
+	clientId string
+}
+
+// Server is an in-memory update service. It is safe for concurrent use.
+type Server struct {
+	// Now returns the current time. It may be replaced to control the
+	// progress of rollouts and the times recorded.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	apps      map[string]*update.App
+	channels  map[key]*update.AppChannel
+	groups    map[key]*group
+	packages  map[key]*update.Package
+	users     map[string]*update.AdminUser
+	upstreams map[string]*update.Upstream
+	clients   map[key]*update.ClientUpdate
+	history   map[string][]*update.ClientHistoryItem
+	events    []*event
+	checks    []*check
+	files     map[string][]byte
+
+	mux *http.ServeMux
+}
+
+// New returns an empty server.
+func New() *Server {
+	s := &Server{
+		Now:       time.Now,
+		apps:      make(map[string]*update.App),
+		channels:  make(map[key]*update.AppChannel),
+		groups:    make(map[key]*group),
+		packages:  make(map[key]*update.Package),
+		users:     make(map[string]*update.AdminUser),
+		upstreams: make(map[string]*update.Upstream),
+		clients:   make(map[key]*update.ClientUpdate),
+		history:   make(map[string][]*update.ClientHistoryItem),
+		files:     make(map[string][]byte),
+	}
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc(APIPath, s.serveAPI)
+	s.mux.HandleFunc("/v1/update", s.serveOmaha)
+	s.mux.HandleFunc("/v1/update/", s.serveOmaha)
+	s.mux.HandleFunc("/package-upload", s.serveUpload)
+	s.mux.HandleFunc("/packages/", s.serveFile)
+	return s
+}
+
+// ServeHTTP serves all endpoints of the update service.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Service returns a client of the server listening on url.
+func (s *Server) Service(url string) *update.Service {
+	service, err := update.New(http.DefaultClient)
+	if err != nil {
+		// only fails without a client
+		panic(err)
+	}
+	service.BasePath = strings.TrimSuffix(url, "/") + APIPath
+	return service
+}
+
+// apiError is an error response of the JSON API.
+type apiError struct {
+	code    int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func errorf(code int, format string, v ...interface{}) error {
+	return &apiError{code, fmt.Sprintf(format, v...)}
+}
+
+func notFound(format string, v ...interface{}) error {
+	return errorf(http.StatusNotFound, format, v...)
+}
+
+func badRequest(format string, v ...interface{}) error {
+	return errorf(http.StatusBadRequest, format, v...)
+}
+
+// writeError writes err in the format of Google APIs, which the update
+// client decodes.
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if e, ok := err.(*apiError); ok {
+		code = e.code
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": err.Error(),
+			"errors": []map[string]string{{
+				"reason":  http.StatusText(code),
+				"message": err.Error(),
+			}},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// timestamp formats times the way the update service does.
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,192 @@
+package mockserver
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/omahaclient"
+)
+
+func TestServer(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	s := New()
+	s.Now = func() time.Time { return now }
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	service := s.Service(ts.URL)
+
+	if _, err := service.App.Insert(&update.AppInsertReq{Id: "app", Label: "App"}).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.App.Insert(&update.AppInsertReq{Id: "app"}).Do(); !isCode(err, 409) {
+		t.Errorf("expected a conflict for a duplicate app, got %v", err)
+	}
+	if _, err := service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "1.0.0"}).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "beta"}).Do(); !isCode(err, 400) {
+		t.Errorf("expected an unknown channel to be refused, got %v", err)
+	}
+	g, err := service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable"}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.UpdatePercent != 100 {
+		t.Errorf("expected new groups to update all machines, got %v%%", g.UpdatePercent)
+	}
+	if _, err := service.App.Get("other").Do(); !isCode(err, 404) {
+		t.Errorf("expected an unknown app not to be found, got %v", err)
+	}
+
+	// machines behind the channel are offered its package
+	pkg := &update.Package{Url: "https://example.com/2.0.0/update.gz", Sha1Sum: "sha1", Sha256Sum: "sha256", Size: "4"}
+	if _, err := service.App.Package.Insert("app", "2.0.0", pkg).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Channel.Update("app", "stable", &update.ChannelRequest{Version: "2.0.0"}).Do(); err != nil {
+		t.Fatal(err)
+	}
+	c := &omahaclient.Client{Server: ts.URL, AppID: "{app}", Version: "1.0.0", Track: "prod", MachineID: "m1"}
+	uc, err := c.UpdateCheck(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uc.Status != "ok" || uc.Manifest.Version != "2.0.0" || uc.Urls.Urls[0].CodeBase != "https://example.com/2.0.0/" {
+		t.Fatalf("expected an update to 2.0.0, got %+v", uc)
+	}
+	if p := uc.Manifest.Packages.Packages[0]; p.Name != "update.gz" || p.Hash != "sha1" || uc.Manifest.Actions.Actions[0].Sha256 != "sha256" {
+		t.Errorf("unexpected package %+v", p)
+	}
+
+	// paused groups offer nothing
+	if _, err := service.Group.Patch("app", "prod", &update.Group{UpdatesPaused: true}).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if uc, err := c.UpdateCheck(context.Background()); err != nil || uc.Status != "noupdate" {
+		t.Errorf("expected no update while paused, got %+v, %v", uc, err)
+	}
+	if g, err := service.Group.Get("app", "prod").Do(); err != nil || g.ChannelId != "stable" {
+		t.Errorf("expected a patch to keep the channel, got %+v, %v", g, err)
+	}
+
+	c.Version = "2.0.0"
+	if err := c.SendEvent(context.Background(), &omahaclient.Event{Type: "3", Result: "0", ErrorCode: "7"}); err != nil {
+		t.Fatal(err)
+	}
+	clients, err := service.Clientupdate.List().AppId("app").EventResult("0").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients.Items) != 1 || clients.Items[0].ClientId != "m1" || clients.Items[0].Version != "2.0.0" {
+		t.Errorf("expected m1 to have failed at 2.0.0, got %+v", clients.Items)
+	}
+	history, err := service.Client.History("m1").Do()
+	if err != nil || len(history.Items) != 1 || history.Items[0].ErrorCode != "7" {
+		t.Errorf("unexpected history %+v, %v", history, err)
+	}
+
+	events, err := service.Group.Requests.Events.Rollup("app", "prod", now.Unix()-60, now.Unix()).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Items) != 1 || events.Items[0].Type != "3" || events.Items[0].Values[0].Count != 1 {
+		t.Errorf("expected one failed event, got %+v", events.Items)
+	}
+	versions, err := service.Group.Requests.Versions.Rollup("app", "prod", now.Unix()-60, now.Unix()).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions.Items) != 2 {
+		t.Errorf("expected requests at 1.0.0 and 2.0.0, got %+v", versions.Items)
+	}
+}
+
+func TestRollout(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	s := New()
+	s.Now = func() time.Time { return now }
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	service := s.Service(ts.URL)
+
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable"}).Do()
+
+	if _, err := service.Group.Rollout.Active.Set("app", "prod", &update.RolloutActive{Active: true}).Do(); !isCode(err, 400) {
+		t.Errorf("expected a group without rollout not to be activated, got %v", err)
+	}
+	frames := []*update.Frame{{Percent: 10, Duration: 60}, {Percent: 50, Duration: 60}, {Percent: 100}}
+	if _, err := service.Group.Rollout.Set("app", "prod", &update.Rollout{Rollout: frames}).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Group.Rollout.Active.Set("app", "prod", &update.RolloutActive{Active: true}).Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []struct {
+		elapsed time.Duration
+		percent float64
+	}{
+		{0, 10},
+		{time.Minute, 50},
+		{90 * time.Second, 50},
+		{2 * time.Minute, 100},
+	} {
+		now = time.Unix(1500000000, 0).Add(step.elapsed)
+		p, err := service.Group.Percent.Get("app", "prod").Do()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.UpdatePercent != step.percent {
+			t.Errorf("after %v: expected %v%%, got %v%%", step.elapsed, step.percent, p.UpdatePercent)
+		}
+	}
+}
+
+func isCode(err error, code int) bool {
+	e, ok := err.(*googleapi.Error)
+	return ok && e.Code == code
+}
+
+func TestLimits(t *testing.T) {
+	s := New()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	service := s.Service(ts.URL)
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "1.0.0"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable"}).Do()
+
+	if _, err := service.App.Package.List("app").Limit(-1).Do(); !isCode(err, 400) {
+		t.Errorf("expected a negative limit to be refused, got %v", err)
+	}
+	if _, err := service.Group.List("app").Limit(-1).Do(); !isCode(err, 400) {
+		t.Errorf("expected a negative limit to be refused, got %v", err)
+	}
+	if _, err := service.Clientupdate.List().Skip(-1).Do(); !isCode(err, 400) {
+		t.Errorf("expected a negative skip to be refused, got %v", err)
+	}
+	if _, err := service.App.Package.List("app").Do(); err != nil {
+		t.Errorf("expected the server to answer after a refused request, got %v", err)
+	}
+
+	// only the latest history of a machine is kept
+	c := &omahaclient.Client{Server: ts.URL, AppID: "{app}", Version: "1.0.0", Track: "prod", MachineID: "m1"}
+	for i := 0; i < maxHistory+5; i++ {
+		if err := c.SendEvent(context.Background(), &omahaclient.Event{Type: "3", Result: "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.mu.Lock()
+	history, events := len(s.history["m1"]), len(s.events)
+	s.mu.Unlock()
+	if history != maxHistory || events != maxHistory+5 {
+		t.Errorf("expected %d history items and %d events, got %d and %d", maxHistory, maxHistory+5, history, events)
+	}
+}
@@ -0,0 +1,385 @@
+// Package notify watches the update service for changes of channels,
+// groups and rollouts and for spikes of failed updates, and sends them as
+// events to webhooks or commands.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/omahaclient"
+)
+
+// Types of events.
+const (
+	ChannelVersionChanged = "channel_version_changed"
+	GroupPaused           = "group_paused"
+	GroupUnpaused         = "group_unpaused"
+	RolloutStarted        = "rollout_started"
+	RolloutFinished       = "rollout_finished"
+	RolloutStopped        = "rollout_stopped"
+	ErrorSpike            = "error_spike"
+)
+
+// EventTypes are all types of events.
+var EventTypes = []string{
+	ChannelVersionChanged,
+	GroupPaused,
+	GroupUnpaused,
+	RolloutStarted,
+	RolloutFinished,
+	RolloutStopped,
+	ErrorSpike,
+}
+
+// maxClients is how many clients an error spike lists.
+const maxClients = 10
+
+// Event is a change noticed in the update service. Text is a message
+// for humans, named so that chat webhooks show it as is.
+type Event struct {
+	Type          string    `json:"type"`
+	Time          time.Time `json:"time"`
+	AppId         string    `json:"appId"`
+	GroupId       string    `json:"groupId,omitempty"`
+	Channel       string    `json:"channel,omitempty"`
+	Version       string    `json:"version,omitempty"`
+	OldVersion    string    `json:"oldVersion,omitempty"`
+	UpdatePercent float64   `json:"updatePercent,omitempty"`
+	Errors        int64     `json:"errors,omitempty"`
+	Clients       []string  `json:"clients,omitempty"`
+	Text          string    `json:"text"`
+}
+
+// GroupState is the part of a group whose changes are notified.
+type GroupState struct {
+	Channel       string
+	UpdatesPaused bool
+	RolloutActive bool
+	UpdatePercent float64
+}
+
+// State is the state of the watched apps, keyed by app ID and channel
+// label or group ID.
+type State struct {
+	Channels map[[2]string]string
+	Groups   map[[2]string]GroupState
+}
+
+// Diff returns the events which lead from old to new. Channels and groups
+// which appeared or disappeared are not reported.
+func Diff(old, new *State, now time.Time) []*Event {
+	var events []*Event
+
+	for _, key := range sortedKeys(new.Channels) {
+		version := new.Channels[key]
+		oldVersion, ok := old.Channels[key]
+		if !ok || oldVersion == version {
+			continue
+		}
+		events = append(events, &Event{
+			Type:       ChannelVersionChanged,
+			Time:       now,
+			AppId:      key[0],
+			Channel:    key[1],
+			Version:    version,
+			OldVersion: oldVersion,
+			Text:       fmt.Sprintf("Channel %s of app %s changed from %s to %s", key[1], key[0], oldVersion, version),
+		})
+	}
+
+	groupKeys := make([][2]string, 0, len(new.Groups))
+	for key := range new.Groups {
+		groupKeys = append(groupKeys, key)
+	}
+	sortKeys(groupKeys)
+	for _, key := range groupKeys {
+		g := new.Groups[key]
+		o, ok := old.Groups[key]
+		if !ok {
+			continue
+		}
+		event := func(typ, format string, v ...interface{}) {
+			events = append(events, &Event{
+				Type:          typ,
+				Time:          now,
+				AppId:         key[0],
+				GroupId:       key[1],
+				Channel:       g.Channel,
+				UpdatePercent: g.UpdatePercent,
+				Text:          fmt.Sprintf("Group %s of app %s: ", key[1], key[0]) + fmt.Sprintf(format, v...),
+			})
+		}
+
+		if g.UpdatesPaused != o.UpdatesPaused {
+			if g.UpdatesPaused {
+				event(GroupPaused, "updates paused")
+			} else {
+				event(GroupUnpaused, "updates unpaused")
+			}
+		}
+
+		switch {
+		case g.RolloutActive && !o.RolloutActive:
+			event(RolloutStarted, "rollout started at %g%%", g.UpdatePercent)
+		case g.RolloutActive && g.UpdatePercent >= 100 && o.UpdatePercent < 100,
+			!g.RolloutActive && o.RolloutActive && g.UpdatePercent >= 100 && o.UpdatePercent < 100:
+			event(RolloutFinished, "rollout finished")
+		case !g.RolloutActive && o.RolloutActive && o.UpdatePercent >= 100:
+			// the rollout was reported finished when it reached 100%
+		case !g.RolloutActive && o.RolloutActive:
+			event(RolloutStopped, "rollout stopped at %g%%", g.UpdatePercent)
+		}
+	}
+
+	return events
+}
+
+func sortedKeys(m map[[2]string]string) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+	return keys
+}
+
+func sortKeys(keys [][2]string) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+}
+
+// ErrorSpikes returns an event for every group and version with at least
+// threshold failed updates in updates.
+func ErrorSpikes(updates []*update.ClientUpdate, threshold int64, now time.Time) []*Event {
+	counts := make(map[[3]string]*Event)
+	var keys [][3]string
+	for _, u := range updates {
+		if u.EventResult != omahaclient.EventResultError {
+			continue
+		}
+		key := [3]string{u.AppId, u.GroupId, u.Version}
+		e, ok := counts[key]
+		if !ok {
+			e = &Event{
+				Type:    ErrorSpike,
+				Time:    now,
+				AppId:   u.AppId,
+				GroupId: u.GroupId,
+				Version: u.Version,
+			}
+			counts[key] = e
+			keys = append(keys, key)
+		}
+		e.Errors++
+		if len(e.Clients) < maxClients {
+			e.Clients = append(e.Clients, u.ClientId)
+		}
+	}
+
+	var events []*Event
+	for _, key := range keys {
+		e := counts[key]
+		if e.Errors < threshold {
+			continue
+		}
+		e.Text = fmt.Sprintf("Group %s of app %s: %d failed updates to %s", e.GroupId, e.AppId, e.Errors, e.Version)
+		events = append(events, e)
+	}
+	return events
+}
+
+// Watcher polls the update service and sends events to sinks.
+type Watcher struct {
+	Service *update.Service
+
+	// AppIds are the apps to watch. If empty, all apps are watched.
+	AppIds []string
+	// Types are the types of events to send. If empty, all are sent.
+	Types []string
+	// ErrorThreshold is the number of failed updates of a version in a
+	// group between two polls which makes an error spike.
+	ErrorThreshold int64
+	// Interval is the time between polls.
+	Interval time.Duration
+	Sinks    []Sink
+
+	// Logf, if set, is called with what the watcher does.
+	Logf func(format string, v ...interface{})
+
+	state    *State
+	lastPoll time.Time
+}
+
+func (w *Watcher) logf(format string, v ...interface{}) {
+	if w.Logf != nil {
+		w.Logf(format, v...)
+	}
+}
+
+// Run polls every interval until ctx is done. The first poll only records
+// the state to compare later polls with.
+func (w *Watcher) Run(ctx context.Context) error {
+	tick := time.NewTicker(w.Interval)
+	defer tick.Stop()
+	for {
+		events, err := w.Poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			w.logf("poll failed: %v", err)
+		}
+		w.send(ctx, events)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
+
+// Poll fetches the state of the update service and returns the events
+// since the previous poll.
+func (w *Watcher) Poll(ctx context.Context) ([]*Event, error) {
+	now := time.Now()
+	state, err := w.fetchState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*Event
+	if w.state != nil {
+		events = Diff(w.state, state, now)
+
+		updates, err := w.fetchErrors(ctx, w.lastPoll, now)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, ErrorSpikes(updates, w.ErrorThreshold, now)...)
+	}
+	w.state = state
+	w.lastPoll = now
+	return w.filter(events), nil
+}
+
+func (w *Watcher) filter(events []*Event) []*Event {
+	if len(w.Types) == 0 {
+		return events
+	}
+	var filtered []*Event
+	for _, e := range events {
+		for _, typ := range w.Types {
+			if e.Type == typ {
+				filtered = append(filtered, e)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+func (w *Watcher) send(ctx context.Context, events []*Event) {
+	for _, e := range events {
+		w.logf("%s", e.Text)
+		for _, sink := range w.Sinks {
+			if err := sink.Send(ctx, e); err != nil {
+				w.logf("sending %s to %s failed: %v", e.Type, sink, err)
+			}
+		}
+	}
+}
+
+func (w *Watcher) appIds(ctx context.Context) ([]string, error) {
+	if len(w.AppIds) > 0 {
+		return w.AppIds, nil
+	}
+	apps, err := w.Service.App.List().Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	var appIds []string
+	for _, app := range apps.Items {
+		appIds = append(appIds, app.Id)
+	}
+	return appIds, nil
+}
+
+func (w *Watcher) fetchState(ctx context.Context) (*State, error) {
+	appIds, err := w.appIds(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{
+		Channels: make(map[[2]string]string),
+		Groups:   make(map[[2]string]GroupState),
+	}
+	for _, appId := range appIds {
+		channels, err := w.Service.Channel.List(appId).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range channels.Items {
+			state.Channels[[2]string{appId, channel.Label}] = channel.Version
+		}
+
+		groups, err := w.Service.Group.List(appId).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups.Items {
+			state.Groups[[2]string{appId, group.Id}] = GroupState{
+				Channel:       group.ChannelId,
+				UpdatesPaused: group.UpdatesPaused,
+				RolloutActive: group.RolloutActive,
+				UpdatePercent: group.UpdatePercent,
+			}
+		}
+	}
+	return state, nil
+}
+
+// errorsPageSize is the number of client updates fetched per request.
+const errorsPageSize = 1000
+
+// fetchErrors lists the failed updates reported between start and end.
+func (w *Watcher) fetchErrors(ctx context.Context, start, end time.Time) ([]*update.ClientUpdate, error) {
+	appIds, err := w.appIds(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []*update.ClientUpdate
+	for _, appId := range appIds {
+		var skip int64
+		for {
+			list, err := w.Service.Clientupdate.List().
+				AppId(appId).
+				EventResult(omahaclient.EventResultError).
+				DateStart(start.Unix()).
+				DateEnd(end.Unix()).
+				Limit(errorsPageSize).
+				Skip(skip).
+				Context(ctx).
+				Do()
+			if err != nil {
+				return nil, err
+			}
+			updates = append(updates, list.Items...)
+			if len(list.Items) < errorsPageSize {
+				break
+			}
+			skip += int64(len(list.Items))
+		}
+	}
+	return updates, nil
+}
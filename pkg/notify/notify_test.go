@@ -0,0 +1,141 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+func TestDiff(t *testing.T) {
+	now := time.Unix(1000, 0)
+	old := &State{
+		Channels: map[[2]string]string{
+			{"app", "stable"}: "1.0.0",
+			{"app", "beta"}:   "1.1.0",
+		},
+		Groups: map[[2]string]GroupState{
+			{"app", "paused"}:   {Channel: "stable"},
+			{"app", "started"}:  {Channel: "beta", UpdatePercent: 1},
+			{"app", "finished"}: {Channel: "beta", RolloutActive: true, UpdatePercent: 50},
+			{"app", "jumped"}:   {Channel: "beta", RolloutActive: true, UpdatePercent: 90},
+			{"app", "done"}:     {Channel: "beta", RolloutActive: true, UpdatePercent: 100},
+			{"app", "stopped"}:  {Channel: "beta", RolloutActive: true, UpdatePercent: 10},
+			{"app", "same"}:     {Channel: "beta", RolloutActive: true, UpdatePercent: 10},
+		},
+	}
+	new := &State{
+		Channels: map[[2]string]string{
+			{"app", "stable"}: "1.1.0",
+			{"app", "beta"}:   "1.1.0",
+			{"app", "alpha"}:  "1.2.0",
+		},
+		Groups: map[[2]string]GroupState{
+			{"app", "paused"}:   {Channel: "stable", UpdatesPaused: true},
+			{"app", "started"}:  {Channel: "beta", RolloutActive: true, UpdatePercent: 1},
+			{"app", "finished"}: {Channel: "beta", RolloutActive: true, UpdatePercent: 100},
+			// finishing and ending the rollout between two polls
+			{"app", "jumped"}: {Channel: "beta", UpdatePercent: 100},
+			// the end of a rollout already reported finished
+			{"app", "done"}:    {Channel: "beta", UpdatePercent: 100},
+			{"app", "stopped"}: {Channel: "beta", UpdatePercent: 10},
+			{"app", "same"}:    {Channel: "beta", RolloutActive: true, UpdatePercent: 20},
+			{"app", "new"}:     {Channel: "beta", UpdatesPaused: true},
+		},
+	}
+
+	events := Diff(old, new, now)
+
+	expected := []struct {
+		typ, channel, group string
+	}{
+		{ChannelVersionChanged, "stable", ""},
+		{RolloutFinished, "beta", "finished"},
+		{RolloutFinished, "beta", "jumped"},
+		{GroupPaused, "stable", "paused"},
+		{RolloutStarted, "beta", "started"},
+		{RolloutStopped, "beta", "stopped"},
+	}
+	if len(events) != len(expected) {
+		for _, e := range events {
+			t.Log(e.Text)
+		}
+		t.Fatalf("expected %d events, got %d", len(expected), len(events))
+	}
+	for i, e := range expected {
+		got := events[i]
+		if got.Type != e.typ || got.Channel != e.channel || got.GroupId != e.group {
+			t.Errorf("event %d: expected %s of %s/%s, got %s of %s/%s",
+				i, e.typ, e.channel, e.group, got.Type, got.Channel, got.GroupId)
+		}
+		if !got.Time.Equal(now) || got.Text == "" {
+			t.Errorf("event %d: expected time and text, got %v %q", i, got.Time, got.Text)
+		}
+	}
+	if events[0].OldVersion != "1.0.0" || events[0].Version != "1.1.0" {
+		t.Errorf("expected change from 1.0.0 to 1.1.0, got %s to %s", events[0].OldVersion, events[0].Version)
+	}
+}
+
+func TestErrorSpikes(t *testing.T) {
+	var updates []*update.ClientUpdate
+	add := func(n int, group, version, result string) {
+		for i := 0; i < n; i++ {
+			updates = append(updates, &update.ClientUpdate{
+				AppId:       "app",
+				GroupId:     group,
+				Version:     version,
+				EventResult: result,
+				ClientId:    "client",
+			})
+		}
+	}
+	add(12, "alpha", "2.0.0", "0")
+	add(4, "alpha", "1.0.0", "0")
+	add(20, "beta", "2.0.0", "1")
+
+	events := ErrorSpikes(updates, 5, time.Unix(0, 0))
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	e := events[0]
+	if e.Type != ErrorSpike || e.GroupId != "alpha" || e.Version != "2.0.0" || e.Errors != 12 {
+		t.Errorf("expected 12 errors of alpha 2.0.0, got %d of %s %s", e.Errors, e.GroupId, e.Version)
+	}
+	if len(e.Clients) != maxClients {
+		t.Errorf("expected %d clients, got %d", maxClients, len(e.Clients))
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var received Event
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected %s request of %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	h := &Webhook{URL: server.URL}
+	e := &Event{Type: GroupPaused, AppId: "app", GroupId: "alpha", Text: "paused"}
+	if err := h.Send(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	if received.Type != GroupPaused || received.GroupId != "alpha" || received.Text != "paused" {
+		t.Errorf("unexpected event %+v", received)
+	}
+
+	status = http.StatusInternalServerError
+	if err := h.Send(context.Background(), e); err == nil {
+		t.Error("expected an error for status 500")
+	}
+}
@@ -0,0 +1,90 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Sink receives events.
+type Sink interface {
+	Send(ctx context.Context, e *Event) error
+}
+
+// Webhook posts events as JSON to a URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// webhookTimeout limits how long a webhook may take when no client is set.
+const webhookTimeout = 10 * time.Second
+
+func (h *Webhook) String() string {
+	return h.URL
+}
+
+// Send posts e and fails unless the webhook responds with a 2xx status.
+func (h *Webhook) Send(ctx context.Context, e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: webhookTimeout}
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Command runs a shell command for every event, with the event as JSON on
+// its standard input and its fields in UPDATE_SERVICE_* variables.
+type Command struct {
+	Command string
+}
+
+func (c *Command) String() string {
+	return c.Command
+}
+
+// Send runs the command and fails if it exits with a non-zero status.
+func (c *Command) Send(ctx context.Context, e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"UPDATE_SERVICE_EVENT="+e.Type,
+		"UPDATE_SERVICE_APP_ID="+e.AppId,
+		"UPDATE_SERVICE_GROUP_ID="+e.GroupId,
+		"UPDATE_SERVICE_CHANNEL="+e.Channel,
+		"UPDATE_SERVICE_VERSION="+e.Version,
+		"UPDATE_SERVICE_OLD_VERSION="+e.OldVersion,
+		"UPDATE_SERVICE_TEXT="+e.Text,
+	)
+	return cmd.Run()
+}
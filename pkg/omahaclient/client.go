@@ -0,0 +1,205 @@
+// Package omahaclient talks to the Omaha endpoint of an update service on
+// behalf of a machine, the way update_engine does.
+package omahaclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-omaha/omaha"
+)
+
+// Omaha event types and results reported by clients.
+const (
+	EventTypeDownloadComplete = "1"
+	EventTypeUpdateComplete   = "3"
+	EventTypeDownloadStarted  = "13"
+	EventTypeDownloadFinished = "14"
+
+	EventResultError         = "0"
+	EventResultSuccess       = "1"
+	EventResultSuccessReboot = "2"
+)
+
+// EventTypeName returns the name of an Omaha event type, or the type
+// itself if it is unknown.
+func EventTypeName(eventType string) string {
+	return eventName(omaha.EventTypes, eventType)
+}
+
+// EventResultName returns the name of an Omaha event result, or the result
+// itself if it is unknown.
+func EventResultName(eventResult string) string {
+	return eventName(omaha.EventResults, eventResult)
+}
+
+func eventName(names map[int]string, code string) string {
+	n, err := strconv.Atoi(code)
+	if err != nil {
+		return code
+	}
+	if name, ok := names[n]; ok {
+		return name
+	}
+	return code
+}
+
+// Event is an Omaha event sent along with a request.
+type Event struct {
+	Type      string
+	Result    string
+	ErrorCode string
+}
+
+// Client identifies a single machine to the update service.
+type Client struct {
+	// Server is the base URL of the update service.
+	Server string
+	// HTTPClient is used to send requests. If nil, http.DefaultClient
+	// is used.
+	HTTPClient *http.Client
+
+	AppID     string
+	Version   string
+	Track     string
+	MachineID string
+	BootID    string
+	OEM       string
+
+	// InstallSource is "ondemandupdate" for forced updates and
+	// "scheduler" otherwise. It is left out of requests if empty.
+	InstallSource string
+
+	// Logf, if set, is called with every request and response body.
+	Logf func(format string, v ...interface{})
+
+	// Stats, if set, collects the requests and update checks of the
+	// client.
+	Stats *Stats
+}
+
+// NewRequest builds an Omaha request for the client.
+func (c *Client) NewRequest(updateCheck, ping bool, events ...*Event) *omaha.Request {
+	// TODO: Fill out the OS field correctly based on /etc/os-release
+	req := omaha.NewRequest("lsb", "CoreOS", "", "")
+	req.InstallSource = c.InstallSource
+
+	app := req.AddApp(c.AppID, c.Version)
+	app.MachineID = c.MachineID
+	app.BootId = c.BootID
+	app.Track = c.Track
+	app.OEM = c.OEM
+
+	if updateCheck {
+		app.AddUpdateCheck()
+	}
+
+	if ping {
+		app.AddPing()
+		app.Ping.LastReportDays = "1"
+		app.Ping.Status = "1"
+	}
+
+	for _, e := range events {
+		event := app.AddEvent()
+		event.Type = e.Type
+		event.Result = e.Result
+		event.ErrorCode = e.ErrorCode
+	}
+
+	return req
+}
+
+// Send posts req to the update service and decodes the response.
+func (c *Client) Send(ctx context.Context, req *omaha.Request) (*omaha.Response, error) {
+	started := time.Now()
+	resp, err := c.send(ctx, req)
+	// requests cut short by the end of ctx say nothing about the server
+	if ctx.Err() == nil {
+		c.Stats.request(time.Since(started), err)
+	}
+	return resp, err
+}
+
+func (c *Client) send(ctx context.Context, req *omaha.Request) (*omaha.Response, error) {
+	raw, err := xml.MarshalIndent(req, "", " ")
+	if err != nil {
+		return nil, err
+	}
+	c.logf("request: %s%s\n", xml.Header, raw)
+
+	u, err := url.Parse(c.Server)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, "/v1/update/")
+
+	hreq, err := http.NewRequest("POST", u.String(), bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "text/xml")
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(hreq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.logf("response: %s%s\n", xml.Header, body)
+
+	oresp := new(omaha.Response)
+	if err := xml.Unmarshal(body, oresp); err != nil {
+		return nil, fmt.Errorf("invalid omaha response (HTTP %d): %v", resp.StatusCode, err)
+	}
+	return oresp, nil
+}
+
+// UpdateCheck asks the update service for a new version and returns the
+// update check of the client's app.
+func (c *Client) UpdateCheck(ctx context.Context, events ...*Event) (*omaha.UpdateCheck, error) {
+	resp, err := c.Send(ctx, c.NewRequest(true, false, events...))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Apps) == 0 || resp.Apps[0].UpdateCheck == nil {
+		return nil, errors.New("omaha response contains no update check")
+	}
+	c.Stats.status(resp.Apps[0].UpdateCheck.Status)
+	return resp.Apps[0].UpdateCheck, nil
+}
+
+// SendEvent reports a single event.
+func (c *Client) SendEvent(ctx context.Context, event *Event) error {
+	_, err := c.Send(ctx, c.NewRequest(false, false, event))
+	return err
+}
+
+// Ping tells the update service the client is still alive.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Send(ctx, c.NewRequest(false, true))
+	return err
+}
+
+func (c *Client) logf(format string, v ...interface{}) {
+	if c.Logf != nil {
+		c.Logf(format, v...)
+	}
+}
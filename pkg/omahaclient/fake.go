@@ -0,0 +1,202 @@
+package omahaclient
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/coreos/go-omaha/omaha"
+	"github.com/pborman/uuid"
+)
+
+// Fake simulates a machine that periodically checks for updates and walks
+// through the download and install events when one is offered.
+type Fake struct {
+	*Client
+
+	// ErrorRate is the chance, in percent, of each update step failing.
+	ErrorRate int
+	// FailureRates, if set, replaces ErrorRate with the chance, from 0 to
+	// 1, of the update step with the given event type failing.
+	FailureRates map[string]float64
+	// MinSleep and MaxSleep bound the random time between update checks.
+	MinSleep time.Duration
+	MaxSleep time.Duration
+	// PingInterval, if set, is the time between pings sent while
+	// waiting for the next update check.
+	PingInterval time.Duration
+	// Pings is the number of pings sent after installing an update
+	// before reporting completion, simulating a held reboot lock.
+	Pings int
+	// MinRebootDelay and MaxRebootDelay bound the random time between
+	// installing an update and reporting the new version.
+	MinRebootDelay time.Duration
+	MaxRebootDelay time.Duration
+
+	// Log, if set, receives progress messages.
+	Log func(format string, v ...interface{})
+}
+
+// stepDelay is the time between the steps of an update and between the
+// pings of a held reboot lock.
+var stepDelay = time.Second
+
+// updateSteps are the events a client sends while applying an update.
+var updateSteps = []Event{
+	{Type: EventTypeDownloadStarted, Result: EventResultSuccess},
+	{Type: EventTypeDownloadFinished, Result: EventResultSuccess},
+	{Type: EventTypeUpdateComplete, Result: EventResultSuccess},
+}
+
+// Run checks for updates at random intervals and applies them until ctx
+// is done.
+func (f *Fake) Run(ctx context.Context) error {
+	f.Stats.version(f.MachineID, f.Version)
+	for {
+		if err := f.wait(ctx, randDuration(f.MinSleep, f.MaxSleep)); err != nil {
+			return err
+		}
+
+		uc, err := f.UpdateCheck(ctx, &Event{
+			Type:   EventTypeUpdateComplete,
+			Result: EventResultSuccessReboot,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			f.log("%v\n", err)
+			continue
+		}
+		if err := f.Update(ctx, uc); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			f.log("%v\n", err)
+		}
+	}
+}
+
+// wait waits for d, sending pings every PingInterval if set.
+func (f *Fake) wait(ctx context.Context, d time.Duration) error {
+	if f.PingInterval <= 0 {
+		return sleep(ctx, d)
+	}
+	for d > f.PingInterval {
+		if err := sleep(ctx, f.PingInterval); err != nil {
+			return err
+		}
+		if err := f.Ping(ctx); err != nil {
+			f.log("%v\n", err)
+		}
+		d -= f.PingInterval
+	}
+	return sleep(ctx, d)
+}
+
+// failed decides at random whether step fails.
+func (f *Fake) failed(step Event) bool {
+	if f.FailureRates != nil {
+		return rand.Float64() < f.FailureRates[step.Type]
+	}
+	return rand.Intn(100) < f.ErrorRate
+}
+
+// Update goes through the update steps for the update check and switches
+// the client to the new version. Steps fail at random according to
+// ErrorRate or FailureRates, in which case the update is abandoned until
+// the next check.
+func (f *Fake) Update(ctx context.Context, uc *omaha.UpdateCheck) error {
+	if uc.Status != "ok" {
+		f.log("%s\n", uc.Status)
+		return nil
+	}
+	if uc.Manifest == nil {
+		f.log("update check has no manifest\n")
+		return nil
+	}
+
+	for i, step := range updateSteps {
+		if i > 0 {
+			if err := sleep(ctx, stepDelay); err != nil {
+				return err
+			}
+		}
+
+		event := step
+		failed := f.failed(step)
+		if failed {
+			event = Event{
+				Type:      EventTypeUpdateComplete,
+				Result:    EventResultError,
+				ErrorCode: "2000",
+			}
+		}
+		if err := f.SendEvent(ctx, &event); err != nil {
+			return err
+		}
+		if failed {
+			f.Stats.update(true)
+			f.log("failed to update in eventType: %s, eventResult: %s. Retrying.\n", step.Type, step.Result)
+			if err := sleep(ctx, f.MinSleep); err != nil {
+				return err
+			}
+			return f.SendEvent(ctx, &step)
+		}
+	}
+
+	// simulate reboot lock for a while, on every update
+	for pings := f.Pings; pings > 0; pings-- {
+		f.Ping(ctx)
+		if err := sleep(ctx, stepDelay); err != nil {
+			return err
+		}
+	}
+
+	if err := sleep(ctx, randDuration(f.MinRebootDelay, f.MaxRebootDelay)); err != nil {
+		return err
+	}
+
+	// Send complete with new version. The update only counts once the
+	// server heard of it.
+	oldVersion := f.Version
+	f.Version = uc.Manifest.Version
+	if err := f.SendEvent(ctx, &Event{
+		Type:   EventTypeUpdateComplete,
+		Result: EventResultSuccessReboot,
+	}); err != nil {
+		f.Version = oldVersion
+		return err
+	}
+	f.BootID = uuid.New()
+	f.log("updated from %s to %s\n", oldVersion, f.Version)
+	f.Stats.update(false)
+	f.Stats.version(f.MachineID, f.Version)
+	return nil
+}
+
+func (f *Fake) log(format string, v ...interface{}) {
+	if f.Log != nil {
+		f.Log(format, v...)
+	}
+}
+
+// randDuration returns a random duration between min and max.
+func randDuration(min, max time.Duration) time.Duration {
+	if max-min > 0 {
+		return time.Duration(rand.Int63n(int64(max-min))) + min
+	}
+	return max
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
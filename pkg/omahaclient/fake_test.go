@@ -0,0 +1,94 @@
+package omahaclient
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-omaha/omaha"
+)
+
+func TestFakeRebootPings(t *testing.T) {
+	defer func(d time.Duration) { stepDelay = d }(stepDelay)
+	stepDelay = time.Millisecond
+
+	var pings int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req omaha.Request
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Apps) > 0 && req.Apps[0].Ping != nil {
+			atomic.AddInt32(&pings, 1)
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, `<response protocol="3.0" server="s"><daystart elapsed_seconds="0"/><app appid="app" status="ok"/></response>`)
+	}))
+	defer server.Close()
+
+	f := &Fake{
+		Client:    &Client{Server: server.URL, AppID: "app", Version: "1.0.0"},
+		ErrorRate: 0,
+		Pings:     3,
+	}
+	for _, version := range []string{"2.0.0", "3.0.0"} {
+		uc := &omaha.UpdateCheck{Status: "ok", Manifest: &omaha.Manifest{Version: version}}
+		if err := f.Update(context.Background(), uc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := atomic.LoadInt32(&pings); got != 6 {
+		t.Errorf("expected the reboot lock to hold both updates for 3 pings, got %d pings", got)
+	}
+	if f.Version != "3.0.0" || f.Pings != 3 {
+		t.Errorf("expected version 3.0.0 with 3 pings per update, got %s with %d", f.Version, f.Pings)
+	}
+}
+
+func TestFakeUnreportedUpdate(t *testing.T) {
+	defer func(d time.Duration) { stepDelay = d }(stepDelay)
+	stepDelay = time.Millisecond
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req omaha.Request
+		xml.NewDecoder(r.Body).Decode(&req)
+		if len(req.Apps) > 0 && len(req.Apps[0].Events) > 0 && req.Apps[0].Events[0].Result == EventResultSuccessReboot {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, `<response protocol="3.0" server="s"><daystart elapsed_seconds="0"/><app appid="app" status="ok"/></response>`)
+	}))
+	defer server.Close()
+
+	stats := NewStats()
+	f := &Fake{
+		Client:    &Client{Server: server.URL, AppID: "app", Version: "1.0.0", MachineID: "m", Stats: stats},
+		ErrorRate: 0,
+	}
+	uc := &omaha.UpdateCheck{Status: "ok", Manifest: &omaha.Manifest{Version: "2.0.0"}}
+	if err := f.Update(context.Background(), uc); err == nil {
+		t.Fatal("expected the failed completion event to fail the update")
+	}
+	if report := stats.Report(); f.Version != "1.0.0" || stats.Reached("2.0.0") != 0 || report.UpdatesSuccess != 0 {
+		t.Errorf("expected the unreported update not to count, got version %s and %+v", f.Version, report)
+	}
+}
+
+func TestFakeErrorRate(t *testing.T) {
+	never, always := &Fake{ErrorRate: 0}, &Fake{ErrorRate: 100}
+	for i := 0; i < 1000; i++ {
+		if never.failed(Event{Type: EventTypeUpdateComplete}) {
+			t.Fatal("expected an error rate of 0 never to fail")
+		}
+		if !always.failed(Event{Type: EventTypeUpdateComplete}) {
+			t.Fatal("expected an error rate of 100 always to fail")
+		}
+	}
+}
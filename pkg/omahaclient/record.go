@@ -0,0 +1,217 @@
+package omahaclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-omaha/omaha"
+)
+
+// Record is an Omaha request and the response of the update service to
+// it, as written by a Recorder.
+type Record struct {
+	Time     time.Time `json:"time"`
+	Path     string    `json:"path"`
+	Request  string    `json:"request"`
+	Status   int       `json:"status"`
+	Response string    `json:"response"`
+}
+
+// Recorder is an Omaha proxy: it forwards requests to an update service
+// and writes every request and response as a line of JSON.
+type Recorder struct {
+	// Server is the base URL of the update service.
+	Server string
+	// HTTPClient is used to forward requests. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	mu      sync.Mutex
+	enc     *json.Encoder
+	records int
+}
+
+// NewRecorder returns a Recorder forwarding to server and writing to w.
+func NewRecorder(server string, w io.Writer) *Recorder {
+	return &Recorder{Server: server, enc: json.NewEncoder(w)}
+}
+
+// Records returns the number of records written.
+func (r *Recorder) Records() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.records
+}
+
+// ServeHTTP forwards req and records it along with the response.
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	record := &Record{
+		Time:    time.Now(),
+		Path:    req.URL.Path,
+		Request: string(body),
+	}
+	resp, err := post(req.Context(), r.HTTPClient, r.Server, req.URL.Path, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	record.Status = resp.StatusCode
+	record.Response = string(respBody)
+
+	r.mu.Lock()
+	err = r.enc.Encode(record)
+	if err == nil {
+		r.records++
+	}
+	r.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+}
+
+// post sends an Omaha request body to the path of server.
+func post(ctx context.Context, client *http.Client, server, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", strings.TrimSuffix(server, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req.WithContext(ctx))
+}
+
+// ReplayResult is the outcome of replaying one record.
+type ReplayResult struct {
+	Record *Record
+	// Status and Response are what the update service answered, Err
+	// why it did not.
+	Status   int
+	Response string
+	Err      error
+	// Match tells whether the response is the recorded one, ignoring the
+	// time of day and the name of the server.
+	Match bool
+}
+
+// Replayer resends recorded requests to an update service.
+type Replayer struct {
+	// Server is the base URL of the update service.
+	Server string
+	// HTTPClient is used to send requests. If nil, http.DefaultClient
+	// is used.
+	HTTPClient *http.Client
+	// Speed scales the time between records: 2 replays twice as fast
+	// as recorded. If 0, records are replayed without waiting.
+	Speed float64
+}
+
+// maxRecordSize is the longest line of a recording accepted.
+const maxRecordSize = 16 << 20
+
+// Replay reads records from r, sends them in order and calls fn with the
+// result of each. It stops at the first invalid record or when ctx is
+// done.
+func (p *Replayer) Replay(ctx context.Context, r io.Reader, fn func(*ReplayResult)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+
+	var first time.Time
+	started := time.Now()
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		record := new(Record)
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+
+		if p.Speed > 0 {
+			if first.IsZero() {
+				first = record.Time
+			}
+			due := started.Add(time.Duration(float64(record.Time.Sub(first)) / p.Speed))
+			if err := sleep(ctx, time.Until(due)); err != nil {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fn(p.send(ctx, record))
+	}
+	return scanner.Err()
+}
+
+func (p *Replayer) send(ctx context.Context, record *Record) *ReplayResult {
+	result := &ReplayResult{Record: record}
+	resp, err := post(ctx, p.HTTPClient, p.Server, record.Path, []byte(record.Request))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Status = resp.StatusCode
+	result.Response = string(body)
+	result.Match = resp.StatusCode == record.Status && SameResponse(record.Response, result.Response)
+	return result
+}
+
+// NormalizeResponse returns an Omaha response without the parts which
+// change from one request to the next: the time of day and the name of
+// the server. Responses which are not Omaha are returned as is.
+func NormalizeResponse(response string) string {
+	resp := new(omaha.Response)
+	if err := xml.Unmarshal([]byte(response), resp); err != nil {
+		return response
+	}
+	resp.DayStart = omaha.DayStart{}
+	resp.Server = ""
+	b, err := xml.MarshalIndent(resp, "", " ")
+	if err != nil {
+		return response
+	}
+	return string(b)
+}
+
+// SameResponse tells whether two responses match once normalized.
+func SameResponse(a, b string) bool {
+	return NormalizeResponse(a) == NormalizeResponse(b)
+}
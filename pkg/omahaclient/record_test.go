@@ -0,0 +1,76 @@
+package omahaclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	version := "2.0.0"
+	elapsed := 100
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		elapsed++
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<response protocol="3.0" server="s%d"><daystart elapsed_seconds="%d"/>`+
+			`<app appid="app" status="ok"><updatecheck status="ok"><manifest version="%s"/></updatecheck></app></response>`,
+			elapsed, elapsed, version)
+	}))
+	defer server.Close()
+
+	var recording bytes.Buffer
+	recorder := NewRecorder(server.URL, &recording)
+	proxy := httptest.NewServer(recorder)
+	defer proxy.Close()
+
+	c := &Client{Server: proxy.URL, AppID: "app", Version: "1.0.0"}
+	for i := 0; i < 3; i++ {
+		uc, err := c.UpdateCheck(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uc.Manifest == nil || uc.Manifest.Version != "2.0.0" {
+			t.Fatalf("expected the response of the server through the proxy, got %+v", uc)
+		}
+	}
+	if recorder.Records() != 3 || strings.Count(recording.String(), "\n") != 3 {
+		t.Fatalf("expected 3 records, got %d:\n%s", recorder.Records(), recording.String())
+	}
+
+	replay := func() (matched, differed int) {
+		p := &Replayer{Server: server.URL}
+		err := p.Replay(context.Background(), bytes.NewReader(recording.Bytes()), func(r *ReplayResult) {
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+			if r.Match {
+				matched++
+			} else {
+				differed++
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	// the time of day and the server name changed, which is ignored
+	if matched, differed := replay(); matched != 3 || differed != 0 {
+		t.Errorf("expected 3 matches, got %d and %d differences", matched, differed)
+	}
+
+	version = "3.0.0"
+	if matched, differed := replay(); matched != 0 || differed != 3 {
+		t.Errorf("expected 3 differences, got %d and %d matches", differed, matched)
+	}
+
+	p := &Replayer{Server: server.URL}
+	if err := p.Replay(context.Background(), strings.NewReader("{not json"), func(*ReplayResult) {}); err == nil {
+		t.Error("expected an error for an invalid record")
+	}
+}
@@ -0,0 +1,247 @@
+package omahaclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/pborman/uuid"
+)
+
+// Scenario describes populations of fake clients to simulate.
+//
+// A scenario file is YAML or JSON:
+//
+//	populations:
+//	- name: stable
+//	  count: 100
+//	  appId: e96281a6-d1af-4bde-9a0a-97b76e56dc57
+//	  group: stable
+//	  version: 1.0.0
+//	  oems: {gce: 3, ami: 1}
+//	  checkInterval: {min: 1m, max: 10m}
+//	  pingInterval: 30s
+//	  rebootDelay: {min: 10s, max: 2m}
+//	  failures: {downloadStarted: 0.01, downloadFinished: 0.01, install: 0.05}
+type Scenario struct {
+	Populations []*Population `json:"populations"`
+}
+
+// Population is a number of fake clients which behave alike.
+type Population struct {
+	Name    string `json:"name"`
+	Count   int    `json:"count"`
+	AppId   string `json:"appId"`
+	Group   string `json:"group"`
+	Version string `json:"version"`
+
+	// OEMs maps OEM names to weights. Each client picks one at random.
+	OEMs        map[string]int `json:"oems"`
+	ForceUpdate bool           `json:"forceUpdate"`
+
+	// CheckInterval bounds the random time between update checks,
+	// PingInterval is the time between pings in between.
+	CheckInterval Range    `json:"checkInterval"`
+	PingInterval  Duration `json:"pingInterval"`
+	// RebootDelay bounds the time between installing an update and
+	// reporting the new version, RebootPings is the number of pings sent
+	// meanwhile for a held reboot lock.
+	RebootDelay Range `json:"rebootDelay"`
+	RebootPings int   `json:"rebootPings"`
+
+	Failures Failures `json:"failures"`
+}
+
+// Failures are the chances, from 0 to 1, of each update step failing.
+type Failures struct {
+	DownloadStarted  float64 `json:"downloadStarted"`
+	DownloadFinished float64 `json:"downloadFinished"`
+	Install          float64 `json:"install"`
+}
+
+// Range is an interval of durations.
+type Range struct {
+	Min Duration `json:"min"`
+	Max Duration `json:"max"`
+}
+
+// Duration is a time.Duration read from a string such as "90s" or a
+// number of seconds.
+type Duration time.Duration
+
+// UnmarshalJSON reads a duration string or a number of seconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var secs float64
+		if err := json.Unmarshal(b, &secs); err != nil {
+			return fmt.Errorf("invalid duration %s", b)
+		}
+		*d = Duration(secs * float64(time.Second))
+		return nil
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		*d = Duration(secs * float64(time.Second))
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ReadScenario reads a scenario in YAML or JSON and validates it.
+func ReadScenario(r io.Reader) (*Scenario, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var s Scenario
+	if err := dec.Decode(&s); err != nil {
+		return nil, err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Validate checks that every population has clients, an app and a group,
+// and sane intervals and chances.
+func (s *Scenario) Validate() error {
+	if len(s.Populations) == 0 {
+		return errors.New("scenario has no populations")
+	}
+	for i, p := range s.Populations {
+		name := p.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("population %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (p *Population) validate() error {
+	switch {
+	case p.Count <= 0:
+		return errors.New("count must be positive")
+	case p.AppId == "":
+		return errors.New("appId is required")
+	case p.Group == "":
+		return errors.New("group is required")
+	case p.CheckInterval.Max <= 0:
+		return errors.New("checkInterval max must be positive")
+	case p.CheckInterval.Min < 0 || p.CheckInterval.Max < p.CheckInterval.Min:
+		return errors.New("checkInterval must be 0 <= min <= max")
+	case p.RebootDelay.Min < 0 || p.RebootDelay.Max < p.RebootDelay.Min:
+		return errors.New("rebootDelay must be 0 <= min <= max")
+	case p.PingInterval < 0:
+		return errors.New("pingInterval must not be negative")
+	case p.RebootPings < 0:
+		return errors.New("rebootPings must not be negative")
+	}
+	for _, f := range []float64{p.Failures.DownloadStarted, p.Failures.DownloadFinished, p.Failures.Install} {
+		if f < 0 || f > 1 {
+			return errors.New("failures must be between 0 and 1")
+		}
+	}
+	for oem, weight := range p.OEMs {
+		if weight < 0 {
+			return fmt.Errorf("weight of oem %s must not be negative", oem)
+		}
+	}
+	return nil
+}
+
+// Total returns the number of clients of all populations.
+func (s *Scenario) Total() int {
+	var total int
+	for _, p := range s.Populations {
+		total += p.Count
+	}
+	return total
+}
+
+// oem picks an OEM at random according to the weights.
+func (p *Population) oem() string {
+	names := make([]string, 0, len(p.OEMs))
+	var total int
+	for name, weight := range p.OEMs {
+		names = append(names, name)
+		total += weight
+	}
+	if total == 0 {
+		return ""
+	}
+	sort.Strings(names)
+
+	n := rand.Intn(total)
+	for _, name := range names {
+		if n < p.OEMs[name] {
+			return name
+		}
+		n -= p.OEMs[name]
+	}
+	return ""
+}
+
+// NewFake returns a fake client of the population talking to server.
+func (p *Population) NewFake(server, machineID string) *Fake {
+	installSource := "scheduler"
+	if p.ForceUpdate {
+		installSource = "ondemandupdate"
+	}
+	version := p.Version
+	if version == "" {
+		version = "0.0.0"
+	}
+
+	return &Fake{
+		Client: &Client{
+			Server:        server,
+			AppID:         p.AppId,
+			Version:       version,
+			Track:         p.Group,
+			MachineID:     machineID,
+			BootID:        uuid.New(),
+			OEM:           p.oem(),
+			InstallSource: installSource,
+		},
+		FailureRates: map[string]float64{
+			EventTypeDownloadStarted:  p.Failures.DownloadStarted,
+			EventTypeDownloadFinished: p.Failures.DownloadFinished,
+			EventTypeUpdateComplete:   p.Failures.Install,
+		},
+		MinSleep:       time.Duration(p.CheckInterval.Min),
+		MaxSleep:       time.Duration(p.CheckInterval.Max),
+		PingInterval:   time.Duration(p.PingInterval),
+		Pings:          p.RebootPings,
+		MinRebootDelay: time.Duration(p.RebootDelay.Min),
+		MaxRebootDelay: time.Duration(p.RebootDelay.Max),
+	}
+}
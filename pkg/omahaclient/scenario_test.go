@@ -0,0 +1,83 @@
+package omahaclient
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadScenario(t *testing.T) {
+	s, err := ReadScenario(strings.NewReader(`
+populations:
+- name: stable
+  count: 3
+  appId: app
+  group: stable
+  version: 1.0.0
+  oems: {gce: 1}
+  checkInterval: {min: 1m, max: 600}
+  pingInterval: 30s
+  rebootDelay: {min: 1.5, max: 2m}
+  rebootPings: 2
+  failures: {downloadStarted: 0.1, install: 0.5}
+- count: 2
+  appId: app
+  group: beta
+  checkInterval: {max: 10s}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Populations) != 2 || s.Total() != 5 {
+		t.Fatalf("expected 2 populations of 5 clients, got %d of %d", len(s.Populations), s.Total())
+	}
+
+	f := s.Populations[0].NewFake("http://localhost", "machine")
+	if f.AppID != "app" || f.Track != "stable" || f.Version != "1.0.0" || f.OEM != "gce" {
+		t.Errorf("unexpected client %+v", f.Client)
+	}
+	if f.MinSleep != time.Minute || f.MaxSleep != 10*time.Minute || f.PingInterval != 30*time.Second {
+		t.Errorf("unexpected check intervals %v-%v, ping interval %v", f.MinSleep, f.MaxSleep, f.PingInterval)
+	}
+	if f.MinRebootDelay != 1500*time.Millisecond || f.MaxRebootDelay != 2*time.Minute || f.Pings != 2 {
+		t.Errorf("unexpected reboot delay %v-%v with %d pings", f.MinRebootDelay, f.MaxRebootDelay, f.Pings)
+	}
+	if f.FailureRates[EventTypeDownloadStarted] != 0.1 || f.FailureRates[EventTypeDownloadFinished] != 0 ||
+		f.FailureRates[EventTypeUpdateComplete] != 0.5 {
+		t.Errorf("unexpected failure rates %v", f.FailureRates)
+	}
+
+	if f := s.Populations[1].NewFake("http://localhost", "machine"); f.Version != "0.0.0" || f.OEM != "" {
+		t.Errorf("expected version 0.0.0 without oem, got %s and %q", f.Version, f.OEM)
+	}
+}
+
+func TestReadScenarioErrors(t *testing.T) {
+	tests := []string{
+		`populations: []`,
+		`populations: [{count: 0, appId: app, group: g, checkInterval: {max: 1}}]`,
+		`populations: [{count: 1, group: g, checkInterval: {max: 1}}]`,
+		`populations: [{count: 1, appId: app, group: g}]`,
+		`populations: [{count: 1, appId: app, group: g, checkInterval: {min: 2, max: 1}}]`,
+		`populations: [{count: 1, appId: app, group: g, checkInterval: {max: 1}, failures: {install: 2}}]`,
+		`populations: [{count: 1, appId: app, group: g, checkInterval: {max: 1}, oems: {gce: -1}}]`,
+		`populations: [{count: 1, appId: app, group: g, checkInterval: {max: soon}}]`,
+		`populations: [{count: 1, appId: app, group: g, checkInterval: {max: 1}, unknown: 1}]`,
+	}
+	for _, tt := range tests {
+		if _, err := ReadScenario(strings.NewReader(tt)); err == nil {
+			t.Errorf("expected an error for %s", tt)
+		}
+	}
+}
+
+func TestPopulationOEM(t *testing.T) {
+	p := &Population{OEMs: map[string]int{"gce": 3, "ami": 1, "never": 0}}
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		counts[p.oem()]++
+	}
+	if counts["never"] != 0 || counts["gce"] < counts["ami"] || counts["ami"] == 0 {
+		t.Errorf("unexpected distribution %v", counts)
+	}
+}
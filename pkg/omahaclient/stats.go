@@ -0,0 +1,178 @@
+package omahaclient
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxLatencies is the number of request latencies kept to compute
+// percentiles from.
+const maxLatencies = 10000
+
+// Stats collects statistics of clients. It is safe for concurrent use,
+// and a nil *Stats collects nothing.
+type Stats struct {
+	mu       sync.Mutex
+	started  time.Time
+	requests int64
+	errors   int64
+	// latencies is a uniform sample of the latencies of successful
+	// requests, of which there may be many more over a long run.
+	latencies  []time.Duration
+	maxLatency time.Duration
+	statuses   map[string]int64
+	succeeded  int64
+	failed     int64
+	versions   map[string]string
+}
+
+// NewStats returns empty statistics started now.
+func NewStats() *Stats {
+	return &Stats{
+		started:  time.Now(),
+		statuses: make(map[string]int64),
+		versions: make(map[string]string),
+	}
+}
+
+// request records a request which took d and failed with err, if not nil.
+func (s *Stats) request(d time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if err != nil {
+		s.errors++
+		return
+	}
+	if d > s.maxLatency {
+		s.maxLatency = d
+	}
+	if len(s.latencies) < maxLatencies {
+		s.latencies = append(s.latencies, d)
+	} else if i := rand.Int63n(s.requests - s.errors); i < maxLatencies {
+		s.latencies[i] = d
+	}
+}
+
+// status records the status of an update check.
+func (s *Stats) status(status string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[status]++
+}
+
+// update records the outcome of an update.
+func (s *Stats) update(failed bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if failed {
+		s.failed++
+	} else {
+		s.succeeded++
+	}
+}
+
+// version records the version a machine runs.
+func (s *Stats) version(machineID, version string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions[machineID] = version
+}
+
+// Reached returns the number of machines running version.
+func (s *Stats) Reached(version string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, v := range s.versions {
+		if v == version {
+			n++
+		}
+	}
+	return n
+}
+
+// Latency holds percentiles of request latencies in milliseconds.
+type Latency struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// Report is a summary of Stats.
+type Report struct {
+	Duration       float64          `json:"duration"`
+	Clients        int              `json:"clients"`
+	Requests       int64            `json:"requests"`
+	Errors         int64            `json:"errors"`
+	Latency        Latency          `json:"latency"`
+	UpdatesSuccess int64            `json:"updatesSucceeded"`
+	UpdatesFailed  int64            `json:"updatesFailed"`
+	Versions       map[string]int64 `json:"versions"`
+	Statuses       map[string]int64 `json:"statuses"`
+}
+
+// Report summarizes the statistics collected so far. Duration is in
+// seconds.
+func (s *Stats) Report() *Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := &Report{
+		Duration:       time.Since(s.started).Seconds(),
+		Clients:        len(s.versions),
+		Requests:       s.requests,
+		Errors:         s.errors,
+		UpdatesSuccess: s.succeeded,
+		UpdatesFailed:  s.failed,
+		Versions:       make(map[string]int64),
+		Statuses:       make(map[string]int64),
+	}
+	for _, v := range s.versions {
+		r.Versions[v]++
+	}
+	for status, n := range s.statuses {
+		r.Statuses[status] = n
+	}
+
+	latencies := append([]time.Duration{}, s.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.Latency = Latency{
+		P50: percentile(latencies, 50),
+		P90: percentile(latencies, 90),
+		P99: percentile(latencies, 99),
+		Max: float64(s.maxLatency) / float64(time.Millisecond),
+	}
+	return r
+}
+
+// percentile returns the nearest rank percentile p of sorted latencies in
+// milliseconds, or 0 if there are none.
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	} else if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return float64(sorted[rank]) / float64(time.Millisecond)
+}
@@ -0,0 +1,67 @@
+package omahaclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStatsReport(t *testing.T) {
+	s := NewStats()
+	for i := 1; i <= 100; i++ {
+		s.request(time.Duration(i)*time.Millisecond, nil)
+	}
+	s.request(time.Second, errors.New("connection refused"))
+	s.status("ok")
+	s.status("noupdate")
+	s.status("noupdate")
+	s.update(false)
+	s.update(true)
+	s.version("a", "1.0.0")
+	s.version("b", "1.0.0")
+	s.version("a", "2.0.0")
+
+	r := s.Report()
+	if r.Requests != 101 || r.Errors != 1 {
+		t.Errorf("expected 101 requests with 1 error, got %d with %d", r.Requests, r.Errors)
+	}
+	if r.Latency != (Latency{P50: 50, P90: 90, P99: 99, Max: 100}) {
+		t.Errorf("unexpected latency %+v", r.Latency)
+	}
+	if r.UpdatesSuccess != 1 || r.UpdatesFailed != 1 {
+		t.Errorf("expected 1 successful and 1 failed update, got %d and %d", r.UpdatesSuccess, r.UpdatesFailed)
+	}
+	if r.Clients != 2 || r.Versions["1.0.0"] != 1 || r.Versions["2.0.0"] != 1 {
+		t.Errorf("unexpected versions %v of %d clients", r.Versions, r.Clients)
+	}
+	if r.Statuses["ok"] != 1 || r.Statuses["noupdate"] != 2 {
+		t.Errorf("unexpected statuses %v", r.Statuses)
+	}
+	if s.Reached("2.0.0") != 1 {
+		t.Errorf("expected 1 client at 2.0.0, got %d", s.Reached("2.0.0"))
+	}
+
+	var empty *Stats
+	empty.request(time.Second, nil)
+	if r := NewStats().Report(); r.Latency != (Latency{}) {
+		t.Errorf("expected no latency, got %+v", r.Latency)
+	}
+}
+
+func TestStatsLatencySample(t *testing.T) {
+	s := NewStats()
+	for i := 1; i <= 4*maxLatencies; i++ {
+		s.request(time.Duration(i)*time.Microsecond, nil)
+	}
+	if len(s.latencies) != maxLatencies {
+		t.Errorf("expected %d latencies kept, got %d", maxLatencies, len(s.latencies))
+	}
+	r := s.Report()
+	if r.Latency.Max != 40 {
+		t.Errorf("expected the exact maximum of 40ms, got %g", r.Latency.Max)
+	}
+	// the sample covers the whole run, not only its start
+	if r.Latency.P50 < 15 || r.Latency.P50 > 25 {
+		t.Errorf("expected a median near 20ms, got %g", r.Latency.P50)
+	}
+}
@@ -0,0 +1,256 @@
+// Package packages creates, uploads and downloads update package payloads.
+package packages
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+// Hash reads r to the end and returns its size along with the base64
+// encoded SHA-1 and SHA-256 sums the update service expects.
+func Hash(r io.Reader) (size int64, sha1Sum, sha256Sum string, err error) {
+	sha1h := sha1.New()
+	sha256h := sha256.New()
+	size, err = io.Copy(io.MultiWriter(sha1h, sha256h), r)
+	if err != nil {
+		return 0, "", "", err
+	}
+	sha1Sum = base64.StdEncoding.EncodeToString(sha1h.Sum(nil))
+	sha256Sum = base64.StdEncoding.EncodeToString(sha256h.Sum(nil))
+	return size, sha1Sum, sha256Sum, nil
+}
+
+// FromFile returns a package with the size and sums of the payload file.
+func FromFile(file string) (*update.Package, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size, sha1Sum, sha256Sum, err := Hash(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s failed: %v", file, err)
+	}
+
+	return &update.Package{
+		Size:      strconv.FormatInt(size, 10),
+		Sha1Sum:   sha1Sum,
+		Sha256Sum: sha256Sum,
+	}, nil
+}
+
+// Upload sends a payload file to the package-upload endpoint of server.
+// The client must be authorized to upload packages.
+func Upload(ctx context.Context, client *http.Client, server, file string) error {
+	if file == "" {
+		return errors.New("missing file argument")
+	}
+
+	fpath, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pipeOut, pipeIn := io.Pipe()
+
+	writer := multipart.NewWriter(pipeIn)
+	errChan := make(chan error, 1)
+	go func() {
+		defer pipeIn.Close()
+		part, _ := writer.CreateFormFile("file", filepath.Base(fpath))
+		if _, err := io.Copy(part, f); err != nil {
+			errChan <- err
+			return
+		}
+		errChan <- writer.Close()
+	}()
+
+	req, err := http.NewRequest("POST", server+"/package-upload", pipeOut)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if resp == nil || resp.Body == nil {
+		return errors.New("server did not respond")
+	}
+	defer resp.Body.Close()
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+
+	return <-errChan
+}
+
+// Filename returns the name a downloaded package payload is saved as.
+func Filename(pkg *update.Package) string {
+	return fmt.Sprintf("%s_%s_%s", pkg.AppId, pkg.Version, path.Base(pkg.Url))
+}
+
+// Download fetches the payload of pkg into dir and verifies its size and
+// SHA-1 sum, and its SHA-256 sum if pkg has one. Downloaded bytes are also
+// written to progress, if set. The payload only appears under its name
+// once verified.
+func Download(ctx context.Context, client *http.Client, pkg *update.Package, dir string, progress io.Writer) (file string, err error) {
+	// Ensure we have a valid package URL
+	pkgUrl, err := url.Parse(pkg.Url)
+	if err != nil {
+		return "", err
+	}
+
+	// Currently only supports files hosted publicly on HTTP/HTTPS
+	if pkgUrl.Scheme != "http" && pkgUrl.Scheme != "https" {
+		return "", fmt.Errorf("Cannot download package with scheme %s", pkgUrl.Scheme)
+	}
+
+	req, err := http.NewRequest("GET", pkg.Url, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return "", fmt.Errorf("Download of %s failed: %s", pkg.Url, res.Status)
+	}
+
+	// Save the file by Application, Version, and Filename. The partial
+	// file is unique so that downloads of the same payload do not collide.
+	file = path.Join(dir, Filename(pkg))
+	out, err := ioutil.TempFile(dir, Filename(pkg)+".part")
+	if err != nil {
+		return "", err
+	}
+	partial := out.Name()
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(partial)
+		}
+	}()
+
+	if progress == nil {
+		progress = ioutil.Discard
+	}
+
+	// We will hash the file as we download it.
+	sha1h := sha1.New()
+	sha256h := sha256.New()
+	// Write to file, hashes, and progress bar.
+	n, err := io.Copy(io.MultiWriter(out, sha1h, sha256h, progress), res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	// Verify downloaded size matches the package's size.
+	pkgSize, err := strconv.ParseInt(pkg.Size, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid package size %q: %v", pkg.Size, err)
+	}
+	if n != pkgSize {
+		return "", fmt.Errorf("Download size does not match package size. %d != %d", n, pkgSize)
+	}
+
+	// Verify the hashes match the package manifest.
+	if err := verifySum("SHA1", pkg.Sha1Sum, sha1h.Sum(nil)); err != nil {
+		return "", err
+	}
+	if pkg.Sha256Sum != "" {
+		if err := verifySum("SHA256", pkg.Sha256Sum, sha256h.Sum(nil)); err != nil {
+			return "", err
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	// temporary files are only readable by their owner
+	if err := os.Chmod(partial, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(partial, file); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// verifySum compares a base64 encoded sum from a package manifest with
+// the sum of the downloaded bytes.
+func verifySum(name, want string, got []byte) error {
+	sum, err := base64.StdEncoding.DecodeString(want)
+	if err != nil {
+		return fmt.Errorf("invalid %s sum %q: %v", name, want, err)
+	}
+	if string(sum) != string(got) {
+		return fmt.Errorf("%s sums do not match: %x != %x", name, sum, got)
+	}
+	return nil
+}
+
+// WriteInfo writes the metadata of pkg to an info.json file in dir, next
+// to the payload saved by Download.
+func WriteInfo(pkg *update.Package, dir string) error {
+	filename := fmt.Sprintf("%s_%s_info.json", pkg.AppId, pkg.Version)
+	out, err := os.Create(path.Join(dir, filename))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	output, err := json.Marshal(pkg)
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(output)
+	return err
+}
+
+// ReadInfo loads package metadata written by WriteInfo.
+func ReadInfo(filename string) (*update.Package, error) {
+	jsonBody, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := new(update.Package)
+	if err := json.Unmarshal(jsonBody, pkg); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
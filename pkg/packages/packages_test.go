@@ -0,0 +1,70 @@
+package packages
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+func TestDownloadConcurrently(t *testing.T) {
+	payload := strings.Repeat("payload of 2.0.0\n", 1000)
+	size, sha1Sum, sha256Sum, _ := Hash(strings.NewReader(payload))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// interleave the writes of both downloads
+		for i := 0; i < len(payload); i += 1000 {
+			w.Write([]byte(payload[i : i+1000]))
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "packages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pkg := &update.Package{
+		AppId:     "app",
+		Version:   "2.0.0",
+		Url:       server.URL + "/update.gz",
+		Size:      strconv.FormatInt(size, 10),
+		Sha1Sum:   sha1Sum,
+		Sha256Sum: sha256Sum,
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = Download(context.Background(), http.DefaultClient, pkg, dir, nil)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("expected both downloads to succeed, got %v", err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 || filepath.Base(files[0]) != Filename(pkg) {
+		t.Errorf("expected only the payload to be left, got %v", files)
+	}
+	if data, _ := ioutil.ReadFile(files[0]); string(data) != payload {
+		t.Errorf("expected the payload in %s", files[0])
+	}
+}
@@ -0,0 +1,250 @@
+// Package rollout generates the frames of automated group rollouts.
+package rollout
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+// Linear returns a rollout which raises the update percentage of a group
+// in equal steps every frameSize seconds, reaching 100% after
+// totalDuration seconds.
+func Linear(appId, groupId string, frameSize, totalDuration int64) (*update.Rollout, error) {
+	if frameSize <= 0 || totalDuration <= 0 {
+		return nil, fmt.Errorf("frame size and duration must be positive, got %d and %d", frameSize, totalDuration)
+	}
+
+	var frames []*update.Frame
+	duration := totalDuration
+	stepSize := 100 / math.Ceil(float64(duration)/float64(frameSize))
+	percent := 0.0
+
+	for {
+		// we stop making frames when we are at the end of the rollout
+		if duration <= 0 {
+			break
+		}
+
+		// set our current frame and step size
+		size := frameSize
+		percent += stepSize
+		if size >= duration {
+			// if that's longer than the time we have left, this is the final
+			// frame. use the rest of the duration to set us to 100%
+			size = duration
+		}
+
+		// append our new frame to the end of the frames list
+		frames = append(frames, &update.Frame{
+			Duration: size,
+			Percent:  percent,
+		})
+
+		// subtract our frame from the total duration
+		duration -= size
+	}
+
+	// add one more frame to bring us up to 100%. the server will set this
+	// frame, and the next time it checks, it will exit the rollout, since the
+	// duration is set to 0.
+	frames = append(frames, &update.Frame{
+		Duration: 0,
+		Percent:  100,
+	})
+
+	return New(appId, groupId, frames)
+}
+
+// Exponential returns a rollout which starts at startPercent and doubles
+// the update percentage of a group every frameSize seconds until it
+// reaches 100%.
+func Exponential(appId, groupId string, startPercent float64, frameSize int64) (*update.Rollout, error) {
+	if startPercent <= 0 || startPercent > 100 {
+		return nil, fmt.Errorf("start percent must be greater than 0 and at most 100, got %g", startPercent)
+	}
+	if frameSize <= 0 {
+		return nil, fmt.Errorf("frame size must be positive, got %d", frameSize)
+	}
+
+	var frames []*update.Frame
+	for percent := startPercent; ; percent *= 2 {
+		if percent >= 100 {
+			percent = 100
+		}
+		frames = append(frames, &update.Frame{
+			Duration: frameSize,
+			Percent:  percent,
+		})
+		if percent == 100 {
+			break
+		}
+	}
+
+	// like linear rollouts, end with a frame which holds 100%.
+	frames = append(frames, &update.Frame{
+		Duration: 0,
+		Percent:  100,
+	})
+
+	return New(appId, groupId, frames)
+}
+
+// New returns a rollout with the given frames after checking them with
+// Validate.
+func New(appId, groupId string, frames []*update.Frame) (*update.Rollout, error) {
+	if err := Validate(frames); err != nil {
+		return nil, err
+	}
+	return &update.Rollout{
+		AppId:   appId,
+		GroupId: groupId,
+		Rollout: frames,
+	}, nil
+}
+
+// Validate checks that frames is not empty, that the percentages are
+// between 0 and 100 and never decrease, and that every frame but the last
+// has a positive duration. The last frame may have a duration of 0 to
+// hold its percentage once the rollout ends.
+func Validate(frames []*update.Frame) error {
+	if len(frames) == 0 {
+		return errors.New("rollout has no frames")
+	}
+	for i, frame := range frames {
+		if frame.Percent < 0 || frame.Percent > 100 {
+			return fmt.Errorf("frame %d: percent must be between 0 and 100, got %g", i, frame.Percent)
+		}
+		if i > 0 && frame.Percent < frames[i-1].Percent {
+			return fmt.Errorf("frame %d: percent %g is lower than the %g of the previous frame", i, frame.Percent, frames[i-1].Percent)
+		}
+		if frame.Duration < 0 || (frame.Duration == 0 && i != len(frames)-1) {
+			return fmt.Errorf("frame %d: duration must be positive, got %d", i, frame.Duration)
+		}
+	}
+	return nil
+}
+
+// ParseSteps parses a comma separated list of PERCENT:DURATION steps, such
+// as "1%:1h,10%:6h,50%:1d,100%". The percent sign is optional. Durations
+// are Go durations with an additional "d" unit for days and must be whole
+// seconds. The duration of the last step may be left out.
+func ParseSteps(s string) ([]*update.Frame, error) {
+	var frames []*update.Frame
+	steps := strings.Split(s, ",")
+	for i, step := range steps {
+		step = strings.TrimSpace(step)
+		parts := strings.SplitN(step, ":", 2)
+
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(parts[0], "%"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("step %q: invalid percent", step)
+		}
+
+		frame := &update.Frame{Percent: percent}
+		if len(parts) == 2 {
+			d, err := parseDuration(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("step %q: %v", step, err)
+			}
+			frame.Duration = d
+		} else if i != len(steps)-1 {
+			return nil, fmt.Errorf("step %q: missing duration", step)
+		}
+		frames = append(frames, frame)
+	}
+
+	if err := Validate(frames); err != nil {
+		return nil, err
+	}
+	return frames, nil
+}
+
+// parseDuration parses a duration into seconds.
+func parseDuration(s string) (int64, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseInt(days, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return n * 24 * 60 * 60, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	if d%time.Second != 0 {
+		return 0, fmt.Errorf("duration %q is not a whole number of seconds", s)
+	}
+	return int64(d / time.Second), nil
+}
+
+// ReadFrames reads a JSON list of frames, each with a percent and a
+// duration in seconds, and checks them with Validate:
+//
+//	[{"percent": 5, "duration": 3600}, {"percent": 100, "duration": 0}]
+func ReadFrames(r io.Reader) ([]*update.Frame, error) {
+	var list []struct {
+		Percent  *float64 `json:"percent"`
+		Duration *int64   `json:"duration"`
+	}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid frames: %v", err)
+	}
+
+	frames := make([]*update.Frame, len(list))
+	for i, f := range list {
+		if f.Percent == nil || f.Duration == nil {
+			return nil, fmt.Errorf("frame %d: percent and duration are required", i)
+		}
+		frames[i] = &update.Frame{
+			Percent:  *f.Percent,
+			Duration: *f.Duration,
+		}
+	}
+
+	if err := Validate(frames); err != nil {
+		return nil, err
+	}
+	return frames, nil
+}
+
+// ScheduledFrame is a frame of a rollout placed on the wall clock.
+type ScheduledFrame struct {
+	Frame    int       `json:"frame"`
+	Start    time.Time `json:"start"`
+	Percent  float64   `json:"percent"`
+	Duration int64     `json:"duration"`
+	// Instances is the estimated number of instances allowed to update
+	// once the frame starts.
+	Instances int64 `json:"instances"`
+}
+
+// Schedule returns when each frame of r would start if the rollout was
+// activated at start, and how many of the instances of the group it
+// would reach.
+func Schedule(r *update.Rollout, start time.Time, instances int64) []*ScheduledFrame {
+	schedule := make([]*ScheduledFrame, len(r.Rollout))
+	at := start
+	for i, frame := range r.Rollout {
+		schedule[i] = &ScheduledFrame{
+			Frame:     i,
+			Start:     at,
+			Percent:   frame.Percent,
+			Duration:  frame.Duration,
+			Instances: int64(math.Round(float64(instances) * frame.Percent / 100)),
+		}
+		at = at.Add(time.Duration(frame.Duration) * time.Second)
+	}
+	return schedule
+}
@@ -0,0 +1,421 @@
+package rollout
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/mockserver"
+	"google.golang.org/api/googleapi"
+)
+
+func eq(a, b *update.Rollout) bool {
+	if a.AppId != b.AppId || a.GroupId != b.GroupId {
+		return false
+	}
+	if len(a.Rollout) != len(b.Rollout) {
+		return false
+	}
+	for i, fa := range a.Rollout {
+		fb := b.Rollout[i]
+		if fa.Percent != fb.Percent {
+			return false
+		}
+		if fa.Duration != fb.Duration {
+			return false
+		}
+	}
+	return true
+}
+
+func displayRollout(out io.Writer, rollout *update.Rollout) {
+	for i, frame := range rollout.Rollout {
+		fmt.Fprintf(out, "Frame %d:\tPercent:\t%f\n", i, frame.Percent)
+		fmt.Fprintf(out, "\tDuration:\t%d\n", frame.Duration)
+	}
+}
+
+func TestLinearRolloutGeneration(t *testing.T) {
+	appId := "e96281a6-d1af-4bde-9a0a-97b76e56dc57"
+	groupId := "stable"
+	truth := &update.Rollout{
+		AppId:   appId,
+		GroupId: groupId,
+		Rollout: []*update.Frame{
+			&update.Frame{
+				Percent:  10.0,
+				Duration: 10,
+			},
+			&update.Frame{
+				Percent:  20.0,
+				Duration: 10,
+			},
+			&update.Frame{
+				Percent:  30.0,
+				Duration: 10,
+			},
+			&update.Frame{
+				Percent:  40.0,
+				Duration: 10,
+			},
+			&update.Frame{
+				Percent:  50.0,
+				Duration: 10,
+			},
+			&update.Frame{
+				Percent:  60.0,
+				Duration: 10,
+			},
+			&update.Frame{
+				Percent:  70.0,
+				Duration: 10,
+			},
+			&update.Frame{
+				Percent:  80.0,
+				Duration: 10,
+			},
+			&update.Frame{
+				Percent:  90.0,
+				Duration: 10,
+			},
+			&update.Frame{
+				Percent:  100.0,
+				Duration: 10,
+			},
+			// final frame to force 100%
+			&update.Frame{
+				Percent:  100.0,
+				Duration: 0,
+			},
+		},
+	}
+
+	rollout, err := Linear(appId, groupId, 10, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !eq(rollout, truth) {
+		// generate nice frame output
+		var e, g bytes.Buffer
+		displayRollout(&e, truth)
+		displayRollout(&g, rollout)
+		t.Error("incorrect rollout generated.\n")
+		t.Errorf("expected:\n%s\n", e.String())
+		t.Errorf("got:\n%s\n", g.String())
+	}
+}
+
+func frames(pd ...float64) []*update.Frame {
+	var frames []*update.Frame
+	for i := 0; i < len(pd); i += 2 {
+		frames = append(frames, &update.Frame{
+			Percent:  pd[i],
+			Duration: int64(pd[i+1]),
+		})
+	}
+	return frames
+}
+
+func checkRollout(t *testing.T, truth, rollout *update.Rollout) {
+	if !eq(rollout, truth) {
+		var e, g bytes.Buffer
+		displayRollout(&e, truth)
+		displayRollout(&g, rollout)
+		t.Error("incorrect rollout generated.\n")
+		t.Errorf("expected:\n%s\n", e.String())
+		t.Errorf("got:\n%s\n", g.String())
+	}
+}
+
+func TestExponentialRolloutGeneration(t *testing.T) {
+	truth := &update.Rollout{
+		AppId:   "app",
+		GroupId: "stable",
+		Rollout: frames(
+			1, 60,
+			2, 60,
+			4, 60,
+			8, 60,
+			16, 60,
+			32, 60,
+			64, 60,
+			100, 60,
+			100, 0,
+		),
+	}
+
+	rollout, err := Exponential("app", "stable", 1, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRollout(t, truth, rollout)
+
+	for _, start := range []float64{0, -1, 101} {
+		if _, err := Exponential("app", "stable", start, 60); err == nil {
+			t.Errorf("start percent %g: expected an error", start)
+		}
+	}
+	if _, err := Exponential("app", "stable", 1, 0); err == nil {
+		t.Error("frame size 0: expected an error")
+	}
+}
+
+func TestParseSteps(t *testing.T) {
+	truth := &update.Rollout{
+		Rollout: frames(
+			1, 3600,
+			10, 6*3600,
+			50, 86400,
+			100, 0,
+		),
+	}
+
+	steps, err := ParseSteps("1%:1h,10%:6h,50%:1d,100%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRollout(t, truth, &update.Rollout{Rollout: steps})
+
+	for _, s := range []string{
+		"",
+		"10%:1h,5%:1h",
+		"10%,100%",
+		"x%:1h",
+		"10%:1x",
+		"10%:1500ms",
+		"10%:0s,100%",
+		"150%:1h",
+	} {
+		if _, err := ParseSteps(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestReadFrames(t *testing.T) {
+	truth := &update.Rollout{
+		Rollout: frames(
+			5, 3600,
+			25, 21600,
+			100, 0,
+		),
+	}
+
+	got, err := ReadFrames(strings.NewReader(`[
+		{"percent": 5, "duration": 3600},
+		{"percent": 25, "duration": 21600},
+		{"percent": 100, "duration": 0}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRollout(t, truth, &update.Rollout{Rollout: got})
+
+	for _, s := range []string{
+		`[]`,
+		`{}`,
+		`[{"percent": 5}]`,
+		`[{"percent": 5, "duration": 60, "extra": 1}]`,
+		`[{"percent": 50, "duration": 60}, {"percent": 25, "duration": 60}]`,
+		`[{"percent": 50, "duration": -1}, {"percent": 100, "duration": 0}]`,
+	} {
+		if _, err := ReadFrames(strings.NewReader(s)); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestValidateLinear(t *testing.T) {
+	r, err := Linear("app", "stable", 60, 86400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(r.Rollout); err != nil {
+		t.Error(err)
+	}
+
+	for _, c := range [][2]int64{{0, 600}, {-60, 600}, {60, 0}, {60, -600}} {
+		if _, err := Linear("app", "stable", c[0], c[1]); err == nil {
+			t.Errorf("expected frame size %d and duration %d to be refused", c[0], c[1])
+		}
+	}
+}
+
+func TestTally(t *testing.T) {
+	values := func(counts ...int64) []*update.GroupRequestsValues {
+		var v []*update.GroupRequestsValues
+		for _, c := range counts {
+			v = append(v, &update.GroupRequestsValues{Count: c})
+		}
+		return v
+	}
+	rollup := &update.GroupRequestsRollup{
+		Items: []*update.GroupRequestsItem{
+			{Version: "2.0.0", Type: "3", Result: "2", Values: values(10, 5)},
+			{Version: "2.0.0", Type: "3", Result: "1", Values: values(3)},
+			{Version: "2.0.0", Type: "3", Result: "0", Values: values(1, 1)},
+			// download events and other versions are ignored
+			{Version: "2.0.0", Type: "13", Result: "0", Values: values(7)},
+			{Version: "1.0.0", Type: "3", Result: "0", Values: values(9)},
+		},
+	}
+
+	h := Tally(rollup, "2.0.0")
+	if h.Successes != 18 || h.Failures != 2 {
+		t.Errorf("expected 18 successes and 2 failures, got %d and %d", h.Successes, h.Failures)
+	}
+	if rate := h.FailureRate(); rate != 10 {
+		t.Errorf("expected a failure rate of 10%%, got %g%%", rate)
+	}
+
+	if rate := Tally(rollup, "3.0.0").FailureRate(); rate != 0 {
+		t.Errorf("expected a failure rate of 0%% without updates, got %g%%", rate)
+	}
+}
+
+func TestSupervisorRetries(t *testing.T) {
+	s := mockserver.New()
+	var failures int32 = 2
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/groups/prod") && atomic.AddInt32(&failures, -1) >= 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	service := s.Service(ts.URL)
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "1.0.0"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable"}).Do()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sup := &Supervisor{
+		Service:  service,
+		AppId:    "app",
+		GroupId:  "prod",
+		Interval: 10 * time.Millisecond,
+		Action:   ActionPause,
+	}
+	if _, err := sup.Run(ctx); err != ErrRolloutInactive {
+		t.Errorf("expected the failed checks to be retried until the rollout is found inactive, got %v", err)
+	}
+	if atomic.LoadInt32(&failures) >= 0 {
+		t.Errorf("expected the group to be checked again after the failures")
+	}
+
+	// a missing group is not retried
+	sup.GroupId = "missing"
+	_, err := sup.Run(ctx)
+	if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != http.StatusNotFound {
+		t.Errorf("expected the missing group to end the supervision, got %v", err)
+	}
+	if !permanent(fmt.Errorf("checking rollout: %w", err)) {
+		t.Errorf("expected a wrapped missing group to be permanent")
+	}
+
+	sup.GroupId, sup.Interval = "prod", 0
+	if _, err := sup.Run(ctx); err == nil || err == ErrRolloutInactive {
+		t.Errorf("expected a zero interval to be refused, got %v", err)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := &update.Rollout{Rollout: frames(
+		1, 3600,
+		10, 6*3600,
+		100, 0,
+	)}
+
+	schedule := Schedule(r, start, 250)
+	if len(schedule) != 3 {
+		t.Fatalf("expected 3 frames, got %d", len(schedule))
+	}
+	for i, expected := range []struct {
+		start     time.Time
+		instances int64
+	}{
+		{start, 3},
+		{start.Add(time.Hour), 25},
+		{start.Add(7 * time.Hour), 250},
+	} {
+		if !schedule[i].Start.Equal(expected.start) {
+			t.Errorf("frame %d: expected start %s, got %s", i, expected.start, schedule[i].Start)
+		}
+		if schedule[i].Instances != expected.instances {
+			t.Errorf("frame %d: expected %d instances, got %d", i, expected.instances, schedule[i].Instances)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, err := Linear("app", "stable", 10, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		percent   float64
+		frame     int
+		elapsed   int64
+		remaining int64
+		complete  bool
+	}{
+		{0, -1, 0, 100, false},
+		{10, 0, 0, 100, false},
+		{35, 2, 20, 80, false},
+		{100, 10, 100, 0, true},
+	} {
+		s := Status{Active: true}
+		s.Progress(r, tt.percent, now)
+		if s.Frame != tt.frame || s.Elapsed != tt.elapsed || s.Remaining != tt.remaining || s.Complete != tt.complete {
+			t.Errorf("%g%%: expected frame %d, elapsed %d, remaining %d, complete %v, got %d, %d, %d, %v",
+				tt.percent, tt.frame, tt.elapsed, tt.remaining, tt.complete,
+				s.Frame, s.Elapsed, s.Remaining, s.Complete)
+		}
+		if eta := now.Add(time.Duration(tt.remaining) * time.Second); !s.ETA.Equal(eta) {
+			t.Errorf("%g%%: expected ETA %s, got %s", tt.percent, eta, s.ETA)
+		}
+	}
+
+	// a last frame with a duration lasts until the rollout ends
+	held := &update.Rollout{Rollout: frames(50, 600, 100, 3600)}
+	s := Status{Active: true}
+	s.Progress(held, 100, now)
+	if s.Complete || s.Remaining != 3600 {
+		t.Errorf("expected the last frame to be running, got complete %v, remaining %d", s.Complete, s.Remaining)
+	}
+	s.Active = false
+	s.Progress(held, 100, now)
+	if !s.Complete || s.Remaining != 0 {
+		t.Errorf("expected the ended rollout to be complete, got complete %v, remaining %d", s.Complete, s.Remaining)
+	}
+}
+
+func TestVersionShare(t *testing.T) {
+	versions := []*update.AppVersionItem{
+		{GroupId: "prod", Version: "1.0.0", Count: 60},
+		{GroupId: "prod", Version: "2.0.0", Count: 40},
+		// other groups of the app are left out
+		{GroupId: "beta", Version: "2.0.0", Count: 25},
+	}
+
+	updated, total := VersionShare(versions, "prod", "2.0.0")
+	if updated != 40 || total != 100 {
+		t.Errorf("expected 40 of 100 instances, got %d of %d", updated, total)
+	}
+}
@@ -0,0 +1,153 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+// Status is the progress of the rollout of a group.
+//
+// The update service does not record when a rollout was activated, so the
+// frame in effect is derived from the update percentage of the group.
+// Elapsed is the time the frames before it take and Remaining the time
+// the frame in effect and the ones after it take at most.
+type Status struct {
+	AppId         string  `json:"appId"`
+	GroupId       string  `json:"groupId"`
+	Active        bool    `json:"active"`
+	UpdatePercent float64 `json:"updatePercent"`
+
+	// Frame is the index of the frame in effect, or -1 if the update
+	// percentage is below the first frame.
+	Frame     int       `json:"frame"`
+	Frames    int       `json:"frames"`
+	Elapsed   int64     `json:"elapsed"`
+	Remaining int64     `json:"remaining"`
+	ETA       time.Time `json:"eta"`
+	Complete  bool      `json:"complete"`
+
+	// Version is the version being rolled out, Updated the number of
+	// the Instances seen recently which run it.
+	Version   string `json:"version"`
+	Instances int64  `json:"instances"`
+	Updated   int64  `json:"updated"`
+}
+
+// UpdatedPercent returns the percentage of instances running the target
+// version.
+func (s *Status) UpdatedPercent() float64 {
+	if s.Instances == 0 {
+		return 0
+	}
+	return float64(s.Updated) / float64(s.Instances) * 100
+}
+
+// Progress fills in the frame in effect at percent and the time elapsed
+// and remaining, estimated from the frame durations. The rollout is
+// complete once the last frame is reached and its duration has passed,
+// which the update service marks by ending the rollout, so Active must be
+// set first.
+func (s *Status) Progress(r *update.Rollout, percent float64, now time.Time) {
+	s.UpdatePercent = percent
+	s.Frames = len(r.Rollout)
+	s.Frame = -1
+	for i, frame := range r.Rollout {
+		if frame.Percent <= percent {
+			s.Frame = i
+		}
+	}
+
+	s.Elapsed, s.Remaining = 0, 0
+	for i, frame := range r.Rollout {
+		if i < s.Frame {
+			s.Elapsed += frame.Duration
+		} else {
+			s.Remaining += frame.Duration
+		}
+	}
+	last := s.Frames > 0 && s.Frame == s.Frames-1
+	s.Complete = last && (r.Rollout[s.Frame].Duration == 0 || !s.Active)
+	if s.Complete {
+		s.Remaining = 0
+	}
+	s.ETA = now.Add(time.Duration(s.Remaining) * time.Second)
+}
+
+// VersionShare returns how many instances of group ran version and how
+// many instances there were in an app versions list, which counts every
+// instance once with the version it last reported.
+func VersionShare(versions []*update.AppVersionItem, groupId, version string) (updated, total int64) {
+	for _, item := range versions {
+		if item.GroupId != groupId {
+			continue
+		}
+		total += item.Count
+		if item.Version == version {
+			updated += item.Count
+		}
+	}
+	return updated, total
+}
+
+// GetStatus fetches the rollout, the group and the versions run by the
+// instances seen during the last hour. If version is empty, the version of
+// the channel the group follows is used.
+func GetStatus(ctx context.Context, service *update.Service, appId, groupId, version string) (*Status, error) {
+	r, err := service.Group.Rollout.Get(appId, groupId).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	active, err := service.Group.Rollout.Active.Get(appId, groupId).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	group, err := service.Group.Get(appId, groupId).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if version == "" {
+		version, err = channelVersion(ctx, service, appId, group.ChannelId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().Truncate(time.Second)
+	versions, err := service.Appversion.List().
+		AppId(appId).
+		GroupId(groupId).
+		DateStart(now.Add(-time.Hour).Unix()).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Status{
+		AppId:   appId,
+		GroupId: groupId,
+		Active:  active.Active,
+		Version: version,
+	}
+	s.Progress(r, group.UpdatePercent, now)
+	s.Updated, s.Instances = VersionShare(versions.Items, groupId, version)
+	return s, nil
+}
+
+// channelVersion returns the version of the channel with label.
+func channelVersion(ctx context.Context, service *update.Service, appId, label string) (string, error) {
+	list, err := service.Channel.List(appId).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	for _, channel := range list.Items {
+		if channel.Label == label {
+			return channel.Version, nil
+		}
+	}
+	return "", fmt.Errorf("channel %s not found", label)
+}
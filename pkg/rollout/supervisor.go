@@ -0,0 +1,255 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/omahaclient"
+	"google.golang.org/api/googleapi"
+)
+
+// Actions a Supervisor can take when a rollout is unhealthy.
+const (
+	ActionPause      = "pause"
+	ActionDeactivate = "deactivate"
+	ActionBoth       = "both"
+)
+
+// ErrRolloutInactive is returned by Supervisor.Run when the rollout of the
+// group is not, or no longer, active.
+var ErrRolloutInactive = errors.New("rollout is not active")
+
+// Health counts the update complete events reported for a version.
+type Health struct {
+	Version   string `json:"version"`
+	Successes int64  `json:"successes"`
+	Failures  int64  `json:"failures"`
+}
+
+// Total returns the number of completed and failed updates.
+func (h *Health) Total() int64 {
+	return h.Successes + h.Failures
+}
+
+// FailureRate returns the percentage of updates which failed, or 0 if no
+// update has been reported yet.
+func (h *Health) FailureRate() float64 {
+	if h.Total() == 0 {
+		return 0
+	}
+	return float64(h.Failures) / float64(h.Total()) * 100
+}
+
+// Tally counts the update complete events of version in an events rollup.
+func Tally(rollup *update.GroupRequestsRollup, version string) *Health {
+	h := &Health{Version: version}
+	for _, item := range rollup.Items {
+		if item.Version != version || item.Type != omahaclient.EventTypeUpdateComplete {
+			continue
+		}
+		var count int64
+		for _, v := range item.Values {
+			count += v.Count
+		}
+		switch item.Result {
+		case omahaclient.EventResultError:
+			h.Failures += count
+		case omahaclient.EventResultSuccess, omahaclient.EventResultSuccessReboot:
+			h.Successes += count
+		}
+	}
+	return h
+}
+
+// Supervisor watches the health of an active rollout and stops it when too
+// many clients fail to update to the new version.
+type Supervisor struct {
+	Service *update.Service
+	AppId   string
+	GroupId string
+
+	// Version is the version being rolled out. If empty, the version of
+	// the channel the group follows is used.
+	Version string
+
+	// Threshold is the failure rate, in percent, above which the rollout
+	// is stopped.
+	Threshold float64
+	// MinUpdates is the number of updates which must be reported before
+	// the failure rate is acted upon.
+	MinUpdates int64
+	// Window is how far back events are taken into account.
+	Window time.Duration
+	// Interval is the time between checks.
+	Interval time.Duration
+	// Action is one of ActionPause, ActionDeactivate or ActionBoth.
+	Action string
+	// DryRun logs the decisions without acting on them.
+	DryRun bool
+
+	// Logf receives every decision.
+	Logf func(format string, v ...interface{})
+}
+
+// Run checks the rollout every Interval until ctx is done, the rollout is
+// no longer active or the Threshold is exceeded. It returns the health
+// which caused the rollout to be stopped, ErrRolloutInactive when the
+// rollout ended by itself, or the error of a check which cannot succeed
+// later, such as a group which does not exist. Other failed checks are
+// logged and retried on the next tick.
+func (s *Supervisor) Run(ctx context.Context) (*Health, error) {
+	switch s.Action {
+	case ActionPause, ActionDeactivate, ActionBoth:
+	default:
+		return nil, fmt.Errorf("unknown action %q", s.Action)
+	}
+	if s.Interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive, got %v", s.Interval)
+	}
+
+	tick := time.NewTicker(s.Interval)
+	defer tick.Stop()
+
+	for {
+		health, err := s.Check(ctx)
+		if err != nil {
+			if err == ErrRolloutInactive || permanent(err) || ctx.Err() != nil {
+				return nil, err
+			}
+			// the next check may well succeed, a single failed request
+			// must not leave the rollout unsupervised
+			s.logf("checking rollout: %v, retrying in %v", err, s.Interval)
+		} else if stop, err := s.judge(ctx, health); stop {
+			return health, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
+
+// judge logs the health of the rollout and stops it if the Threshold is
+// exceeded. It reports whether the supervision is over.
+func (s *Supervisor) judge(ctx context.Context, health *Health) (bool, error) {
+	rate := health.FailureRate()
+	s.logf("version %s: %d updated, %d failed (%.1f%% failure rate, threshold %.1f%%)",
+		health.Version, health.Successes, health.Failures, rate, s.Threshold)
+
+	switch {
+	case health.Total() < s.MinUpdates:
+		s.logf("waiting for %d updates before judging the rollout", s.MinUpdates)
+	case rate > s.Threshold:
+		s.logf("failure rate %.1f%% exceeds threshold, stopping rollout (%s)", rate, s.Action)
+		s.logFailures(ctx, health.Version)
+		if s.DryRun {
+			s.logf("dry run, leaving group %s unchanged", s.GroupId)
+			return true, nil
+		}
+		return true, s.stop(ctx)
+	default:
+		s.logf("rollout healthy, continuing")
+	}
+	return false, nil
+}
+
+// permanent reports whether checking again cannot fix err, because the
+// group is gone or the credentials are refused.
+func permanent(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusUnauthorized)
+}
+
+// Check returns the health of the version being rolled out. It returns
+// ErrRolloutInactive if the group has no active rollout or its updates
+// are paused.
+func (s *Supervisor) Check(ctx context.Context) (*Health, error) {
+	group, err := s.Service.Group.Get(s.AppId, s.GroupId).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	if !group.RolloutActive || group.UpdatesPaused {
+		return nil, ErrRolloutInactive
+	}
+
+	version := s.Version
+	if version == "" {
+		version, err = channelVersion(ctx, s.Service, s.AppId, group.ChannelId)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %v", s.GroupId, err)
+		}
+	}
+
+	end := time.Now()
+	start := end.Add(-s.Window)
+	rollup, err := s.Service.Group.Requests.Events.Rollup(s.AppId, s.GroupId, start.Unix(), end.Unix()).
+		Versions(version).
+		Resolution(60).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+	return Tally(rollup, version), nil
+}
+
+// logFailures logs the clients which most recently failed to update, so
+// the decision can be traced back to them.
+func (s *Supervisor) logFailures(ctx context.Context, version string) {
+	list, err := s.Service.Clientupdate.List().
+		AppId(s.AppId).
+		GroupId(s.GroupId).
+		Version(version).
+		EventType(omahaclient.EventTypeUpdateComplete).
+		EventResult(omahaclient.EventResultError).
+		DateStart(time.Now().Add(-s.Window).Unix()).
+		Limit(10).
+		Context(ctx).
+		Do()
+	if err != nil {
+		s.logf("listing failed clients: %v", err)
+		return
+	}
+	for _, cl := range list.Items {
+		s.logf("failed client %s (oem %s, error code %s, last seen %s)",
+			cl.ClientId, cl.Oem, cl.ErrorCode, cl.LastSeen)
+	}
+}
+
+func (s *Supervisor) stop(ctx context.Context) error {
+	if s.Action == ActionPause || s.Action == ActionBoth {
+		group, err := s.Service.Group.Get(s.AppId, s.GroupId).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+		group.UpdatesPaused = true
+		if _, err := s.Service.Group.Patch(s.AppId, s.GroupId, group).Context(ctx).Do(); err != nil {
+			return err
+		}
+		s.logf("paused updates of group %s", s.GroupId)
+	}
+
+	if s.Action == ActionDeactivate || s.Action == ActionBoth {
+		active := &update.RolloutActive{
+			Active:          false,
+			ForceSendFields: []string{"Active"},
+		}
+		if _, err := s.Service.Group.Rollout.Active.Set(s.AppId, s.GroupId, active).Context(ctx).Do(); err != nil {
+			return err
+		}
+		s.logf("deactivated rollout of group %s", s.GroupId)
+	}
+	return nil
+}
+
+func (s *Supervisor) logf(format string, v ...interface{}) {
+	if s.Logf != nil {
+		s.Logf(format, v...)
+	}
+}
@@ -0,0 +1,361 @@
+// Package watcher polls an update service for new versions of an app and
+// hands them to a hook.
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-omaha/omaha"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/omahaclient"
+	"github.com/coreos/updateservicectl/pkg/packages"
+)
+
+// Update is a version offered by the update service.
+type Update struct {
+	AppID      string
+	GroupID    string
+	Version    string
+	OldVersion string
+	// URL of the update payload. Empty if the update check did not
+	// offer a payload.
+	URL string
+	// File is the verified payload, if the watcher downloads them. The
+	// first check hands the hook the version already running, without
+	// downloading the payload offered for another version.
+	File string
+
+	UpdateCheck *omaha.UpdateCheck
+}
+
+// ErrorCodeDownload is the Omaha error code reported for payloads which
+// fail to download or verify.
+const ErrorCodeDownload = "2000"
+
+// HookError is returned by a hook whose update failed, with the code
+// reported to the update service, such as the exit code of a command.
+type HookError struct {
+	Code int
+	Err  error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("update hook failed with code %d (%v)", e.Code, e.Err)
+}
+
+// Watcher checks for updates every Interval.
+type Watcher struct {
+	// Client is used for update checks. Its Version is advanced as new
+	// versions are found.
+	Client   *omahaclient.Client
+	AppID    string
+	Interval time.Duration
+
+	// Timeout bounds every update check. If 0, checks are only bounded
+	// by the HTTP client.
+	Timeout time.Duration
+
+	// MaxBackoff is the longest wait between failed update checks,
+	// which starts at Interval and doubles with every failure. If 0,
+	// failed checks are retried every Interval.
+	MaxBackoff time.Duration
+
+	// DownloadDir, if set, is where the payload of each new version is
+	// saved and verified before the hook is called.
+	DownloadDir string
+	// HTTPClient is used to download payloads. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Hook is called for each new version. A *HookError returned by
+	// Hook fails the update, which is retried after the next update
+	// check; other errors stop the watcher.
+	Hook func(ctx context.Context, u *Update) error
+
+	// ReportEvents sends the update service the progress of updates:
+	// download started and finished, then the outcome of the hook.
+	// Otherwise every update check reports a failed download, as older
+	// versions of watch did.
+	ReportEvents bool
+
+	// Logf, if set, receives warnings about failed update checks.
+	Logf func(format string, v ...interface{})
+}
+
+// Run polls until ctx is done or the hook fails. Failed update checks,
+// including the first one, update checks without a usable payload and
+// failed updates are logged and retried with backoff.
+func (w *Watcher) Run(ctx context.Context) error {
+	version := w.Client.Version
+
+	var delay time.Duration
+	failures := 0
+	initial := true
+	for {
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+
+		updateCheck, err := w.check(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failures++
+			delay = w.backoff(failures)
+			w.logf("warning: update check failed (%v), retrying in %v\n", err, delay)
+			continue
+		}
+
+		var u *Update
+		newVersion := version
+		switch {
+		case updateCheck.Status == "noupdate", updateCheck.Status == "error-version":
+		case initial:
+			// the first check hands the hook the version already running
+			u, err = w.newUpdate(version, "", updateCheck)
+		case updateCheck.Manifest == nil:
+			w.logf("warning: update check returned status %s\n", updateCheck.Status)
+		default:
+			if newVersion = updateCheck.Manifest.Version; newVersion != version {
+				u, err = w.newUpdate(newVersion, version, updateCheck)
+			}
+		}
+		if err != nil {
+			failures++
+			delay = w.backoff(failures)
+			w.logf("warning: %v, retrying in %v\n", err, delay)
+			continue
+		}
+
+		if u != nil {
+			if err := w.apply(ctx, u); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if _, ok := err.(*retryError); !ok {
+					return err
+				}
+				// a successful check does not reset the backoff, or an
+				// update which keeps failing would be retried every
+				// Interval
+				failures++
+				delay = w.backoff(failures)
+				w.logf("warning: %v, retrying in %v\n", err, delay)
+				continue
+			}
+		}
+		failures = 0
+		delay = w.Interval
+
+		if !initial {
+			version = newVersion
+			w.Client.Version = version
+		}
+		initial = false
+	}
+}
+
+// retryError is a failure to apply an update which is retried after the
+// next update check.
+type retryError struct {
+	err error
+}
+
+func (e *retryError) Error() string {
+	return e.err.Error()
+}
+
+// apply downloads the payload of u and runs the hook, reporting the
+// progress of updates to the update service if ReportEvents is set.
+func (w *Watcher) apply(ctx context.Context, u *Update) error {
+	// the version already running on the first check is not an update
+	report := w.ReportEvents && u.OldVersion != ""
+
+	if report {
+		w.report(ctx, &omahaclient.Event{
+			Type:   omahaclient.EventTypeDownloadStarted,
+			Result: omahaclient.EventResultSuccess,
+		})
+	}
+	if err := w.download(ctx, u); err != nil {
+		if report && ctx.Err() == nil {
+			w.report(ctx, &omahaclient.Event{
+				Type:      omahaclient.EventTypeUpdateComplete,
+				Result:    omahaclient.EventResultError,
+				ErrorCode: ErrorCodeDownload,
+			})
+		}
+		return &retryError{fmt.Errorf("download of %s failed (%v)", u.URL, err)}
+	}
+	if report {
+		w.report(ctx, &omahaclient.Event{
+			Type:   omahaclient.EventTypeDownloadFinished,
+			Result: omahaclient.EventResultSuccess,
+		})
+	}
+
+	err := w.Hook(ctx, u)
+	// the outcome of the hook is reported even if ctx ended while it ran
+	outcome := context.Background()
+	if hookErr, ok := err.(*HookError); ok {
+		if report {
+			w.report(outcome, &omahaclient.Event{
+				Type:      omahaclient.EventTypeUpdateComplete,
+				Result:    omahaclient.EventResultError,
+				ErrorCode: strconv.Itoa(hookErr.Code),
+			})
+		}
+		return &retryError{hookErr}
+	} else if err != nil {
+		return err
+	}
+
+	if report {
+		w.report(outcome, &omahaclient.Event{
+			Type:   omahaclient.EventTypeUpdateComplete,
+			Result: omahaclient.EventResultSuccess,
+		})
+		// like a machine after its reboot, the update is complete once
+		// reported from the new version
+		w.Client.Version = u.Version
+		w.report(outcome, &omahaclient.Event{
+			Type:   omahaclient.EventTypeUpdateComplete,
+			Result: omahaclient.EventResultSuccessReboot,
+		})
+	}
+	return nil
+}
+
+// report sends an event, logging failures: the update goes on without it.
+func (w *Watcher) report(ctx context.Context, event *omahaclient.Event) {
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+	if err := w.Client.SendEvent(ctx, event); err != nil {
+		w.logf("warning: reporting event %s failed (%v)\n", omahaclient.EventTypeName(event.Type), err)
+	}
+}
+
+// download saves and verifies the payload of u in DownloadDir.
+func (w *Watcher) download(ctx context.Context, u *Update) error {
+	if w.DownloadDir == "" || u.URL == "" || u.OldVersion == "" {
+		return nil
+	}
+	manifest := u.UpdateCheck.Manifest
+	pkg := &update.Package{
+		AppId:   u.AppID,
+		Version: manifest.Version,
+		Url:     u.URL,
+		Size:    manifest.Packages.Packages[0].Size,
+		Sha1Sum: manifest.Packages.Packages[0].Hash,
+	}
+	for _, action := range manifest.Actions.Actions {
+		if action.Event == "postinstall" && action.Sha256 != "" {
+			pkg.Sha256Sum = action.Sha256
+		}
+	}
+
+	client := w.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	file, err := packages.Download(ctx, client, pkg, w.DownloadDir, nil)
+	if err != nil {
+		return err
+	}
+	u.File = file
+	return nil
+}
+
+func (w *Watcher) check(ctx context.Context) (*omaha.UpdateCheck, error) {
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+	if w.ReportEvents {
+		return w.Client.UpdateCheck(ctx)
+	}
+	return w.Client.UpdateCheck(ctx, &omahaclient.Event{
+		Type:   omahaclient.EventTypeDownloadComplete,
+		Result: omahaclient.EventResultError,
+	})
+}
+
+// backoff returns the wait after the given number of consecutive
+// failures: Interval doubled for each failure but the first, capped at
+// MaxBackoff, of which a random half is taken off so that many watchers
+// failing together do not retry together.
+func (w *Watcher) backoff(failures int) time.Duration {
+	d := w.Interval
+	if w.MaxBackoff <= 0 {
+		return d
+	}
+	for i := 1; i < failures && d < w.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > w.MaxBackoff {
+		d = w.MaxBackoff
+	}
+	if d < 2 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Watcher) logf(format string, v ...interface{}) {
+	if w.Logf != nil {
+		w.Logf(format, v...)
+	}
+}
+
+func (w *Watcher) newUpdate(version, oldVersion string, updateCheck *omaha.UpdateCheck) (*Update, error) {
+	u := &Update{
+		AppID:       w.AppID,
+		GroupID:     w.Client.Track,
+		Version:     version,
+		OldVersion:  oldVersion,
+		UpdateCheck: updateCheck,
+	}
+
+	if updateCheck.Status == "ok" {
+		if updateCheck.Urls == nil || len(updateCheck.Urls.Urls) == 0 ||
+			updateCheck.Manifest == nil || len(updateCheck.Manifest.Packages.Packages) == 0 {
+			return nil, fmt.Errorf("update check for %s has no payload", version)
+		}
+
+		url, err := url.Parse(updateCheck.Urls.Urls[0].CodeBase)
+		if err != nil {
+			return nil, err
+		}
+
+		url.Path = path.Join(url.Path, updateCheck.Manifest.Packages.Packages[0].Name)
+		u.URL = url.String()
+	}
+	return u, nil
+}
@@ -0,0 +1,316 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/mockserver"
+	"github.com/coreos/updateservicectl/pkg/omahaclient"
+	"github.com/coreos/updateservicectl/pkg/packages"
+)
+
+func TestBackoff(t *testing.T) {
+	w := &Watcher{Interval: time.Second, MaxBackoff: 10 * time.Second}
+	for _, c := range []struct {
+		failures int
+		max      time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	} {
+		for i := 0; i < 100; i++ {
+			if d := w.backoff(c.failures); d < c.max/2 || d >= c.max {
+				t.Fatalf("after %d failures: expected a wait in [%v, %v), got %v", c.failures, c.max/2, c.max, d)
+			}
+		}
+	}
+
+	w.MaxBackoff = 0
+	if d := w.backoff(5); d != time.Second {
+		t.Errorf("expected the interval without backoff, got %v", d)
+	}
+}
+
+func TestRunRetries(t *testing.T) {
+	s := mockserver.New()
+	var failures int32 = 3
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/update") && atomic.AddInt32(&failures, -1) >= 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	service := s.Service(ts.URL)
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "2.0.0"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable"}).Do()
+	service.App.Package.Insert("app", "2.0.0", &update.Package{Url: "http://example.com/update.gz"}).Do()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var updates []*Update
+	w := &Watcher{
+		Client:     &omahaclient.Client{Server: ts.URL, AppID: "{app}", Version: "1.0.0", Track: "prod", MachineID: "m"},
+		AppID:      "app",
+		Interval:   10 * time.Millisecond,
+		Timeout:    time.Second,
+		MaxBackoff: 40 * time.Millisecond,
+		Hook: func(ctx context.Context, u *Update) error {
+			updates = append(updates, u)
+			if u.Version == "2.0.0" {
+				cancel()
+			}
+			return nil
+		},
+	}
+	if err := w.Run(ctx); err != context.Canceled {
+		t.Fatalf("expected the watcher to run until cancelled, got %v", err)
+	}
+	if atomic.LoadInt32(&failures) >= 0 {
+		t.Errorf("expected the failed checks to be retried")
+	}
+	if len(updates) != 2 || updates[1].OldVersion != "1.0.0" || updates[1].GroupID != "prod" ||
+		updates[1].URL != "http://example.com/update.gz" {
+		t.Errorf("unexpected updates %+v", updates)
+	}
+}
+
+func TestRunDownload(t *testing.T) {
+	payload := "payload of 2.0.0"
+	size, sha1Sum, sha256Sum, _ := packages.Hash(strings.NewReader(payload))
+
+	s := mockserver.New()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	var fetches int32
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		io.WriteString(w, payload)
+	}))
+	defer files.Close()
+
+	service := s.Service(ts.URL)
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "2.0.0"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable"}).Do()
+	service.App.Package.Insert("app", "2.0.0", &update.Package{
+		Url:       files.URL + "/update.gz",
+		Size:      strconv.FormatInt(size, 10),
+		Sha1Sum:   sha1Sum,
+		Sha256Sum: sha256Sum,
+	}).Do()
+
+	run := func() (*Update, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		dir, err := ioutil.TempDir("", "watcher")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		var got *Update
+		w := &Watcher{
+			Client:      &omahaclient.Client{Server: ts.URL, AppID: "app", Version: "1.0.0", Track: "prod", MachineID: "m"},
+			AppID:       "app",
+			Interval:    10 * time.Millisecond,
+			MaxBackoff:  160 * time.Millisecond,
+			DownloadDir: dir,
+			Hook: func(ctx context.Context, u *Update) error {
+				if u.OldVersion == "" {
+					// the payload offered is not that of the version
+					// already running
+					if u.File != "" {
+						t.Errorf("expected no download on the first check, got %s", u.File)
+					}
+					return nil
+				}
+				data, err := ioutil.ReadFile(u.File)
+				if err != nil || string(data) != payload {
+					t.Errorf("expected the payload in %s, got %q, %v", u.File, data, err)
+				}
+				got = u
+				cancel()
+				return nil
+			},
+		}
+		return got, w.Run(ctx)
+	}
+
+	if u, err := run(); u == nil || err != context.Canceled {
+		t.Fatalf("expected the hook to get the payload, got %+v, %v", u, err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected the payload to be downloaded once, got %d", n)
+	}
+
+	// a corrupted payload is retried with backoff and never reaches the
+	// hook
+	payload = "payload of 2.0.1"
+	atomic.StoreInt32(&fetches, 0)
+	if u, err := run(); u != nil || err != context.DeadlineExceeded {
+		t.Errorf("expected the corrupted payload to be refused, got %+v, %v", u, err)
+	}
+	if n := atomic.LoadInt32(&fetches); n < 2 || n > 8 {
+		t.Errorf("expected a few downloads backing off, got %d", n)
+	}
+}
+
+func TestRunReportsEvents(t *testing.T) {
+	s := mockserver.New()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	service := s.Service(ts.URL)
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "2.0.0"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable"}).Do()
+	service.App.Package.Insert("app", "2.0.0", &update.Package{Url: "http://example.com/update.gz"}).Do()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	runs := 0
+	w := &Watcher{
+		Client:       &omahaclient.Client{Server: ts.URL, AppID: "app", Version: "1.0.0", Track: "prod", MachineID: "m"},
+		AppID:        "app",
+		Interval:     10 * time.Millisecond,
+		ReportEvents: true,
+		Hook: func(ctx context.Context, u *Update) error {
+			if u.OldVersion == "" {
+				return nil
+			}
+			runs++
+			if runs == 1 {
+				return &HookError{Code: 3, Err: errors.New("exit status 3")}
+			}
+			cancel()
+			return nil
+		},
+	}
+	if err := w.Run(ctx); err != context.Canceled {
+		t.Fatalf("expected the watcher to run until cancelled, got %v", err)
+	}
+	if runs != 2 || w.Client.Version != "2.0.0" {
+		t.Fatalf("expected the failed update to be retried, got %d runs ending at %s", runs, w.Client.Version)
+	}
+
+	history, err := service.Client.History("m").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range history.Items {
+		got = append(got, item.Version+" "+item.EventType+"/"+item.EventResult+" "+item.ErrorCode)
+	}
+	want := []string{
+		"1.0.0 13/1 ", "1.0.0 14/1 ", "1.0.0 3/0 3",
+		"1.0.0 13/1 ", "1.0.0 14/1 ", "1.0.0 3/1 ", "2.0.0 3/2 ",
+	}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("expected events %q, got %q", want, got)
+	}
+}
+
+func TestRunHookAlwaysFails(t *testing.T) {
+	s := mockserver.New()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	service := s.Service(ts.URL)
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "2.0.0"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable"}).Do()
+	service.App.Package.Insert("app", "2.0.0", &update.Package{Url: "http://example.com/update.gz"}).Do()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	runs := 0
+	w := &Watcher{
+		Client:       &omahaclient.Client{Server: ts.URL, AppID: "app", Version: "1.0.0", Track: "prod", MachineID: "m"},
+		AppID:        "app",
+		Interval:     10 * time.Millisecond,
+		MaxBackoff:   160 * time.Millisecond,
+		ReportEvents: true,
+		Hook: func(ctx context.Context, u *Update) error {
+			if u.OldVersion == "" {
+				return nil
+			}
+			runs++
+			return &HookError{Code: 1, Err: errors.New("exit status 1")}
+		},
+	}
+	if err := w.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the watcher to run until the deadline, got %v", err)
+	}
+	// waits of at least 5, 10, 20, 40, 80 and 80ms leave room for 7 runs
+	// in 500ms, against 50 without backoff
+	if runs < 2 || runs > 8 || w.Client.Version != "1.0.0" {
+		t.Errorf("expected a few runs backing off at 1.0.0, got %d runs at %s", runs, w.Client.Version)
+	}
+}
+
+func TestRunUnusablePayload(t *testing.T) {
+	s := mockserver.New()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	service := s.Service(ts.URL)
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "2.0.0"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable"}).Do()
+	// the update check offers a payload url which does not parse
+	service.App.Package.Insert("app", "2.0.0", &update.Package{Url: ":/update.gz"}).Do()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	warnings := 0
+	var updates []*Update
+	w := &Watcher{
+		Client:     &omahaclient.Client{Server: ts.URL, AppID: "app", Version: "1.0.0", Track: "prod", MachineID: "m"},
+		AppID:      "app",
+		Interval:   10 * time.Millisecond,
+		MaxBackoff: 40 * time.Millisecond,
+		Logf: func(format string, v ...interface{}) {
+			if warnings++; warnings == 2 {
+				service.App.Package.Delete("app", "2.0.0").Do()
+				service.App.Package.Insert("app", "2.0.0", &update.Package{Url: "http://example.com/update.gz"}).Do()
+			}
+		},
+		Hook: func(ctx context.Context, u *Update) error {
+			updates = append(updates, u)
+			if u.Version == "2.0.0" {
+				cancel()
+			}
+			return nil
+		},
+	}
+	if err := w.Run(ctx); err != context.Canceled {
+		t.Fatalf("expected the watcher to run until cancelled, got %v", err)
+	}
+	if warnings < 2 || len(updates) != 2 || updates[1].URL != "http://example.com/update.gz" {
+		t.Errorf("expected the update to be retried until its payload is usable, got %d warnings and %+v", warnings, updates)
+	}
+}
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+// fleetChange is a single change needed to bring the server in line with a
+// manifest. Changes are ordered so that they can be applied one after the
+// other: apps before their packages, packages before the channels pointing
+// at them and channels before the groups using them.
+type fleetChange struct {
+	Op     string         `json:"op"`
+	Kind   string         `json:"kind"`
+	AppId  string         `json:"appId"`
+	Id     string         `json:"id"`
+	Fields []*fieldChange `json:"fields,omitempty"`
+
+	apply func(service *update.Service) error
+}
+
+type fieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+const (
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+
+	kindApp      = "app"
+	kindChannel  = "channel"
+	kindGroup    = "group"
+	kindRollout  = "rollout"
+	kindPackage  = "package"
+	kindUpstream = "upstream"
+)
+
+func (c *fleetChange) String() string {
+	if c.AppId == "" {
+		return fmt.Sprintf("%s %s %s", c.Op, c.Kind, c.Id)
+	}
+	return fmt.Sprintf("%s %s %s (app %s)", c.Op, c.Kind, c.Id, c.AppId)
+}
+
+var opMarkers = map[string]string{
+	opCreate: "+",
+	opUpdate: "~",
+	opDelete: "-",
+}
+
+// formatChange renders a change and its field changes for humans.
+func formatChange(c *fleetChange) string {
+	s := fmt.Sprintf("%s %s\n", opMarkers[c.Op], c)
+	for _, f := range c.Fields {
+		if f.Old == nil {
+			s += fmt.Sprintf("\t%s:\t%v\n", f.Field, f.New)
+		} else {
+			s += fmt.Sprintf("\t%s:\t%v -> %v\n", f.Field, f.Old, f.New)
+		}
+	}
+	return s
+}
+
+// compare records a field change if old and new differ.
+func (c *fleetChange) compare(field string, old, new interface{}) {
+	if !reflect.DeepEqual(old, new) {
+		c.Fields = append(c.Fields, &fieldChange{field, old, new})
+	}
+}
+
+// set records a field of an object which is about to be created. Fields
+// left at their zero value are skipped.
+func (c *fleetChange) set(field string, new interface{}) {
+	if !reflect.ValueOf(new).IsZero() {
+		c.Fields = append(c.Fields, &fieldChange{field, nil, new})
+	}
+}
+
+func (c *fleetChange) changed(field string) bool {
+	for _, f := range c.Fields {
+		if f.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// liveState is the current server state of the objects a manifest
+// describes.
+type liveState struct {
+	apps      map[string]*liveApp
+	upstreams map[string]*update.Upstream
+}
+
+// liveApp is the current server state of an app and the objects below it.
+type liveApp struct {
+	app      *update.App
+	channels map[string]*update.AppChannel
+	groups   map[string]*update.Group
+	rollouts map[string]*update.Rollout
+	packages map[string]*update.Package
+}
+
+// fetchLiveState loads the server state of every app listed in the
+// manifest. Apps which don't exist yet are left out of the result.
+func fetchLiveState(service *update.Service, manifest *Manifest) (*liveState, error) {
+	apps, err := service.App.List().Do()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]*ManifestApp)
+	for _, app := range manifest.Apps {
+		wanted[app.Id] = app
+	}
+
+	live := &liveState{
+		apps:      make(map[string]*liveApp),
+		upstreams: make(map[string]*update.Upstream),
+	}
+	for _, app := range apps.Items {
+		mapp, ok := wanted[app.Id]
+		if !ok {
+			continue
+		}
+
+		la := &liveApp{
+			app:      app,
+			channels: make(map[string]*update.AppChannel),
+			groups:   make(map[string]*update.Group),
+			rollouts: make(map[string]*update.Rollout),
+			packages: make(map[string]*update.Package),
+		}
+
+		channels, err := service.Channel.List(app.Id).Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range channels.Items {
+			la.channels[channel.Label] = channel
+		}
+
+		groups, err := service.Group.List(app.Id).Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups.Items {
+			la.groups[group.Id] = group
+		}
+
+		// rollouts are only fetched for groups that manage them.
+		for _, mgroup := range mapp.Groups {
+			if mgroup.Rollout == nil || la.groups[mgroup.Id] == nil {
+				continue
+			}
+			rollout, err := fetchRollout(service, app.Id, mgroup.Id)
+			if err != nil {
+				return nil, err
+			}
+			la.rollouts[mgroup.Id] = rollout
+		}
+
+		if len(mapp.Packages) > 0 {
+			packages, err := fetchPackages(service, app.Id)
+			if err != nil {
+				return nil, err
+			}
+			for _, pkg := range packages {
+				la.packages[pkg.Version] = pkg
+			}
+		}
+
+		live.apps[app.Id] = la
+	}
+
+	if len(manifest.Upstreams) > 0 {
+		upstreams, err := service.Upstream.List().Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, upstream := range upstreams.Items {
+			live.upstreams[upstream.Url] = upstream
+		}
+	}
+	return live, nil
+}
+
+// fetchRollout returns the rollout of a group, or an empty rollout if the
+// group never had one.
+func fetchRollout(service *update.Service, appId, groupId string) (*update.Rollout, error) {
+	rollout, err := service.Group.Rollout.Get(appId, groupId).Do()
+	if isNotFound(err) {
+		return &update.Rollout{AppId: appId, GroupId: groupId}, nil
+	}
+	return rollout, err
+}
+
+// packagePageSize is the number of packages requested at a time.
+const packagePageSize = 100
+
+// fetchPackages pages through all packages of an app. The total reported
+// by the server is not relied on as it may be left out.
+func fetchPackages(service *update.Service, appId string) ([]*update.Package, error) {
+	var packages []*update.Package
+	for {
+		list, err := service.App.Package.List(appId).
+			Limit(packagePageSize).
+			Skip(int64(len(packages))).
+			Do()
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, list.Items...)
+		if len(list.Items) < packagePageSize {
+			return packages, nil
+		}
+	}
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// planManifest computes the changes needed to make the server match the
+// manifest. If prune is set, channels and groups of the listed apps which
+// are not in the manifest are deleted.
+func planManifest(manifest *Manifest, live *liveState, prune bool) []*fleetChange {
+	var upstreams, apps, channels, packages, groups, rollouts, deletes []*fleetChange
+
+	for _, mupstream := range manifest.Upstreams {
+		if c := planUpstream(mupstream, live.upstreams[mupstream.Url]); c != nil {
+			upstreams = append(upstreams, c)
+		}
+	}
+
+	for _, mapp := range manifest.Apps {
+		la := live.apps[mapp.Id]
+		if la == nil {
+			la = &liveApp{}
+		}
+
+		if c := planApp(mapp, la.app); c != nil {
+			apps = append(apps, c)
+		}
+
+		for _, mchannel := range mapp.Channels {
+			if c := planChannel(mapp.Id, mchannel, la.channels[mchannel.Label]); c != nil {
+				channels = append(channels, c)
+			}
+		}
+
+		for _, pkg := range mapp.Packages {
+			if la.packages[pkg.Version] == nil {
+				packages = append(packages, planPackage(mapp.Id, pkg))
+			}
+		}
+
+		for _, mgroup := range mapp.Groups {
+			group := la.groups[mgroup.Id]
+			if c := planGroup(mapp.Id, mgroup, group); c != nil {
+				groups = append(groups, c)
+			}
+			if mgroup.Rollout == nil {
+				continue
+			}
+			if c := planRollout(mapp.Id, mgroup, group, la.rollouts[mgroup.Id]); c != nil {
+				rollouts = append(rollouts, c)
+			}
+		}
+
+		if prune {
+			deletes = append(deletes, planPrune(mapp, la)...)
+		}
+	}
+
+	changes := []*fleetChange{}
+	for _, cs := range [][]*fleetChange{upstreams, apps, packages, channels, groups, rollouts, deletes} {
+		changes = append(changes, cs...)
+	}
+	return changes
+}
+
+func planApp(mapp *ManifestApp, app *update.App) *fleetChange {
+	if app == nil {
+		c := &fleetChange{Op: opCreate, Kind: kindApp, AppId: mapp.Id, Id: mapp.Id}
+		c.set("label", mapp.Label)
+		c.set("description", mapp.Description)
+		c.apply = func(service *update.Service) error {
+			_, err := service.App.Insert(&update.AppInsertReq{
+				Id:          mapp.Id,
+				Label:       mapp.Label,
+				Description: mapp.Description,
+			}).Do()
+			return err
+		}
+		return c
+	}
+
+	c := &fleetChange{Op: opUpdate, Kind: kindApp, AppId: mapp.Id, Id: mapp.Id}
+	c.compare("label", app.Label, mapp.Label)
+	c.compare("description", app.Description, mapp.Description)
+	if len(c.Fields) == 0 {
+		return nil
+	}
+	c.apply = func(service *update.Service) error {
+		_, err := service.App.Update(mapp.Id, &update.AppUpdateReq{
+			Label:       mapp.Label,
+			Description: mapp.Description,
+		}).Do()
+		return err
+	}
+	return c
+}
+
+func planUpstream(mupstream *ManifestUpstream, upstream *update.Upstream) *fleetChange {
+	if upstream == nil {
+		c := &fleetChange{Op: opCreate, Kind: kindUpstream, Id: mupstream.Url}
+		c.set("label", mupstream.Label)
+		c.apply = func(service *update.Service) error {
+			_, err := service.Upstream.Insert(&update.Upstream{
+				Url:   mupstream.Url,
+				Label: mupstream.Label,
+			}).Do()
+			return err
+		}
+		return c
+	}
+
+	c := &fleetChange{Op: opUpdate, Kind: kindUpstream, Id: mupstream.Url}
+	c.compare("label", upstream.Label, mupstream.Label)
+	if len(c.Fields) == 0 {
+		return nil
+	}
+	c.apply = func(service *update.Service) error {
+		_, err := service.Upstream.Update(upstream.Id, &update.Upstream{
+			Id:    upstream.Id,
+			Url:   mupstream.Url,
+			Label: mupstream.Label,
+		}).Do()
+		return err
+	}
+	return c
+}
+
+func planPackage(appId string, pkg *update.Package) *fleetChange {
+	c := &fleetChange{Op: opCreate, Kind: kindPackage, AppId: appId, Id: pkg.Version}
+	c.set("url", pkg.Url)
+	c.set("size", pkg.Size)
+	c.set("sha1Sum", pkg.Sha1Sum)
+	c.set("sha256Sum", pkg.Sha256Sum)
+	c.apply = func(service *update.Service) error {
+		p := *pkg
+		p.AppId = appId
+		_, err := service.App.Package.Insert(appId, pkg.Version, &p).Do()
+		return err
+	}
+	return c
+}
+
+func planChannel(appId string, mchannel *ManifestChannel, channel *update.AppChannel) *fleetChange {
+	req := &update.ChannelRequest{
+		AppId:   appId,
+		Label:   mchannel.Label,
+		Version: mchannel.Version,
+		Publish: mchannel.Publish,
+	}
+
+	if channel == nil {
+		c := &fleetChange{Op: opCreate, Kind: kindChannel, AppId: appId, Id: mchannel.Label}
+		c.set("version", mchannel.Version)
+		c.set("publish", mchannel.Publish)
+		if mchannel.Upstream != "" {
+			c.set("upstream", mchannel.Upstream)
+		}
+		c.apply = func(service *update.Service) error {
+			if _, err := service.Channel.Insert(appId, req).Do(); err != nil {
+				return err
+			}
+			if mchannel.Upstream != "" {
+				return bindUpstream(service, appId, mchannel)
+			}
+			return nil
+		}
+		return c
+	}
+
+	c := &fleetChange{Op: opUpdate, Kind: kindChannel, AppId: appId, Id: mchannel.Label}
+	c.compare("version", channel.Version, mchannel.Version)
+	c.compare("publish", channel.Publish, mchannel.Publish)
+	if mchannel.Upstream != "" {
+		c.compare("upstream", channel.Upstream, mchannel.Upstream)
+	}
+	if len(c.Fields) == 0 {
+		return nil
+	}
+	c.apply = func(service *update.Service) error {
+		if c.changed("version") || c.changed("publish") {
+			if _, err := service.Channel.Update(appId, mchannel.Label, req).Do(); err != nil {
+				return err
+			}
+		}
+		if c.changed("upstream") {
+			return bindUpstream(service, appId, mchannel)
+		}
+		return nil
+	}
+	return c
+}
+
+// bindUpstream binds a channel to its upstream. The API has no call for
+// it: the update service binds channels when it syncs its upstreams, so
+// sync them and check that it did.
+func bindUpstream(service *update.Service, appId string, mchannel *ManifestChannel) error {
+	if _, err := service.Upstream.Sync().Do(); err != nil {
+		return err
+	}
+	channels, err := service.Channel.List(appId).Do()
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels.Items {
+		if channel.Label == mchannel.Label && channel.Upstream == mchannel.Upstream {
+			return nil
+		}
+	}
+	return fmt.Errorf("channel %s of app %s is not bound to upstream %s after syncing upstreams",
+		mchannel.Label, appId, mchannel.Upstream)
+}
+
+func planGroup(appId string, mgroup *ManifestGroup, group *update.Group) *fleetChange {
+	setPercent := func(service *update.Service) error {
+		_, err := service.Group.Percent.Set(appId, mgroup.Id, &update.GroupPercent{
+			AppId:         appId,
+			Id:            mgroup.Id,
+			UpdatePercent: *mgroup.UpdatePercent,
+		}).Do()
+		return err
+	}
+
+	if group == nil {
+		c := &fleetChange{Op: opCreate, Kind: kindGroup, AppId: appId, Id: mgroup.Id}
+		c.set("label", mgroup.Label)
+		c.set("channel", mgroup.Channel)
+		c.set("oemBlacklist", mgroup.oemBlacklist())
+		if mgroup.Paused != nil {
+			c.set("paused", *mgroup.Paused)
+		}
+		if mgroup.UpdatePercent != nil {
+			c.Fields = append(c.Fields, &fieldChange{"updatePercent", nil, *mgroup.UpdatePercent})
+		}
+		c.apply = func(service *update.Service) error {
+			_, err := service.Group.Insert(appId, &update.Group{
+				Id:            mgroup.Id,
+				Label:         mgroup.Label,
+				ChannelId:     mgroup.Channel,
+				OemBlacklist:  mgroup.oemBlacklist(),
+				UpdatesPaused: mgroup.Paused != nil && *mgroup.Paused,
+			}).Do()
+			if err != nil || mgroup.UpdatePercent == nil {
+				return err
+			}
+			return setPercent(service)
+		}
+		return c
+	}
+
+	c := &fleetChange{Op: opUpdate, Kind: kindGroup, AppId: appId, Id: mgroup.Id}
+	c.compare("label", group.Label, mgroup.Label)
+	c.compare("channel", group.ChannelId, mgroup.Channel)
+	c.compare("oemBlacklist", group.OemBlacklist, mgroup.oemBlacklist())
+	if mgroup.Paused != nil {
+		c.compare("paused", group.UpdatesPaused, *mgroup.Paused)
+	}
+	patch := len(c.Fields) > 0
+	if mgroup.UpdatePercent != nil {
+		c.compare("updatePercent", group.UpdatePercent, *mgroup.UpdatePercent)
+	}
+	if len(c.Fields) == 0 {
+		return nil
+	}
+
+	c.apply = func(service *update.Service) error {
+		if patch {
+			g := *group
+			g.Label = mgroup.Label
+			g.ChannelId = mgroup.Channel
+			g.OemBlacklist = mgroup.oemBlacklist()
+			// cleared fields and unpausing must be sent too
+			g.ForceSendFields = []string{"Label", "OemBlacklist"}
+			if mgroup.Paused != nil {
+				g.UpdatesPaused = *mgroup.Paused
+				g.ForceSendFields = append(g.ForceSendFields, "UpdatesPaused")
+			}
+			if _, err := service.Group.Patch(appId, mgroup.Id, &g).Do(); err != nil {
+				return err
+			}
+		}
+		if c.changed("updatePercent") {
+			return setPercent(service)
+		}
+		return nil
+	}
+	return c
+}
+
+func planRollout(appId string, mgroup *ManifestGroup, group *update.Group, rollout *update.Rollout) *fleetChange {
+	// validate has already refused rollouts without valid frames
+	frames, _ := mgroup.Rollout.frames()
+	active := mgroup.Rollout.Active
+
+	c := &fleetChange{Op: opUpdate, Kind: kindRollout, AppId: appId, Id: mgroup.Id}
+	if group == nil || rollout == nil {
+		c.Op = opCreate
+		c.set("frames", formatFrames(frames))
+		c.Fields = append(c.Fields, &fieldChange{"active", nil, active})
+	} else {
+		c.compare("frames", formatFrames(rollout.Rollout), formatFrames(frames))
+		c.compare("active", group.RolloutActive, active)
+		if len(c.Fields) == 0 {
+			return nil
+		}
+	}
+
+	c.apply = func(service *update.Service) error {
+		if c.Op == opCreate || c.changed("frames") {
+			_, err := service.Group.Rollout.Set(appId, mgroup.Id, &update.Rollout{
+				AppId:   appId,
+				GroupId: mgroup.Id,
+				Rollout: frames,
+			}).Do()
+			if err != nil {
+				return err
+			}
+		}
+		if c.Op == opCreate || c.changed("active") {
+			_, err := service.Group.Rollout.Active.Set(appId, mgroup.Id, &update.RolloutActive{
+				AppId:   appId,
+				GroupId: mgroup.Id,
+				Active:  active,
+			}).Do()
+			return err
+		}
+		return nil
+	}
+	return c
+}
+
+// formatFrames renders rollout frames as "percent%/duration" pairs so
+// they can be compared and shown in a single field change.
+func formatFrames(frames []*update.Frame) string {
+	s := make([]string, len(frames))
+	for i, f := range frames {
+		s[i] = fmt.Sprintf("%g%%/%ds", f.Percent, f.Duration)
+	}
+	return strings.Join(s, " ")
+}
+
+func sortChanges(changes []*fleetChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Id < changes[j].Id
+	})
+}
+
+func planPrune(mapp *ManifestApp, la *liveApp) []*fleetChange {
+	var groups, channels []*fleetChange
+
+	wantedGroups := make(map[string]bool)
+	for _, mgroup := range mapp.Groups {
+		wantedGroups[mgroup.Id] = true
+	}
+	for id := range la.groups {
+		if wantedGroups[id] {
+			continue
+		}
+		groupId := id
+		groups = append(groups, &fleetChange{
+			Op:    opDelete,
+			Kind:  kindGroup,
+			AppId: mapp.Id,
+			Id:    groupId,
+			apply: func(service *update.Service) error {
+				_, err := service.Group.Delete(mapp.Id, groupId).Do()
+				return err
+			},
+		})
+	}
+
+	wantedChannels := make(map[string]bool)
+	for _, mchannel := range mapp.Channels {
+		wantedChannels[mchannel.Label] = true
+	}
+	for label := range la.channels {
+		if wantedChannels[label] {
+			continue
+		}
+		channelLabel := label
+		channels = append(channels, &fleetChange{
+			Op:    opDelete,
+			Kind:  kindChannel,
+			AppId: mapp.Id,
+			Id:    channelLabel,
+			apply: func(service *update.Service) error {
+				_, err := service.Channel.Delete(mapp.Id, channelLabel).Do()
+				return err
+			},
+		})
+	}
+
+	sortChanges(groups)
+	sortChanges(channels)
+
+	// groups go first since they may still reference the channels.
+	return append(groups, channels...)
+}
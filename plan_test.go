@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/mockserver"
+	"github.com/coreos/updateservicectl/pkg/rollout"
+)
+
+func TestPlanManifest(t *testing.T) {
+	percent := 50.0
+	manifest := &Manifest{
+		Apps: []*ManifestApp{
+			{
+				Id:    "app",
+				Label: "App",
+				Channels: []*ManifestChannel{
+					{Label: "stable", Version: "1.0.1", Publish: true},
+					{Label: "beta", Version: "1.1.0", Publish: true},
+				},
+				Groups: []*ManifestGroup{
+					{Id: "prod", Label: "Prod", Channel: "stable", UpdatePercent: &percent},
+					{Id: "canary", Label: "Canary", Channel: "beta"},
+				},
+			},
+		},
+	}
+	live := &liveState{apps: map[string]*liveApp{
+		"app": {
+			app: &update.App{Id: "app", Label: "App"},
+			channels: map[string]*update.AppChannel{
+				"stable": {Label: "stable", Version: "1.0.0", Publish: true},
+				"old":    {Label: "old", Version: "0.9.0"},
+			},
+			groups: map[string]*update.Group{
+				"prod": {Id: "prod", Label: "Prod", ChannelId: "stable", UpdatePercent: 100},
+			},
+		},
+	}}
+
+	changes := planManifest(manifest, live, true)
+
+	expected := []string{
+		"update channel stable (app app)",
+		"create channel beta (app app)",
+		"update group prod (app app)",
+		"create group canary (app app)",
+		"delete channel old (app app)",
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %d: %v", len(expected), len(changes), changes)
+	}
+	for i, c := range changes {
+		if c.String() != expected[i] {
+			t.Errorf("change %d: expected %q, got %q", i, expected[i], c.String())
+		}
+	}
+
+	prod := changes[2]
+	if len(prod.Fields) != 1 || prod.Fields[0].Field != "updatePercent" {
+		t.Errorf("expected only updatePercent to change, got %v", prod.Fields)
+	}
+}
+
+func TestPlanManifestNoChanges(t *testing.T) {
+	manifest := &Manifest{
+		Apps: []*ManifestApp{
+			{
+				Id: "app",
+				Groups: []*ManifestGroup{
+					{
+						Id:           "prod",
+						Channel:      "stable",
+						OemBlacklist: []string{"azure", "gce"},
+						Rollout: &ManifestRollout{
+							Active: true,
+							Linear: &ManifestLinear{FrameSize: 10, Duration: 20},
+						},
+					},
+				},
+			},
+		},
+	}
+	linear, err := rollout.Linear("app", "prod", 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := &liveState{apps: map[string]*liveApp{
+		"app": {
+			app: &update.App{Id: "app"},
+			groups: map[string]*update.Group{
+				"prod": {Id: "prod", ChannelId: "stable", OemBlacklist: "azure,gce", RolloutActive: true},
+			},
+			rollouts: map[string]*update.Rollout{
+				"prod": linear,
+			},
+		},
+	}}
+
+	if changes := planManifest(manifest, live, false); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
+
+func TestApplyUnpause(t *testing.T) {
+	s := mockserver.New()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	service := s.Service(ts.URL)
+
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable"}).Do()
+	_, err := service.Group.Insert("app", &update.Group{
+		Id:            "prod",
+		Label:         "Prod",
+		ChannelId:     "stable",
+		OemBlacklist:  "azure",
+		UpdatesPaused: true,
+	}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a group without paused stays paused
+	manifest := &Manifest{Apps: []*ManifestApp{{
+		Id:     "app",
+		Groups: []*ManifestGroup{{Id: "prod", Label: "Prod", Channel: "stable", OemBlacklist: []string{"azure"}}},
+	}}}
+	plan := func() []*fleetChange {
+		live, err := fetchLiveState(service, manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return planManifest(manifest, live, false)
+	}
+	if changes := plan(); len(changes) != 0 {
+		t.Errorf("expected a group without paused to be left alone, got %v", changes)
+	}
+
+	paused := false
+	manifest.Apps[0].Groups[0] = &ManifestGroup{Id: "prod", Channel: "stable", Paused: &paused}
+	changes := plan()
+	if len(changes) != 1 {
+		t.Fatalf("expected the group to change, got %v", changes)
+	}
+	if err := changes[0].apply(service); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := service.Group.Get("app", "prod").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.UpdatesPaused || g.Label != "" || g.OemBlacklist != "" {
+		t.Errorf("expected the group to be unpaused and cleared, got %+v", g)
+	}
+	if changes := plan(); len(changes) != 0 {
+		t.Errorf("expected no drift after apply, got %v", changes)
+	}
+}
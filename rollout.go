@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
-	"math"
+	"os"
 	"text/tabwriter"
+	"time"
 
 	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/rollout"
 )
 
 var (
@@ -19,6 +22,29 @@ var (
 		// linear rollouts
 		frameSize int64
 		duration  int64
+
+		// exponential rollouts
+		startPercent      float64
+		exponentFrameSize int64
+
+		// stepped rollouts
+		steps string
+
+		// custom rollouts
+		file string
+
+		// supervising rollouts
+		version    string
+		threshold  float64
+		minUpdates int64
+		window     int64
+		interval   int64
+		action     string
+		dryRun     bool
+
+		// rollout status
+		watch         bool
+		watchInterval int64
 	}
 
 	cmdRollout = &Command{
@@ -28,6 +54,8 @@ var (
 			cmdRolloutCreate,
 			cmdRolloutActivate,
 			cmdRolloutDeactivate,
+			cmdRolloutSupervise,
+			cmdRolloutStatus,
 		},
 		Run: rolloutGet,
 	}
@@ -37,6 +65,9 @@ var (
 		Summary: "Create an automated rollout.",
 		Subcommands: []*Command{
 			cmdRolloutLinear,
+			cmdRolloutExponential,
+			cmdRolloutSteps,
+			cmdRolloutCustom,
 		},
 	}
 	cmdRolloutActivate = &Command{
@@ -52,6 +83,39 @@ var (
 		Run:     rolloutDeactivate,
 	}
 
+	cmdRolloutStatus = &Command{
+		Name:    "rollout status",
+		Usage:   "[OPTION]...",
+		Summary: "Show the progress of a rollout.",
+		Description: `Show which frame of the rollout is in effect, the time elapsed and remaining,
+and how many instances of the group already run the version being rolled
+out.
+
+The server does not record when a rollout started, so the frame is derived
+from the current update percentage of the group and the times are estimated
+from the frame durations. Instances are counted once each, with the version
+they last reported, if they were seen during the last hour.
+
+With --watch the status is refreshed every --interval seconds until the
+rollout completes or is deactivated.`,
+		Run: rolloutStatus,
+	}
+	cmdRolloutSupervise = &Command{
+		Name:    "rollout supervise",
+		Usage:   "[OPTION]...",
+		Summary: "Stop a rollout when too many updates fail.",
+		Description: `Watch the update events of an active rollout and stop it when the failure
+rate of the new version exceeds --threshold percent.
+
+Every --interval seconds the update complete events of the last --window
+seconds are counted. Once at least --min-updates clients reported, a failure
+rate above the threshold pauses the updates of the group, deactivates the
+rollout or both, depending on --action. Every decision is logged to stderr.
+
+The command exits once the rollout was stopped or is no longer active.`,
+		Run: rolloutSupervise,
+	}
+
 	// each type of rollout has it's own subcommand different arguments and
 	// behavior, even though they all use the same API endpoint.
 	cmdRolloutLinear = &Command{
@@ -60,6 +124,39 @@ var (
 		Summary: "Create a linear rollout.",
 		Run:     rolloutLinear,
 	}
+	cmdRolloutExponential = &Command{
+		Name:    "rollout create exponential",
+		Usage:   "[OPTION]...",
+		Summary: "Create an exponential rollout.",
+		Description: `Create a rollout which starts at --start-percent and doubles the update
+percentage every --frame-size seconds until it reaches 100%.`,
+		Run: rolloutExponential,
+	}
+	cmdRolloutSteps = &Command{
+		Name:    "rollout create steps",
+		Usage:   "[OPTION]...",
+		Summary: "Create a rollout from a list of steps.",
+		Description: `Create a rollout from a comma separated list of PERCENT:DURATION steps.
+Durations use the units s, m, h and d. The duration of the last step may be
+left out to hold its percentage once the rollout ends, for example:
+
+--steps 1%:1h,10%:6h,50%:1d,100%`,
+		Run: rolloutSteps,
+	}
+	cmdRolloutCustom = &Command{
+		Name:    "rollout create custom",
+		Usage:   "[OPTION]...",
+		Summary: "Create a rollout from a file of frames.",
+		Description: `Create a rollout from a JSON file with a list of frames, each with a
+percent and a duration in seconds. Use - to read from stdin, for example:
+
+[
+  {"percent": 5, "duration": 3600},
+  {"percent": 25, "duration": 21600},
+  {"percent": 100, "duration": 0}
+]`,
+		Run: rolloutCustom,
+	}
 )
 
 func init() {
@@ -81,6 +178,38 @@ func init() {
 	cmdRolloutDeactivate.Flags.Var(&rolloutFlags.groupId, "group-id",
 		"ID of the group the rollout is associated with.")
 
+	// rollout status
+	cmdRolloutStatus.Flags.Var(&rolloutFlags.appId, "app-id",
+		"Application containing the group the rollout is associated with.")
+	cmdRolloutStatus.Flags.Var(&rolloutFlags.groupId, "group-id",
+		"ID of the group the rollout is associated with.")
+	cmdRolloutStatus.Flags.StringVar(&rolloutFlags.version, "version", "",
+		"Version being rolled out (default the version of the group's channel)")
+	cmdRolloutStatus.Flags.BoolVar(&rolloutFlags.watch, "watch", false,
+		"Refresh the status until the rollout completes")
+	cmdRolloutStatus.Flags.Int64Var(&rolloutFlags.watchInterval, "interval", 10,
+		"Seconds between refreshes with --watch")
+
+	// supervising a rollout
+	cmdRolloutSupervise.Flags.Var(&rolloutFlags.appId, "app-id",
+		"Application containing the group the rollout is associated with.")
+	cmdRolloutSupervise.Flags.Var(&rolloutFlags.groupId, "group-id",
+		"ID of the group the rollout is associated with.")
+	cmdRolloutSupervise.Flags.StringVar(&rolloutFlags.version, "version", "",
+		"Version being rolled out (default the version of the group's channel)")
+	cmdRolloutSupervise.Flags.Float64Var(&rolloutFlags.threshold, "threshold", 5,
+		"Failure rate in percent above which the rollout is stopped")
+	cmdRolloutSupervise.Flags.Int64Var(&rolloutFlags.minUpdates, "min-updates", 10,
+		"Number of updates to wait for before judging the failure rate")
+	cmdRolloutSupervise.Flags.Int64Var(&rolloutFlags.window, "window", 86400,
+		"Take events of the last this many seconds into account (default 1 day)")
+	cmdRolloutSupervise.Flags.Int64Var(&rolloutFlags.interval, "interval", 60,
+		"Seconds between checks")
+	cmdRolloutSupervise.Flags.StringVar(&rolloutFlags.action, "action", rollout.ActionPause,
+		"What to do with an unhealthy rollout: pause, deactivate or both")
+	cmdRolloutSupervise.Flags.BoolVar(&rolloutFlags.dryRun, "dry-run", false,
+		"Log decisions without changing the group")
+
 	// creating a linear rollout
 	cmdRolloutLinear.Flags.Var(&rolloutFlags.appId, "app-id",
 		"Application containing the group the rollout is associated with.")
@@ -90,6 +219,42 @@ func init() {
 		"Duration of a rollout step (or frame) in seconds (default 60 seconds)")
 	cmdRolloutLinear.Flags.Int64Var(&rolloutFlags.duration, "duration", 86400,
 		"Total duration for the rollout to go from 0% to 100%, in seconds (default 1 day)")
+	cmdRolloutLinear.Flags.BoolVar(&rolloutFlags.dryRun, "dry-run", false,
+		"Print the schedule of the rollout without setting it")
+
+	// creating an exponential rollout
+	cmdRolloutExponential.Flags.Var(&rolloutFlags.appId, "app-id",
+		"Application containing the group the rollout is associated with.")
+	cmdRolloutExponential.Flags.Var(&rolloutFlags.groupId, "group-id",
+		"ID of the group the rollout is associated with.")
+	cmdRolloutExponential.Flags.Float64Var(&rolloutFlags.startPercent, "start-percent", 1,
+		"Update percentage of the first frame (default 1%)")
+	cmdRolloutExponential.Flags.Int64Var(&rolloutFlags.exponentFrameSize, "frame-size", 3600,
+		"Duration of a rollout step (or frame) in seconds (default 1 hour)")
+	cmdRolloutExponential.Flags.BoolVar(&rolloutFlags.dryRun, "dry-run", false,
+		"Print the schedule of the rollout without setting it")
+
+	// creating a stepped rollout
+	cmdRolloutSteps.Flags.Var(&rolloutFlags.appId, "app-id",
+		"Application containing the group the rollout is associated with.")
+	cmdRolloutSteps.Flags.Var(&rolloutFlags.groupId, "group-id",
+		"ID of the group the rollout is associated with.")
+	cmdRolloutSteps.Flags.StringVar(&rolloutFlags.steps, "steps", "",
+		"Comma separated list of PERCENT:DURATION steps.")
+	cmdRolloutSteps.Flags.BoolVar(&rolloutFlags.dryRun, "dry-run", false,
+		"Print the schedule of the rollout without setting it")
+
+	// creating a custom rollout
+	cmdRolloutCustom.Flags.Var(&rolloutFlags.appId, "app-id",
+		"Application containing the group the rollout is associated with.")
+	cmdRolloutCustom.Flags.Var(&rolloutFlags.groupId, "group-id",
+		"ID of the group the rollout is associated with.")
+	cmdRolloutCustom.Flags.StringVar(&rolloutFlags.file, "file", "",
+		"JSON file with the frames of the rollout, or - for stdin.")
+	cmdRolloutCustom.Flags.StringVar(&rolloutFlags.file, "f", "",
+		"Shorthand for --file.")
+	cmdRolloutCustom.Flags.BoolVar(&rolloutFlags.dryRun, "dry-run", false,
+		"Print the schedule of the rollout without setting it")
 }
 
 func displayRollout(out io.Writer, rollout *update.Rollout) {
@@ -111,11 +276,15 @@ func rolloutGet(args []string, service *update.Service, out *tabwriter.Writer) i
 
 	rollout, err := call.Do()
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	displayRollout(out, rollout)
-	out.Flush()
+	err = printResult(out, rollout, func(out *tabwriter.Writer) {
+		displayRollout(out, rollout)
+	})
+	if err != nil {
+		return handleError(err)
+	}
 
 	return OK
 }
@@ -135,15 +304,19 @@ func setActive(service *update.Service, out *tabwriter.Writer, active bool) int
 
 	rolloutActive, err := setCall.Do()
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	if rolloutActive.Active {
-		fmt.Fprintf(out, "rollout activated\n")
-	} else {
-		fmt.Fprintf(out, "rollout deactivated\n")
+	err = printResult(out, rolloutActive, func(out *tabwriter.Writer) {
+		if rolloutActive.Active {
+			fmt.Fprintf(out, "rollout activated\n")
+		} else {
+			fmt.Fprintf(out, "rollout deactivated\n")
+		}
+	})
+	if err != nil {
+		return handleError(err)
 	}
-	out.Flush()
 
 	return OK
 }
@@ -156,73 +329,237 @@ func rolloutDeactivate(args []string, service *update.Service, out *tabwriter.Wr
 	return setActive(service, out, false)
 }
 
-func generateLinear(appId, groupId string, frameSize, totalDuration int64) *update.Rollout {
-	var frames []*update.Frame
-	duration := totalDuration
-	stepSize := 100 / math.Ceil(float64(duration)/float64(frameSize))
-	percent := 0.0
+// previewRollout prints when each frame of r would start if it was set now,
+// along with the number of instances of the group it would reach.
+func previewRollout(service *update.Service, out *tabwriter.Writer, r *update.Rollout) int {
+	count, err := service.Clientupdate.Count().
+		AppId(r.AppId).
+		GroupId(r.GroupId).
+		Do()
+	if err != nil {
+		return handleError(err)
+	}
 
-	for {
-		// we stop making frames when we are at the end of the rollout
-		if duration <= 0 {
-			break
+	schedule := rollout.Schedule(r, time.Now().Truncate(time.Second), count.Count)
+
+	err = printResult(out, schedule, func(out *tabwriter.Writer) {
+		fmt.Fprintf(out, "dry run, rollout of group %s not set (%d instances)\n", r.GroupId, count.Count)
+		fmt.Fprintln(out, "Frame\tStart\tElapsed\tPercent\tDuration\tInstances")
+		for _, frame := range schedule {
+			elapsed := frame.Start.Sub(schedule[0].Start)
+			fmt.Fprintf(out, "%d\t%s\t%s\t%g%%\t%s\t%d\n",
+				frame.Frame, frame.Start.Format(time.RFC3339), elapsed,
+				frame.Percent, time.Duration(frame.Duration)*time.Second, frame.Instances)
 		}
+	})
+	if err != nil {
+		return handleError(err)
+	}
+	return OK
+}
 
-		// set our current frame and step size
-		size := frameSize
-		percent += stepSize
-		if size >= duration {
-			// if that's longer than the time we have left, this is the final
-			// frame. use the rest of the duration to set us to 100%
-			size = duration
+func rolloutStatus(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if rolloutFlags.appId.Get() == nil ||
+		rolloutFlags.groupId.Get() == nil ||
+		rolloutFlags.watchInterval <= 0 {
+		return ERROR_USAGE
+	}
+
+	for {
+		status, err := rollout.GetStatus(context.Background(), service,
+			rolloutFlags.appId.String(), rolloutFlags.groupId.String(), rolloutFlags.version)
+		if err != nil {
+			return handleError(err)
 		}
 
-		// append our new frame to the end of the frames list
-		frames = append(frames, &update.Frame{
-			Duration: size,
-			Percent:  percent,
+		err = printResult(out, status, func(out *tabwriter.Writer) {
+			displayRolloutStatus(out, status)
 		})
+		if err != nil {
+			return handleError(err)
+		}
 
-		// subtract our frame from the total duration
-		duration -= size
+		if !rolloutFlags.watch || status.Complete || !status.Active {
+			return OK
+		}
+		time.Sleep(time.Duration(rolloutFlags.watchInterval) * time.Second)
+		if !machineOutput() {
+			fmt.Fprintln(out)
+		}
 	}
+}
 
-	// add one more frame to bring us up to 100%. the server will set this
-	// frame, and the next time it checks, it will exit the rollout, since the
-	// duration is set to 0.
-	frames = append(frames, &update.Frame{
-		Duration: 0,
-		Percent:  100,
-	})
+func displayRolloutStatus(out io.Writer, s *rollout.Status) {
+	state := "inactive"
+	if s.Complete {
+		state = "complete"
+	} else if s.Active {
+		state = "active"
+	}
 
-	return &update.Rollout{
-		AppId:   appId,
-		GroupId: groupId,
-		Rollout: frames,
+	frame := "not started"
+	if s.Frame >= 0 {
+		frame = fmt.Sprintf("%d of %d", s.Frame+1, s.Frames)
 	}
+
+	fmt.Fprintf(out, "Group:\t%s\n", s.GroupId)
+	fmt.Fprintf(out, "Rollout:\t%s\n", state)
+	fmt.Fprintf(out, "Frame:\t%s\n", frame)
+	fmt.Fprintf(out, "Update Percent:\t%g%%\n", s.UpdatePercent)
+	fmt.Fprintf(out, "Elapsed:\t%s\n", time.Duration(s.Elapsed)*time.Second)
+	fmt.Fprintf(out, "Remaining:\t%s\n", time.Duration(s.Remaining)*time.Second)
+	fmt.Fprintf(out, "ETA:\t%s\n", s.ETA.Format(time.RFC3339))
+	fmt.Fprintf(out, "Updated:\t%d of %d instances on %s (%.1f%%)\n",
+		s.Updated, s.Instances, s.Version, s.UpdatedPercent())
 }
 
-func rolloutLinear(args []string, service *update.Service, out *tabwriter.Writer) int {
+func rolloutSupervise(args []string, service *update.Service, out *tabwriter.Writer) int {
 	if rolloutFlags.appId.Get() == nil ||
 		rolloutFlags.groupId.Get() == nil ||
-		rolloutFlags.frameSize == 0 ||
-		rolloutFlags.duration == 0 {
+		rolloutFlags.interval <= 0 ||
+		rolloutFlags.window <= 0 {
+		return ERROR_USAGE
+	}
+
+	s := &rollout.Supervisor{
+		Service:    service,
+		AppId:      rolloutFlags.appId.String(),
+		GroupId:    rolloutFlags.groupId.String(),
+		Version:    rolloutFlags.version,
+		Threshold:  rolloutFlags.threshold,
+		MinUpdates: rolloutFlags.minUpdates,
+		Window:     time.Duration(rolloutFlags.window) * time.Second,
+		Interval:   time.Duration(rolloutFlags.interval) * time.Second,
+		Action:     rolloutFlags.action,
+		DryRun:     rolloutFlags.dryRun,
+		Logf:       log.Printf,
+	}
+
+	health, err := s.Run(context.Background())
+	if err == rollout.ErrRolloutInactive {
+		log.Printf("rollout of group %s is not active, stopping supervision", s.GroupId)
+		return OK
+	} else if err != nil {
+		return handleError(err)
+	}
+
+	err = printResult(out, health, func(out *tabwriter.Writer) {
+		fmt.Fprintln(out, "Version\tUpdated\tFailed\tFailure Rate")
+		fmt.Fprintf(out, "%s\t%d\t%d\t%.1f%%\n",
+			health.Version, health.Successes, health.Failures, health.FailureRate())
+	})
+	if err != nil {
+		return handleError(err)
+	}
+	return OK
+}
+
+func rolloutLinear(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if rolloutFlags.appId.Get() == nil ||
+		rolloutFlags.groupId.Get() == nil {
 		return ERROR_USAGE
 	}
 
-	rollout := generateLinear(rolloutFlags.appId.String(), rolloutFlags.groupId.String(),
+	r, err := rollout.Linear(rolloutFlags.appId.String(), rolloutFlags.groupId.String(),
 		rolloutFlags.frameSize, rolloutFlags.duration)
+	if err != nil {
+		log.Print(err)
+		return ERROR_USAGE
+	}
 
-	call := service.Group.Rollout.Set(
-		rolloutFlags.appId.String(), rolloutFlags.groupId.String(), rollout)
+	return setRollout(service, out, r)
+}
 
-	rollout, err := call.Do()
+func rolloutExponential(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if rolloutFlags.appId.Get() == nil ||
+		rolloutFlags.groupId.Get() == nil {
+		return ERROR_USAGE
+	}
+
+	r, err := rollout.Exponential(rolloutFlags.appId.String(), rolloutFlags.groupId.String(),
+		rolloutFlags.startPercent, rolloutFlags.exponentFrameSize)
+	if err != nil {
+		log.Print(err)
+		return ERROR_USAGE
+	}
+
+	return setRollout(service, out, r)
+}
+
+func rolloutSteps(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if rolloutFlags.appId.Get() == nil ||
+		rolloutFlags.groupId.Get() == nil ||
+		rolloutFlags.steps == "" {
+		return ERROR_USAGE
+	}
+
+	frames, err := rollout.ParseSteps(rolloutFlags.steps)
+	if err != nil {
+		log.Print(err)
+		return ERROR_USAGE
+	}
+
+	r, err := rollout.New(rolloutFlags.appId.String(), rolloutFlags.groupId.String(), frames)
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		return ERROR_USAGE
 	}
 
-	fmt.Fprintf(out, "rollout set\n")
-	out.Flush()
+	return setRollout(service, out, r)
+}
+
+func rolloutCustom(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if rolloutFlags.appId.Get() == nil ||
+		rolloutFlags.groupId.Get() == nil ||
+		rolloutFlags.file == "" {
+		return ERROR_USAGE
+	}
+
+	var in io.Reader = os.Stdin
+	if rolloutFlags.file != "-" {
+		f, err := os.Open(rolloutFlags.file)
+		if err != nil {
+			return handleError(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	frames, err := rollout.ReadFrames(in)
+	if err != nil {
+		log.Printf("%s: %v", rolloutFlags.file, err)
+		return ERROR_USAGE
+	}
+
+	r, err := rollout.New(rolloutFlags.appId.String(), rolloutFlags.groupId.String(), frames)
+	if err != nil {
+		log.Print(err)
+		return ERROR_USAGE
+	}
+
+	return setRollout(service, out, r)
+}
+
+// setRollout replaces the rollout of the group with r, or only prints its
+// schedule with --dry-run.
+func setRollout(service *update.Service, out *tabwriter.Writer, r *update.Rollout) int {
+	if rolloutFlags.dryRun {
+		return previewRollout(service, out, r)
+	}
+
+	call := service.Group.Rollout.Set(r.AppId, r.GroupId, r)
+
+	r, err := call.Do()
+	if err != nil {
+		return handleError(err)
+	}
+
+	err = printResult(out, r, func(out *tabwriter.Writer) {
+		fmt.Fprintf(out, "rollout set\n")
+	})
+	if err != nil {
+		return handleError(err)
+	}
 
 	return OK
 }
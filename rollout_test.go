@@ -1,95 +1,39 @@
 package main
 
 import (
-	"bytes"
+	"io/ioutil"
 	"testing"
+	"text/tabwriter"
 
 	"github.com/coreos/updateservicectl/client/update/v1"
 )
 
-func eq(a, b *update.Rollout) bool {
-	if a.AppId != b.AppId || a.GroupId != b.GroupId {
-		return false
-	}
-	if len(a.Rollout) != len(b.Rollout) {
-		return false
-	}
-	for i, fa := range a.Rollout {
-		fb := b.Rollout[i]
-		if fa.Percent != fb.Percent {
-			return false
-		}
-		if fa.Duration != fb.Duration {
-			return false
-		}
-	}
-	return true
-}
-
-func TestLinearRolloutGeneration(t *testing.T) {
-	appId := "e96281a6-d1af-4bde-9a0a-97b76e56dc57"
-	groupId := "stable"
-	truth := &update.Rollout{
-		AppId:   appId,
-		GroupId: groupId,
-		Rollout: []*update.Frame{
-			&update.Frame{
-				Percent:  10.0,
-				Duration: 10,
-			},
-			&update.Frame{
-				Percent:  20.0,
-				Duration: 10,
-			},
-			&update.Frame{
-				Percent:  30.0,
-				Duration: 10,
-			},
-			&update.Frame{
-				Percent:  40.0,
-				Duration: 10,
-			},
-			&update.Frame{
-				Percent:  50.0,
-				Duration: 10,
-			},
-			&update.Frame{
-				Percent:  60.0,
-				Duration: 10,
-			},
-			&update.Frame{
-				Percent:  70.0,
-				Duration: 10,
-			},
-			&update.Frame{
-				Percent:  80.0,
-				Duration: 10,
-			},
-			&update.Frame{
-				Percent:  90.0,
-				Duration: 10,
-			},
-			&update.Frame{
-				Percent:  100.0,
-				Duration: 10,
-			},
-			// final frame to force 100%
-			&update.Frame{
-				Percent:  100.0,
-				Duration: 0,
-			},
-		},
-	}
+func TestRolloutLinearFlags(t *testing.T) {
+	service, done := newMockService(t)
+	defer done()
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "1.0.0"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable"}).Do()
 
-	rollout := generateLinear(appId, groupId, 10, 100)
+	out := tabwriter.NewWriter(ioutil.Discard, 0, 8, 1, '\t', 0)
+	rolloutFlags.appId.Set("app")
+	rolloutFlags.groupId.Set("prod")
+	defer func() { rolloutFlags.appId, rolloutFlags.groupId = StringFlag{}, StringFlag{} }()
 
-	if !eq(rollout, truth) {
-		// generate nice frame output
-		var e, g bytes.Buffer
-		displayRollout(&e, truth)
-		displayRollout(&g, rollout)
-		t.Error("incorrect rollout generated.\n")
-		t.Errorf("expected:\n%s\n", e.String())
-		t.Errorf("got:\n%s\n", g.String())
+	for _, c := range []struct {
+		frameSize, duration int64
+		exit                int
+	}{
+		{60, 600, OK},
+		{0, 600, ERROR_USAGE},
+		{-60, 600, ERROR_USAGE},
+		{60, 0, ERROR_USAGE},
+		{60, -600, ERROR_USAGE},
+	} {
+		rolloutFlags.frameSize, rolloutFlags.duration = c.frameSize, c.duration
+		if exit := rolloutLinear(nil, service, out); exit != c.exit {
+			t.Errorf("frame size %d, duration %d: expected exit %d, got %d",
+				c.frameSize, c.duration, c.exit, exit)
+		}
 	}
 }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/olekukonko/ts"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+// sparkBlocks are the characters of a sparkline, lowest first.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// chartWidth is the width of the longest bar in rollup charts.
+const chartWidth = 40
+
+// chartMinTimeline is the narrowest timeline drawn in rollup charts.
+const chartMinTimeline = 20
+
+const rollupDescription = `Counts are reported per --resolution seconds between --start and --end,
+given in seconds since the epoch or in RFC3339 format, e.g.
+2016-01-02T15:04:05Z. --since sets the start relative to the end, or to now
+if no end is given.
+
+With --chart, every series is drawn as a sparkline over time followed by its
+total. Intervals are merged when there are more than fit the terminal. With --csv, one record is written per series and timestamp.`
+
+// parseTimeFlag parses a time given as seconds since the epoch or in
+// RFC3339 format.
+func parseTimeFlag(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected seconds since the epoch or RFC3339", s)
+	}
+	return t, nil
+}
+
+// parseDuration parses a Go duration, or a number of days such as "7d",
+// into whole seconds.
+func parseDuration(s string) (int64, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseInt(days, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return n * 24 * 60 * 60, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	if d%time.Second != 0 {
+		return 0, fmt.Errorf("duration %q is not a whole number of seconds", s)
+	}
+	return int64(d / time.Second), nil
+}
+
+// rollupRange returns the start and end of a rollup from the --start, --end
+// and --since flags. --since is a duration before --end, or before now.
+// Unset times are 0 and left to the server.
+func rollupRange(start, end, since *string) (int64, int64, error) {
+	var startTime, endTime time.Time
+	if end != nil {
+		t, err := parseTimeFlag(*end)
+		if err != nil {
+			return 0, 0, err
+		}
+		endTime = t
+	}
+	if start != nil && since != nil {
+		return 0, 0, fmt.Errorf("--start and --since are mutually exclusive")
+	}
+	if start != nil {
+		t, err := parseTimeFlag(*start)
+		if err != nil {
+			return 0, 0, err
+		}
+		startTime = t
+	}
+	if since != nil {
+		d, err := parseDuration(*since)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid --since: %v", err)
+		}
+		if endTime.IsZero() {
+			endTime = time.Now()
+		}
+		startTime = endTime.Add(-time.Duration(d) * time.Second)
+	}
+
+	var startUnix, endUnix int64
+	if !startTime.IsZero() {
+		startUnix = startTime.Unix()
+	}
+	if !endTime.IsZero() {
+		endUnix = endTime.Unix()
+	}
+	return startUnix, endUnix, nil
+}
+
+// formatTimestamp formats a rollup timestamp for humans.
+func formatTimestamp(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+// rollupSeries is the count of one item of a rollup at every timestamp of
+// the rollup.
+type rollupSeries struct {
+	label  string
+	counts []int64
+	total  int64
+}
+
+// rollupTimeline returns the timestamps of all items of a rollup in order,
+// and for every item its counts at those timestamps.
+func rollupTimeline(rollup *update.GroupRequestsRollup, label func(*update.GroupRequestsItem) string) ([]int64, []*rollupSeries) {
+	seen := make(map[int64]bool)
+	var timestamps []int64
+	for _, item := range rollup.Items {
+		for _, v := range item.Values {
+			if !seen[v.Timestamp] {
+				seen[v.Timestamp] = true
+				timestamps = append(timestamps, v.Timestamp)
+			}
+		}
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	index := make(map[int64]int, len(timestamps))
+	for i, ts := range timestamps {
+		index[ts] = i
+	}
+
+	series := make([]*rollupSeries, len(rollup.Items))
+	for i, item := range rollup.Items {
+		s := &rollupSeries{
+			label:  label(item),
+			counts: make([]int64, len(timestamps)),
+		}
+		for _, v := range item.Values {
+			s.counts[index[v.Timestamp]] += v.Count
+			s.total += v.Count
+		}
+		series[i] = s
+	}
+	return timestamps, series
+}
+
+// downsample sums consecutive counts so that at most width remain.
+func downsample(counts []int64, width int) []int64 {
+	if width <= 0 || len(counts) <= width {
+		return counts
+	}
+	per := (len(counts) + width - 1) / width
+	sums := make([]int64, 0, width)
+	for i := 0; i < len(counts); i += per {
+		end := i + per
+		if end > len(counts) {
+			end = len(counts)
+		}
+		var sum int64
+		for _, c := range counts[i:end] {
+			sum += c
+		}
+		sums = append(sums, sum)
+	}
+	return sums
+}
+
+// timelineWidth returns the columns left for the timeline of a chart by
+// the terminal, after the labels, totals and bars.
+func timelineWidth() int {
+	width := 80
+	if size, err := ts.GetSize(); err == nil && size.Col() > 0 {
+		width = size.Col()
+	}
+	if w := width - chartWidth - 30; w > chartMinTimeline {
+		return w
+	}
+	return chartMinTimeline
+}
+
+// sparkline draws counts with one block character per count, scaled to
+// max.
+func sparkline(counts []int64, max int64) string {
+	var b strings.Builder
+	for _, c := range counts {
+		if max <= 0 || c <= 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		i := int(c * int64(len(sparkBlocks)-1) / max)
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
+
+// printRollup writes a rollup as a chart or CSV if requested with --chart or
+// --csv, and with printResult otherwise.
+func printRollup(out *tabwriter.Writer, rollup *update.GroupRequestsRollup, heading string, label func(*update.GroupRequestsItem) string,
+	header []string, fields func(*update.GroupRequestsItem) []string, table func(out *tabwriter.Writer)) error {
+	switch {
+	case groupFlags.csv:
+		defer out.Flush()
+		return writeRollupCSV(out, rollup, header, fields)
+	case groupFlags.chart:
+		defer out.Flush()
+		writeRollupChart(out, rollup, heading, label, timelineWidth())
+		return nil
+	}
+	return printResult(out, rollup, table)
+}
+
+// writeRollupChart writes a sparkline of every item of a rollup over time,
+// followed by a bar of its total. Timelines longer than width are drawn at
+// a coarser resolution.
+func writeRollupChart(out io.Writer, rollup *update.GroupRequestsRollup, heading string, label func(*update.GroupRequestsItem) string, width int) {
+	timestamps, series := rollupTimeline(rollup, label)
+
+	var max, maxTotal int64
+	for _, s := range series {
+		s.counts = downsample(s.counts, width)
+		for _, c := range s.counts {
+			if c > max {
+				max = c
+			}
+		}
+		if s.total > maxTotal {
+			maxTotal = s.total
+		}
+	}
+
+	if len(timestamps) > 0 {
+		fmt.Fprintf(out, "%s to %s\n", formatTimestamp(timestamps[0]), formatTimestamp(timestamps[len(timestamps)-1]))
+	}
+	fmt.Fprintf(out, "%s\tTimeline\tTotal\t\n", heading)
+	for _, s := range series {
+		bar := 0
+		if maxTotal > 0 {
+			bar = int(s.total * chartWidth / maxTotal)
+		}
+		fmt.Fprintf(out, "%s\t%s\t%d\t%s\n", s.label, sparkline(s.counts, max), s.total, strings.Repeat("#", bar))
+	}
+}
+
+// writeRollupCSV writes one record per item and timestamp of a rollup.
+// fields returns the leading fields of an item, matching header.
+func writeRollupCSV(out io.Writer, rollup *update.GroupRequestsRollup, header []string, fields func(*update.GroupRequestsItem) []string) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(append([]string{"timestamp"}, append(header, "count")...)); err != nil {
+		return err
+	}
+	for _, item := range rollup.Items {
+		for _, v := range item.Values {
+			record := append([]string{formatTimestamp(v.Timestamp)}, fields(item)...)
+			record = append(record, strconv.FormatInt(v.Count, 10))
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+func TestRollupRange(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		start, end, since *string
+		expStart, expEnd  int64
+		err               bool
+	}{
+		{nil, nil, nil, 0, 0, false},
+		{str("1000"), str("2000"), nil, 1000, 2000, false},
+		{str("2016-01-02T15:04:05Z"), nil, nil, 1451747045, 0, false},
+		{nil, str("2016-01-02T15:04:05Z"), str("1h"), 1451743445, 1451747045, false},
+		{nil, str("100000"), str("1d"), 13600, 100000, false},
+		{str("1000"), nil, str("1h"), 0, 0, true},
+		{str("yesterday"), nil, nil, 0, 0, true},
+		{nil, nil, str("1x"), 0, 0, true},
+	}
+	for i, tt := range tests {
+		start, end, err := rollupRange(tt.start, tt.end, tt.since)
+		if tt.err {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if start != tt.expStart || end != tt.expEnd {
+			t.Errorf("case %d: expected %d-%d, got %d-%d", i, tt.expStart, tt.expEnd, start, end)
+		}
+	}
+
+	// --since without --end is relative to now
+	start, end, err := rollupRange(nil, nil, str("1h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end-start != 3600 || time.Since(time.Unix(end, 0)) > time.Minute {
+		t.Errorf("expected the last hour, got %d-%d", start, end)
+	}
+}
+
+func TestRollupTimeline(t *testing.T) {
+	rollup := &update.GroupRequestsRollup{
+		Items: []*update.GroupRequestsItem{
+			{Version: "1.0.0", Values: []*update.GroupRequestsValues{
+				{Timestamp: 120, Count: 2},
+				{Timestamp: 60, Count: 8},
+			}},
+			{Version: "2.0.0", Values: []*update.GroupRequestsValues{
+				{Timestamp: 180, Count: 4},
+			}},
+		},
+	}
+	timestamps, series := rollupTimeline(rollup, func(i *update.GroupRequestsItem) string {
+		return i.Version
+	})
+
+	if len(timestamps) != 3 || timestamps[0] != 60 || timestamps[2] != 180 {
+		t.Fatalf("expected timestamps 60, 120 and 180, got %v", timestamps)
+	}
+	if series[0].label != "1.0.0" || series[0].total != 10 {
+		t.Errorf("expected 1.0.0 with 10 in total, got %s with %d", series[0].label, series[0].total)
+	}
+	if got := sparkline(series[0].counts, 8); got != "█▂ " {
+		t.Errorf("expected sparkline %q, got %q", "█▂ ", got)
+	}
+	if got := sparkline(series[1].counts, 8); got != "  ▄" {
+		t.Errorf("expected sparkline %q, got %q", "  ▄", got)
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	counts := []int64{1, 2, 3, 4, 5, 6, 7}
+	for _, c := range []struct {
+		width int
+		want  string
+	}{
+		{10, "[1 2 3 4 5 6 7]"},
+		{7, "[1 2 3 4 5 6 7]"},
+		{4, "[3 7 11 7]"},
+		{3, "[6 15 7]"},
+		{1, "[28]"},
+	} {
+		if got := fmt.Sprint(downsample(counts, c.width)); got != c.want {
+			t.Errorf("width %d: expected %s, got %s", c.width, c.want, got)
+		}
+	}
+
+	// the chart of a long rollup fits the width
+	rollup := &update.GroupRequestsRollup{Items: []*update.GroupRequestsItem{{Version: "1.0.0"}}}
+	for ts := int64(0); ts < 1000; ts++ {
+		rollup.Items[0].Values = append(rollup.Items[0].Values, &update.GroupRequestsValues{Timestamp: ts * 60, Count: 1})
+	}
+	var buf bytes.Buffer
+	writeRollupChart(&buf, rollup, "Version", func(i *update.GroupRequestsItem) string { return i.Version }, 50)
+	lines := strings.Split(buf.String(), "\n")
+	if timeline := strings.Split(lines[2], "\t")[1]; utf8.RuneCountInString(timeline) != 50 {
+		t.Errorf("expected a timeline of 50 columns, got %d", utf8.RuneCountInString(timeline))
+	}
+}
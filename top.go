@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/olekukonko/ts"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/omahaclient"
+)
+
+// ANSI escape sequences used to draw the screen, and sent by arrow keys.
+const (
+	ansiAltScreen  = "\x1b[?1049h"
+	ansiMainScreen = "\x1b[?1049l"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	ansiClear      = "\x1b[H\x1b[2J"
+	ansiReverse    = "\x1b[7m"
+	ansiReset      = "\x1b[0m"
+	ansiClearToEOL = "\x1b[K"
+
+	keyUp   = "\x1b[A"
+	keyDown = "\x1b[B"
+)
+
+const (
+	// topPercentStep is how much + and - change the update percent.
+	topPercentStep = 5
+	// topRollupPeriod is the resolution of the rollups shown, in seconds.
+	topRollupPeriod = 60
+)
+
+var (
+	topFlags struct {
+		appId    StringFlag
+		interval int64
+		window   int64
+	}
+
+	cmdTop = &Command{
+		Name:    "top",
+		Usage:   "[OPTION]...",
+		Summary: "Monitor apps and groups in a full-screen view.",
+		Description: `Show all groups with their update settings, the versions their instances
+reported and the rate of completed and failed updates, refreshed every
+--interval seconds.
+
+Keys:
+	up, k		select the previous group
+	down, j		select the next group
+	p		pause or unpause updates of the selected group
+	+, -		raise or lower the update percent of the selected group by 5
+	r		refresh now
+	q		quit`,
+		Run: top,
+	}
+)
+
+func init() {
+	cmdTop.Flags.Var(&topFlags.appId, "app-id", "Only show the groups of this app.")
+	cmdTop.Flags.Int64Var(&topFlags.interval, "interval", 5, "Seconds between refreshes.")
+	cmdTop.Flags.Int64Var(&topFlags.window, "window", 3600, "Seconds of version and event reports to fetch.")
+}
+
+// topRow is a group as shown by top.
+type topRow struct {
+	appId string
+	group *update.Group
+
+	// versions reported in the latest minute, and the updates completed
+	// and failed in it.
+	versions map[string]int64
+	updates  int64
+	errors   int64
+}
+
+type topModel struct {
+	service  *update.Service
+	rows     []*topRow
+	selected int
+	// offset is the first row shown, moved to keep the selected row on
+	// the screen.
+	offset  int
+	message string
+	updated time.Time
+}
+
+// refresh fetches all groups and their latest reports.
+func (m *topModel) refresh() error {
+	var appIds []string
+	if topFlags.appId.Get() != nil {
+		appIds = append(appIds, topFlags.appId.String())
+	} else {
+		apps, err := m.service.App.List().Do()
+		if err != nil {
+			return err
+		}
+		for _, app := range apps.Items {
+			appIds = append(appIds, app.Id)
+		}
+	}
+
+	end := time.Now()
+	start := end.Add(-time.Duration(topFlags.window) * time.Second)
+
+	var rows []*topRow
+	for _, appId := range appIds {
+		groups, err := m.service.Group.List(appId).Do()
+		if err != nil {
+			return err
+		}
+		for _, group := range groups.Items {
+			row := &topRow{appId: appId, group: group}
+
+			versions, err := m.service.Group.Requests.Versions.Rollup(appId, group.Id, start.Unix(), end.Unix()).
+				Resolution(topRollupPeriod).
+				Do()
+			if err != nil {
+				return err
+			}
+			row.versions = latestCounts(versions, func(item *update.GroupRequestsItem) string {
+				return item.Version
+			})
+
+			events, err := m.service.Group.Requests.Events.Rollup(appId, group.Id, start.Unix(), end.Unix()).
+				Resolution(topRollupPeriod).
+				Do()
+			if err != nil {
+				return err
+			}
+			for key, count := range latestCounts(events, eventKey) {
+				switch key {
+				case omahaclient.EventTypeUpdateComplete + "/" + omahaclient.EventResultSuccess,
+					omahaclient.EventTypeUpdateComplete + "/" + omahaclient.EventResultSuccessReboot:
+					row.updates += count
+				}
+				if strings.HasSuffix(key, "/"+omahaclient.EventResultError) {
+					row.errors += count
+				}
+			}
+
+			rows = append(rows, row)
+		}
+	}
+
+	m.rows = rows
+	if m.selected >= len(m.rows) {
+		m.selected = len(m.rows) - 1
+	}
+	if m.selected < 0 {
+		m.selected = 0
+	}
+	m.updated = end
+	return nil
+}
+
+func eventKey(item *update.GroupRequestsItem) string {
+	return item.Type + "/" + item.Result
+}
+
+// latestCounts sums the counts of the latest interval of a rollup by the
+// key of each item.
+func latestCounts(rollup *update.GroupRequestsRollup, key func(*update.GroupRequestsItem) string) map[string]int64 {
+	var latest int64
+	for _, item := range rollup.Items {
+		for _, v := range item.Values {
+			if v.Timestamp > latest {
+				latest = v.Timestamp
+			}
+		}
+	}
+
+	counts := make(map[string]int64)
+	for _, item := range rollup.Items {
+		for _, v := range item.Values {
+			if v.Timestamp == latest && v.Count > 0 {
+				counts[key(item)] += v.Count
+			}
+		}
+	}
+	return counts
+}
+
+// render draws the screen, cut to the size of the terminal. The rows
+// scroll to keep the selected row visible.
+func (m *topModel) render(w io.Writer, width, height int) {
+	var table bytes.Buffer
+	tw := new(tabwriter.Writer)
+	tw.Init(&table, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "APP\tGROUP\tCHANNEL\tPERCENT\tPAUSED\tROLLOUT\tUPDATED/MIN\tFAILED/MIN\tVERSIONS")
+	for _, row := range m.rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%g%%\t%t\t%t\t%d\t%d\t%s\n",
+			row.appId, row.group.Id, row.group.ChannelId, row.group.UpdatePercent,
+			row.group.UpdatesPaused, row.group.RolloutActive,
+			row.updates, row.errors, formatDistribution(row.versions))
+	}
+	tw.Flush()
+
+	// the title, a blank line and the table header come first
+	rows := strings.Split(strings.TrimRight(table.String(), "\n"), "\n")
+	header := []string{
+		fmt.Sprintf("%s - %s - %d groups - updated %s",
+			cliName, globalFlags.Server, len(m.rows), m.updated.Format("15:04:05")),
+		"",
+		rows[0],
+	}
+	rows = rows[1:]
+
+	// keep the last line for the status
+	visible := height - 1 - len(header)
+	if visible < 1 {
+		visible = 1
+	}
+	if m.selected < m.offset {
+		m.offset = m.selected
+	} else if m.selected >= m.offset+visible {
+		m.offset = m.selected - visible + 1
+	}
+	if max := len(rows) - visible; m.offset > max {
+		m.offset = max
+	}
+	if m.offset < 0 {
+		m.offset = 0
+	}
+	if len(rows) > m.offset+visible {
+		rows = rows[:m.offset+visible]
+	}
+
+	fmt.Fprint(w, ansiClear)
+	for i, line := range append(header, rows[m.offset:]...) {
+		if i >= height-1 {
+			break
+		}
+		if len(line) > width {
+			line = line[:width]
+		}
+		if i >= len(header) && i-len(header)+m.offset == m.selected {
+			line = ansiReverse + line + strings.Repeat(" ", width-len(line)) + ansiReset
+		}
+		fmt.Fprintf(w, "%s%s\r\n", line, ansiClearToEOL)
+	}
+
+	status := m.message
+	if status == "" {
+		status = "q quit  p pause/unpause  +/- percent  r refresh"
+	}
+	if len(status) > width {
+		status = status[:width]
+	}
+	fmt.Fprintf(w, "\x1b[%d;1H%s%s", height, status, ansiClearToEOL)
+}
+
+// handleKey acts on a key press and reports whether top should refresh
+// or quit.
+func (m *topModel) handleKey(key string) (refresh, quit bool) {
+	m.message = ""
+	switch key {
+	case "q":
+		return false, true
+	case "r":
+		return true, false
+	case keyUp, "k":
+		if m.selected > 0 {
+			m.selected--
+		}
+	case keyDown, "j":
+		if m.selected < len(m.rows)-1 {
+			m.selected++
+		}
+	case "p":
+		if row := m.selectedRow(); row != nil {
+			m.setPaused(row, !row.group.UpdatesPaused)
+			return true, false
+		}
+	case "+", "=":
+		if row := m.selectedRow(); row != nil {
+			m.setPercent(row, row.group.UpdatePercent+topPercentStep)
+			return true, false
+		}
+	case "-":
+		if row := m.selectedRow(); row != nil {
+			m.setPercent(row, row.group.UpdatePercent-topPercentStep)
+			return true, false
+		}
+	}
+	return false, false
+}
+
+func (m *topModel) selectedRow() *topRow {
+	if m.selected < 0 || m.selected >= len(m.rows) {
+		return nil
+	}
+	return m.rows[m.selected]
+}
+
+func (m *topModel) setPaused(row *topRow, paused bool) {
+	group, err := m.service.Group.Get(row.appId, row.group.Id).Do()
+	if err != nil {
+		m.message = err.Error()
+		return
+	}
+	group.UpdatesPaused = paused
+	group.ForceSendFields = []string{"UpdatesPaused"}
+	if _, err := m.service.Group.Patch(row.appId, row.group.Id, group).Do(); err != nil {
+		m.message = err.Error()
+		return
+	}
+	if paused {
+		m.message = fmt.Sprintf("paused updates of %s", row.group.Id)
+	} else {
+		m.message = fmt.Sprintf("unpaused updates of %s", row.group.Id)
+	}
+}
+
+func (m *topModel) setPercent(row *topRow, percent float64) {
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+	groupPercent := &update.GroupPercent{
+		UpdatePercent:   percent,
+		ForceSendFields: []string{"UpdatePercent"},
+	}
+	if _, err := m.service.Group.Percent.Set(row.appId, row.group.Id, groupPercent).Do(); err != nil {
+		m.message = err.Error()
+		return
+	}
+	m.message = fmt.Sprintf("set update percent of %s to %g%%", row.group.Id, percent)
+}
+
+// stty runs stty on the terminal connected to stdin.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// readKeys sends key presses read from stdin to keys. Escape sequences of
+// arrow keys arrive in one read and are sent as one key.
+func readKeys(keys chan<- string) {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		s := string(buf[:n])
+		if strings.HasPrefix(s, "\x1b") {
+			keys <- s
+			continue
+		}
+		for _, r := range s {
+			keys <- string(r)
+		}
+	}
+}
+
+func top(args []string, service *update.Service, out *tabwriter.Writer) int {
+	if topFlags.interval <= 0 || topFlags.window <= 0 {
+		return ERROR_USAGE
+	}
+	if machineOutput() {
+		log.Printf("top does not support --output=%s", globalFlags.Output)
+		return ERROR_USAGE
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return handleError(errors.New("top needs a terminal"))
+	}
+
+	m := &topModel{service: service}
+	if err := m.refresh(); err != nil {
+		return handleError(err)
+	}
+
+	state, err := stty("-g")
+	if err != nil {
+		return handleError(fmt.Errorf("reading terminal state: %v", err))
+	}
+	if _, err := stty("cbreak", "-echo"); err != nil {
+		return handleError(fmt.Errorf("setting up terminal: %v", err))
+	}
+	fmt.Print(ansiAltScreen + ansiHideCursor)
+	defer func() {
+		fmt.Print(ansiShowCursor + ansiMainScreen)
+		stty(state)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	keys := make(chan string)
+	go readKeys(keys)
+
+	tick := time.NewTicker(time.Duration(topFlags.interval) * time.Second)
+	defer tick.Stop()
+
+	draw := func() {
+		width, height := 80, 24
+		if size, err := ts.GetSize(); err == nil && size.Col() > 0 && size.Row() > 0 {
+			width, height = size.Col(), size.Row()
+		}
+		var screen bytes.Buffer
+		m.render(&screen, width, height)
+		os.Stdout.Write(screen.Bytes())
+	}
+	refresh := func() {
+		if err := m.refresh(); err != nil {
+			m.message = "refresh failed: " + err.Error()
+		}
+	}
+
+	for {
+		draw()
+		select {
+		case <-signals:
+			return OK
+		case <-tick.C:
+			refresh()
+		case key, ok := <-keys:
+			if !ok {
+				return OK
+			}
+			doRefresh, quit := m.handleKey(key)
+			if quit {
+				return OK
+			}
+			if doRefresh {
+				refresh()
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/updateservicectl/client/update/v1"
+)
+
+func topRows(n int) []*topRow {
+	var rows []*topRow
+	for i := 0; i < n; i++ {
+		rows = append(rows, &topRow{appId: "app", group: &update.Group{Id: fmt.Sprintf("group%d", i)}})
+	}
+	return rows
+}
+
+func TestTopHandleKey(t *testing.T) {
+	service, done := newMockService(t)
+	defer done()
+	service.App.Insert(&update.AppInsertReq{Id: "app"}).Do()
+	service.Channel.Insert("app", &update.ChannelRequest{Label: "stable", Version: "1.0.0"}).Do()
+	service.Group.Insert("app", &update.Group{Id: "prod", ChannelId: "stable", UpdatePercent: 98}).Do()
+
+	m := &topModel{service: service, rows: topRows(3)}
+	for _, c := range []struct {
+		key      string
+		selected int
+	}{
+		{keyUp, 0},
+		{"j", 1},
+		{keyDown, 2},
+		{"j", 2},
+		{"k", 1},
+	} {
+		if refresh, quit := m.handleKey(c.key); refresh || quit || m.selected != c.selected {
+			t.Errorf("key %q: expected row %d to be selected, got %d (refresh %t, quit %t)",
+				c.key, c.selected, m.selected, refresh, quit)
+		}
+	}
+	if refresh, quit := m.handleKey("r"); !refresh || quit {
+		t.Errorf("expected r to refresh")
+	}
+	if _, quit := m.handleKey("q"); !quit {
+		t.Errorf("expected q to quit")
+	}
+
+	group, _ := service.Group.Get("app", "prod").Do()
+	m = &topModel{service: service, rows: []*topRow{{appId: "app", group: group}}}
+	if refresh, _ := m.handleKey("p"); !refresh {
+		t.Errorf("expected pausing to refresh")
+	}
+	if refresh, _ := m.handleKey("+"); !refresh || m.message != "set update percent of prod to 100%" {
+		t.Errorf("expected the percent to be capped at 100, got %q", m.message)
+	}
+	group, _ = service.Group.Get("app", "prod").Do()
+	if !group.UpdatesPaused || group.UpdatePercent != 100 {
+		t.Errorf("expected the group to be paused at 100%%, got %+v", group)
+	}
+}
+
+func TestLatestCounts(t *testing.T) {
+	rollup := &update.GroupRequestsRollup{
+		Items: []*update.GroupRequestsItem{
+			{Version: "1.0.0", Values: []*update.GroupRequestsValues{{Timestamp: 60, Count: 5}, {Timestamp: 120, Count: 2}}},
+			{Version: "2.0.0", Values: []*update.GroupRequestsValues{{Timestamp: 60, Count: 1}, {Timestamp: 120, Count: 3}}},
+			{Version: "3.0.0", Values: []*update.GroupRequestsValues{{Timestamp: 60, Count: 4}, {Timestamp: 120}}},
+		},
+	}
+	counts := latestCounts(rollup, func(item *update.GroupRequestsItem) string {
+		// the major version
+		return item.Version[:1]
+	})
+	if want := map[string]int64{"1": 2, "2": 3}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("expected %v, got %v", want, counts)
+	}
+}
+
+func TestTopRender(t *testing.T) {
+	m := &topModel{rows: topRows(10)}
+
+	// a title, a blank line, the header, 4 rows and the status
+	screen := func() []string {
+		var buf bytes.Buffer
+		m.render(&buf, 80, 8)
+		var groups []string
+		for _, line := range strings.Split(buf.String(), "\r\n") {
+			if i := strings.Index(line, "app "); i >= 0 {
+				group := strings.Fields(line[i:])[1]
+				if strings.Contains(line, ansiReverse) {
+					group = "*" + group
+				}
+				groups = append(groups, group)
+			}
+		}
+		return groups
+	}
+
+	for _, c := range []struct {
+		selected int
+		groups   string
+	}{
+		{0, "*group0 group1 group2 group3"},
+		{3, "group0 group1 group2 *group3"},
+		{6, "group3 group4 group5 *group6"},
+		{9, "group6 group7 group8 *group9"},
+		{4, "*group4 group5 group6 group7"},
+	} {
+		m.selected = c.selected
+		if got := strings.Join(screen(), " "); got != c.groups {
+			t.Errorf("row %d selected: expected %q, got %q", c.selected, c.groups, got)
+		}
+	}
+
+	// rows removed by a refresh do not leave the screen empty
+	m.rows, m.selected = topRows(2), 1
+	if got := strings.Join(screen(), " "); got != "group0 *group1" {
+		t.Errorf("expected the remaining rows, got %q", got)
+	}
+}
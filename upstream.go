@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"text/tabwriter"
 
 	"github.com/coreos/updateservicectl/client/update/v1"
@@ -75,6 +74,13 @@ func formatUpstream(us *update.Upstream) string {
 	return fmt.Sprintf("%s\t%s\t%s\n", us.Id, us.Url, us.Label)
 }
 
+func printUpstream(out *tabwriter.Writer, us *update.Upstream) error {
+	return printResult(out, us, func(out *tabwriter.Writer) {
+		writeUpstreamHeading(out)
+		fmt.Fprintf(out, "%s", formatUpstream(us))
+	})
+}
+
 func upstreamCreate(args []string, service *update.Service, out *tabwriter.Writer) int {
 	if upstreamFlags.url.Get() == nil {
 		return ERROR_USAGE
@@ -88,12 +94,12 @@ func upstreamCreate(args []string, service *update.Service, out *tabwriter.Write
 
 	upstream, err := call.Do()
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	writeUpstreamHeading(out)
-	fmt.Fprintf(out, "%s", formatUpstream(upstream))
-	out.Flush()
+	if err := printUpstream(out, upstream); err != nil {
+		return handleError(err)
+	}
 
 	return OK
 }
@@ -112,12 +118,12 @@ func upstreamUpdate(args []string, service *update.Service, out *tabwriter.Write
 
 	upstream, err := call.Do()
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	writeUpstreamHeading(out)
-	fmt.Fprintf(out, "%s", formatUpstream(upstream))
-	out.Flush()
+	if err := printUpstream(out, upstream); err != nil {
+		return handleError(err)
+	}
 
 	return OK
 }
@@ -130,12 +136,12 @@ func upstreamDelete(args []string, service *update.Service, out *tabwriter.Write
 	call := service.Upstream.Delete(upstreamFlags.id.String())
 	upstream, err := call.Do()
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	writeUpstreamHeading(out)
-	fmt.Fprintf(out, "%s", formatUpstream(upstream))
-	out.Flush()
+	if err := printUpstream(out, upstream); err != nil {
+		return handleError(err)
+	}
 
 	return OK
 }
@@ -144,14 +150,18 @@ func upstreamList(args []string, service *update.Service, out *tabwriter.Writer)
 	call := service.Upstream.List()
 	upstreams, err := call.Do()
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	writeUpstreamHeading(out)
-	for _, us := range upstreams.Items {
-		fmt.Fprintf(out, "%s", formatUpstream(us))
+	err = printResult(out, upstreams, func(out *tabwriter.Writer) {
+		writeUpstreamHeading(out)
+		for _, us := range upstreams.Items {
+			fmt.Fprintf(out, "%s", formatUpstream(us))
+		}
+	})
+	if err != nil {
+		return handleError(err)
 	}
-	out.Flush()
 
 	return OK
 }
@@ -160,14 +170,18 @@ func upstreamSync(args []string, service *update.Service, out *tabwriter.Writer)
 	call := service.Upstream.Sync()
 	resp, err := call.Do()
 	if err != nil {
-		log.Fatal(err)
+		return handleError(err)
 	}
 
-	fmt.Fprintf(out, "Status: %s\n", resp.Status)
-	if resp.Detail != "" {
-		fmt.Fprintf(out, "Detail: %s\n", resp.Detail)
+	err = printResult(out, resp, func(out *tabwriter.Writer) {
+		fmt.Fprintf(out, "Status: %s\n", resp.Status)
+		if resp.Detail != "" {
+			fmt.Fprintf(out, "Detail: %s\n", resp.Detail)
+		}
+	})
+	if err != nil {
+		return handleError(err)
 	}
-	out.Flush()
 
 	return OK
 }
@@ -1,211 +1,296 @@
 package main
 
 import (
-	"bytes"
-	"encoding/xml"
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
-	"path"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
-	"github.com/coreos/go-omaha/omaha"
 	"github.com/pborman/uuid"
 
 	"github.com/coreos/updateservicectl/client/update/v1"
+	"github.com/coreos/updateservicectl/pkg/omahaclient"
+	"github.com/coreos/updateservicectl/pkg/watcher"
 )
 
 var (
 	watchFlags struct {
-		interval int
-		version  string
-		appId    StringFlag
-		groupId  StringFlag
-		clientId string
+		interval        int
+		timeout         int
+		maxBackoff      int
+		version         string
+		appId           StringFlag
+		groupId         StringFlag
+		watch           string
+		clientId        string
+		downloadDir     string
+		downloadTimeout int
+		report          bool
 	}
 	cmdWatch = &Command{
 		Name:    "watch",
 		Usage:   "[OPTION]... <cmd> <args>",
 		Summary: `Watch for app versions and exec a given command.`,
-		Run:     watch,
+		Description: `Check for new versions of an app every --interval seconds and run the given
+command for each, with the version in its environment. Several apps and
+groups can be watched at once by giving --watch a comma separated list of
+app-id:group-id pairs instead of --app-id and --group-id.
+
+Update checks taking longer than --timeout seconds are abandoned. Failed
+checks, including the first one, are retried after a wait which doubles
+with every failure up to --max-backoff seconds, less a random part so that
+many watchers do not retry at once.
+
+With --download-dir, the payload of each new version is downloaded into the
+directory and its size, SHA-1 and SHA-256 sums are checked against the
+update check before the command runs with the path of the file in
+UPDATE_SERVICE_FILE. Downloads taking longer than --download-timeout seconds
+are abandoned. A failed download is retried like a failed check. The version
+running when watch starts is handed to the command without a payload.
+
+Unless --report-events=false, the progress of every update is reported to
+the update service as Omaha events, so it shows in the instance history: the
+download started and finished, then the outcome of the command. A command
+exiting with 0 completes the update; any other exit code is reported as the
+error code of a failed update, which is retried with backoff like a failed
+check. A command killed by a signal is reported with 128 plus the signal
+number, as shells do, and failed downloads with error code 2000. With
+--report-events=false, the exit code of the command is ignored and the
+command runs once per version.
+
+The command stops on SIGINT or SIGTERM, after any running hook exits.`,
+		Run: watch,
 	}
 )
 
 func init() {
 	cmdWatch.Flags.IntVar(&watchFlags.interval, "interval", 1, "Update polling interval")
+	cmdWatch.Flags.IntVar(&watchFlags.timeout, "timeout", 30, "Seconds after which an update check is abandoned.")
+	cmdWatch.Flags.IntVar(&watchFlags.maxBackoff, "max-backoff", 300, "Longest wait in seconds between failed update checks.")
 	cmdWatch.Flags.StringVar(&watchFlags.version, "version", "0.0.0", "Starting version number")
 	cmdWatch.Flags.Var(&watchFlags.appId, "app-id", "Application to watch.")
 	cmdWatch.Flags.Var(&watchFlags.groupId, "group-id", "Group of application to subscribe to.")
+	cmdWatch.Flags.StringVar(&watchFlags.watch, "watch", "", "Comma separated list of app-id:group-id pairs to watch.")
+	cmdWatch.Flags.StringVar(&watchFlags.downloadDir, "download-dir", "", "Directory to download and verify update payloads in before running the command.")
+	cmdWatch.Flags.IntVar(&watchFlags.downloadTimeout, "download-timeout", 1800, "Seconds after which a payload download is abandoned.")
+	cmdWatch.Flags.BoolVar(&watchFlags.report, "report-events", true, "Report the progress and outcome of updates to the update service.")
 	cmdWatch.Flags.StringVar(&watchFlags.clientId, "client-id", "", "Client id to report ad. If not provided a random UUID will be generated.")
 }
 
-func fetchUpdateCheck(server string, appID string, groupID string, clientID string, version string, debug bool) (*omaha.UpdateCheck, error) {
-	client := &http.Client{}
-
-	// TODO: Fill out the OS field correctly based on /etc/os-release
-	request := omaha.NewRequest("lsb", "CoreOS", "", "")
-	app := request.AddApp(fmt.Sprintf("{%s}", appID), version)
-	app.AddUpdateCheck()
-	app.MachineID = clientID
-	app.BootId = uuid.New()
-	app.Track = groupID
-
-	event := app.AddEvent()
-	event.Type = "1"
-	event.Result = "0"
-
-	raw, err := xml.MarshalIndent(request, "", " ")
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return nil, err
-	}
-
-	if debug {
-		fmt.Fprintf(os.Stderr, "Request: %s%s\n", xml.Header, raw)
-	}
+// watchPair is an app and a group to watch.
+type watchPair struct {
+	appId   string
+	groupId string
+}
 
-	u, err := url.Parse(server)
-	if err != nil {
-		return nil, err
+// watchPairs returns the apps and groups given by the flags.
+func watchPairs() ([]watchPair, error) {
+	if watchFlags.watch == "" {
+		if watchFlags.appId.Get() == nil || watchFlags.groupId.Get() == nil {
+			return nil, fmt.Errorf("--app-id and --group-id or --watch are required")
+		}
+		return []watchPair{{watchFlags.appId.String(), watchFlags.groupId.String()}}, nil
 	}
-	u.Path = path.Join(u.Path, "/v1/update")
-
-	resp, err := client.Post(u.String(), "text/xml", bytes.NewReader(raw))
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return nil, err
+	if watchFlags.appId.Get() != nil || watchFlags.groupId.Get() != nil {
+		return nil, fmt.Errorf("--watch cannot be used with --app-id or --group-id")
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return nil, err
-	}
-
-	if debug {
-		fmt.Fprintf(os.Stderr, "Response: %s%s\n", xml.Header, string(body))
+	var pairs []watchPair
+	seen := make(map[watchPair]bool)
+	for _, item := range splitList(watchFlags.watch) {
+		parts := strings.Split(item, ":")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid --watch pair %q, expected app-id:group-id", item)
+		}
+		pair := watchPair{parts[0], parts[1]}
+		if seen[pair] {
+			return nil, fmt.Errorf("%s is watched twice", item)
+		}
+		seen[pair] = true
+		pairs = append(pairs, pair)
 	}
-
-	oresp := &omaha.Response{}
-	err = xml.Unmarshal(body, oresp)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return nil, err
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("--watch is empty")
 	}
-
-	return oresp.Apps[0].UpdateCheck, nil
+	return pairs, nil
 }
 
-func prepareEnvironment(appID string, version string, oldVersion string, updateCheck *omaha.UpdateCheck) []string {
+func prepareEnvironment(u *watcher.Update) []string {
 	env := os.Environ()
-	env = append(env, "UPDATE_SERVICE_VERSION="+version)
-	if oldVersion != "" {
-		env = append(env, "UPDATE_SERVICE_OLD_VERSION="+oldVersion)
+	env = append(env, "UPDATE_SERVICE_VERSION="+u.Version)
+	if u.OldVersion != "" {
+		env = append(env, "UPDATE_SERVICE_OLD_VERSION="+u.OldVersion)
 	}
-	env = append(env, "UPDATE_SERVICE_APP_ID="+appID)
-
-	if updateCheck.Status == "ok" {
-		url, err := url.Parse(updateCheck.Urls.Urls[0].CodeBase)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, err.Error())
-			os.Exit(1)
-		}
+	env = append(env, "UPDATE_SERVICE_APP_ID="+u.AppID)
+	env = append(env, "UPDATE_SERVICE_GROUP_ID="+u.GroupID)
 
-		url.Path = path.Join(url.Path, updateCheck.Manifest.Packages.Packages[0].Name)
-		env = append(env, "UPDATE_SERVICE_URL="+url.String())
+	if u.URL != "" {
+		env = append(env, "UPDATE_SERVICE_URL="+u.URL)
+	}
+	if u.File != "" {
+		env = append(env, "UPDATE_SERVICE_FILE="+u.File)
 	}
 	return env
 }
 
-func runCmd(cmdName string, args []string, appID string, version string, oldVersion string, updateCheck *omaha.UpdateCheck) {
+// runCmd runs the command for an update. A non-zero exit fails the update
+// with the exit code, to be reported and retried, if report is set; it is
+// only logged otherwise.
+func runCmd(cmdName string, args []string, u *watcher.Update, report bool) error {
 	cmd := exec.Command(cmdName, args...)
-	cmd.Env = prepareEnvironment(appID, version, oldVersion, updateCheck)
+	cmd.Env = prepareEnvironment(u)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
-		os.Exit(1)
+		return err
 	}
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
-		os.Exit(1)
+		return err
 	}
 	err = cmd.Start()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
-		os.Exit(1)
+		return err
 	}
 	go io.Copy(os.Stdout, stdout)
 	go io.Copy(os.Stderr, stderr)
-	cmd.Wait()
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if !report {
+			log.Printf("%s failed for version %s: %v", cmdName, u.Version, err)
+			return nil
+		}
+		return &watcher.HookError{Code: exitCode(exitErr), Err: err}
+	}
+	return err
 }
 
-func watch(args []string, service *update.Service, out *tabwriter.Writer) int {
-	tick := time.NewTicker(time.Second * time.Duration(watchFlags.interval))
-	server := globalFlags.Server
-	debug := globalFlags.Debug
-	version := watchFlags.version
+// exitCode returns the exit code of a command, or 128 plus the signal
+// number for a command killed by a signal, as shells do.
+func exitCode(exitErr *exec.ExitError) int {
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
 
-	if watchFlags.appId.Get() == nil || watchFlags.groupId.Get() == nil {
+func watch(args []string, service *update.Service, out *tabwriter.Writer) int {
+	pairs, err := watchPairs()
+	if err != nil {
+		log.Print(err)
 		return ERROR_USAGE
 	}
-
 	if len(args) == 0 {
 		return ERROR_USAGE
 	}
+	if watchFlags.interval <= 0 || watchFlags.timeout < 0 || watchFlags.maxBackoff < 0 || watchFlags.downloadTimeout < 0 {
+		log.Print("--interval must be positive, --timeout, --max-backoff and --download-timeout not negative")
+		return ERROR_USAGE
+	}
+
+	if watchFlags.downloadDir != "" {
+		if err := os.MkdirAll(watchFlags.downloadDir, 0755); err != nil {
+			return handleError(err)
+		}
+	}
 
-	appId := watchFlags.appId.String()
-	groupId := watchFlags.groupId.String()
 	clientId := watchFlags.clientId
 
 	if clientId == "" {
 		clientId = uuid.New()
 	}
 
-	// initial check
-	updateCheck, err := fetchUpdateCheck(server, appId, groupId, clientId, version, debug)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, err.Error())
-		os.Exit(1)
-	}
-
-	if updateCheck.Status != "noupdate" && updateCheck.Status != "error-version" {
-		runCmd(args[0], args[1:], appId, version, "", updateCheck)
-	}
-
-	for {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
 		select {
-		case <-tick.C:
-
-			updateCheck, err := fetchUpdateCheck(server, appId, groupId, clientId, version, debug)
-			if err != nil {
-				log.Printf("warning: update check failed (%v)\n", err)
-				continue
+		case sig := <-signals:
+			log.Printf("received %v, stopping", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	httpClient := &http.Client{Timeout: time.Duration(watchFlags.timeout) * time.Second}
+	downloadClient := &http.Client{Timeout: time.Duration(watchFlags.downloadTimeout) * time.Second}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, pair := range pairs {
+		client := &omahaclient.Client{
+			Server:     globalFlags.Server,
+			HTTPClient: httpClient,
+			AppID:      fmt.Sprintf("{%s}", pair.appId),
+			Version:    watchFlags.version,
+			Track:      pair.groupId,
+			MachineID:  clientId,
+			BootID:     uuid.New(),
+		}
+		if globalFlags.Debug {
+			client.Logf = func(format string, v ...interface{}) {
+				fmt.Fprintf(os.Stderr, format, v...)
 			}
+		}
 
-			if updateCheck.Status == "noupdate" {
-				continue
-			} else if updateCheck.Status == "error-version" {
-				continue
+		logf := log.Printf
+		if len(pairs) > 1 {
+			prefix := pair.appId + ":" + pair.groupId + ": "
+			logf = func(format string, v ...interface{}) {
+				log.Printf(prefix+format, v...)
 			}
+		}
 
-			newVersion := updateCheck.Manifest.Version
+		w := &watcher.Watcher{
+			Client:       client,
+			AppID:        pair.appId,
+			Interval:     time.Second * time.Duration(watchFlags.interval),
+			Timeout:      time.Second * time.Duration(watchFlags.timeout),
+			MaxBackoff:   time.Second * time.Duration(watchFlags.maxBackoff),
+			DownloadDir:  watchFlags.downloadDir,
+			HTTPClient:   downloadClient,
+			ReportEvents: watchFlags.report,
+			Hook: func(ctx context.Context, u *watcher.Update) error {
+				return runCmd(args[0], args[1:], u, watchFlags.report)
+			},
+			Logf: logf,
+		}
 
-			if newVersion != version {
-				runCmd(args[0], args[1:], appId, newVersion, version, updateCheck)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := w.Run(ctx)
+			if err == nil || ctx.Err() != nil {
+				return
 			}
-			version = newVersion
-		}
+			// a failing watcher stops the others
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+			cancel()
+		}()
 	}
+	wg.Wait()
 
-	tick.Stop()
+	if firstErr != nil {
+		return handleError(firstErr)
+	}
 	return OK
 }
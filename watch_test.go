@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/coreos/updateservicectl/pkg/watcher"
+)
+
+func TestRunCmdExitCode(t *testing.T) {
+	u := &watcher.Update{AppID: "app", GroupID: "prod", Version: "2.0.0", OldVersion: "1.0.0"}
+
+	if err := runCmd("sh", []string{"-c", "exit 3"}, u, false); err != nil {
+		t.Errorf("expected the exit code to be ignored without reporting, got %v", err)
+	}
+	err := runCmd("sh", []string{"-c", "exit 3"}, u, true)
+	if hookErr, ok := err.(*watcher.HookError); !ok || hookErr.Code != 3 {
+		t.Errorf("expected the exit code to fail the update, got %v", err)
+	}
+	err = runCmd("sh", []string{"-c", "kill -9 $$"}, u, true)
+	if hookErr, ok := err.(*watcher.HookError); !ok || hookErr.Code != 128+9 {
+		t.Errorf("expected a killed command to fail the update with code 137, got %v", err)
+	}
+	if err := runCmd("sh", []string{"-c", "exit 0"}, u, true); err != nil {
+		t.Errorf("expected success, got %v", err)
+	}
+}